@@ -0,0 +1,339 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/Centny/gwf/util"
+)
+
+//the action of acl rule
+const (
+	ACLAllow = "allow"
+	ACLDeny  = "deny"
+)
+
+//ACLNetworks is the named networks which can be used in cidr of ACLRule.
+var ACLNetworks = map[string][]string{
+	"private":     {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"},
+	"loopback":    {"127.0.0.0/8", "::1/128"},
+	"link-local":  {"169.254.0.0/16", "fe80::/10"},
+	"unspecified": {"0.0.0.0/8", "::/128"},
+}
+
+//ACLRule is the rule to allow or deny the destination of uri.
+//all not empty fields must be matched, and the rule having cidr is matched by the resolved ip of host,
+//the deny rule is matched when any resolved ip is in cidr or resolving fail,
+//the allow rule is matched when all resolved ip is in cidr.
+type ACLRule struct {
+	Name    string   //the rule name
+	Action  string   //the rule action in allow/deny
+	Scheme  string   //the scheme glob, empty is matched all
+	Host    string   //the host glob without port, empty is matched all
+	CIDR    []string //the cidr or named network in ACLNetworks, empty is matched all
+	Port    string   //the port or port range like 1000-2000, empty is matched all
+	nets    []*net.IPNet
+	portMin int64
+	portMax int64
+}
+
+//ACLSchema is the config schema of ACL
+var ACLSchema = NewSchema(
+	&SchemaField{Name: "default", Type: FieldString, Default: ACLAllow, Description: "the default action in allow/deny"},
+	&SchemaField{Name: "rules", Type: FieldMapArray, Description: "the rules matched by order", Schema: NewSchema(
+		&SchemaField{Name: "name", Type: FieldString, Description: "the rule name"},
+		&SchemaField{Name: "action", Type: FieldString, Required: true, Description: "the rule action in allow/deny"},
+		&SchemaField{Name: "scheme", Type: FieldString, Description: "the scheme glob"},
+		&SchemaField{Name: "host", Type: FieldString, Description: "the host glob without port"},
+		&SchemaField{Name: "cidr", Type: FieldStringArray, Description: "the cidr or named network"},
+		&SchemaField{Name: "port", Type: FieldString, Description: "the port or port range like 1000-2000"},
+	)},
+)
+
+//NewACLRule will return new ACLRule by config.
+func NewACLRule(option util.Map) (rule *ACLRule, err error) {
+	rule = &ACLRule{
+		Name:   option.StrVal("name"),
+		Action: option.StrVal("action"),
+		Scheme: option.StrVal("scheme"),
+		Host:   option.StrVal("host"),
+		CIDR:   option.AryStrVal("cidr"),
+		Port:   option.StrVal("port"),
+	}
+	err = rule.parse()
+	return
+}
+
+func (a *ACLRule) parse() (err error) {
+	if a.Action != ACLAllow && a.Action != ACLDeny {
+		err = fmt.Errorf("the acl rule action(%v) is invalid, it must be allow/deny", a.Action)
+		return
+	}
+	a.nets = nil
+	for _, cidr := range a.CIDR {
+		cidrs, ok := ACLNetworks[cidr]
+		if !ok {
+			cidrs = []string{cidr}
+		}
+		for _, c := range cidrs {
+			if !strings.Contains(c, "/") {
+				//single ip
+				if strings.Contains(c, ":") {
+					c += "/128"
+				} else {
+					c += "/32"
+				}
+			}
+			var network *net.IPNet
+			_, network, err = net.ParseCIDR(c)
+			if err != nil {
+				err = fmt.Errorf("the acl rule cidr(%v) is invalid", cidr)
+				return
+			}
+			a.nets = append(a.nets, network)
+		}
+	}
+	a.portMin, a.portMax, err = parsePortRange(a.Port)
+	if err != nil {
+		err = fmt.Errorf("the acl rule port(%v) is invalid", a.Port)
+	}
+	return
+}
+
+//Match will return whether the target uri is matched by rule, the ips is the resolved ip of host.
+func (a *ACLRule) Match(target *url.URL, ips []net.IP) bool {
+	if len(a.Scheme) > 0 && !matchGlob(a.Scheme, target.Scheme) {
+		return false
+	}
+	if len(a.Host) > 0 && !matchGlob(strings.ToLower(a.Host), uriHostname(target)) {
+		return false
+	}
+	if len(a.Port) > 0 {
+		port := uriPort(target)
+		if port < a.portMin || port > a.portMax {
+			return false
+		}
+	}
+	if len(a.nets) < 1 {
+		return true
+	}
+	if len(ips) < 1 {
+		//fail closed when host is not resolved
+		return a.Action == ACLDeny
+	}
+	for _, ip := range ips {
+		contained := a.contains(ip)
+		if a.Action == ACLDeny && contained {
+			return true
+		}
+		if a.Action == ACLAllow && !contained {
+			return false
+		}
+	}
+	return a.Action == ACLAllow
+}
+
+func (a *ACLRule) contains(ip net.IP) bool {
+	for _, network := range a.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ACLRule) String() string {
+	return fmt.Sprintf("%v(%v)", a.Name, a.Action)
+}
+
+//ACLError is the error of uri is denied by ACL
+type ACLError struct {
+	URI  string
+	Rule *ACLRule //the rule which denied uri, it is nil when denied by default policy
+}
+
+func (a *ACLError) Error() string {
+	if a.Rule == nil {
+		return fmt.Sprintf("uri(%v) is denied by acl default policy", a.URI)
+	}
+	return fmt.Sprintf("uri(%v) is denied by acl rule %v", a.URI, a.Rule.Name)
+}
+
+//ACL is the destination access control list, the rules are matched by order and the first matched rule is used.
+//the host is resolved only when having rule with cidr, and the cidr rules are skipped when all matched dialers are LocalDialer.
+//the dialer resolves the host again when dialing, so TCPDialer checks the connecting ip by CheckIP,
+//then the host which is resolved to other ip after Check (dns rebinding) is denied too.
+type ACL struct {
+	Rules   []*ACLRule
+	Default string //the default action when not rule matched, default is allow
+	//the resolver to resolve the host to ip, default is net.DefaultResolver.LookupIPAddr
+	Resolver func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+//NewACL will return new ACL by config like {"default":"deny","rules":[{"action":"allow","host":"*.xx.com"}]}
+func NewACL(option util.Map) (acl *ACL, err error) {
+	acl = &ACL{
+		Default: option.StrVal("default"),
+	}
+	if len(acl.Default) < 1 {
+		acl.Default = ACLAllow
+	}
+	if acl.Default != ACLAllow && acl.Default != ACLDeny {
+		err = fmt.Errorf("the acl default action(%v) is invalid, it must be allow/deny", acl.Default)
+		return
+	}
+	for _, ruleOption := range option.AryMapVal("rules") {
+		var rule *ACLRule
+		rule, err = NewACLRule(ruleOption)
+		if err == nil {
+			err = acl.AddRule(rule)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//AddRule will append the rule to acl
+func (a *ACL) AddRule(rules ...*ACLRule) (err error) {
+	for _, rule := range rules {
+		err = rule.parse()
+		if err != nil {
+			return
+		}
+		if len(rule.Name) < 1 {
+			rule.Name = fmt.Sprintf("rule%v", len(a.Rules))
+		}
+		a.Rules = append(a.Rules, rule)
+	}
+	return
+}
+
+//Check will return nil if the uri is allowed, or *ACLError if it is denied.
+func (a *ACL) Check(ctx context.Context, uri string) (err error) {
+	err = a.check(ctx, uri, true)
+	return
+}
+
+//check will match the uri by rules, the cidr rules are skipped when the uri host is not dialed by network.
+func (a *ACL) check(ctx context.Context, uri string, network bool) (err error) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	var resolve func() []net.IP
+	if network {
+		resolve = func() []net.IP {
+			return a.resolve(ctx, uriHostname(target))
+		}
+	}
+	err = a.match(uri, target, resolve)
+	return
+}
+
+//CheckIP will return nil if the uri is allowed when its host is connected by ip, or *ACLError if it is denied,
+//it is used by dialer to check the connecting ip after the host is resolved.
+func (a *ACL) CheckIP(uri string, ip net.IP) (err error) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	err = a.match(uri, target, func() (ips []net.IP) {
+		if ip != nil {
+			ips = append(ips, ip)
+		}
+		return
+	})
+	return
+}
+
+//match will return the error of first matched deny rule, the ip is resolved by resolve when having cidr rule,
+//and the cidr rules are skipped when resolve is nil.
+func (a *ACL) match(uri string, target *url.URL, resolve func() []net.IP) (err error) {
+	var ips []net.IP
+	resolved := false
+	for _, rule := range a.Rules {
+		if len(rule.nets) > 0 {
+			if resolve == nil {
+				continue
+			}
+			if !resolved {
+				ips = resolve()
+				resolved = true
+			}
+		}
+		if !rule.Match(target, ips) {
+			continue
+		}
+		if rule.Action == ACLDeny {
+			err = &ACLError{URI: uri, Rule: rule}
+		}
+		return
+	}
+	if a.Default == ACLDeny {
+		err = &ACLError{URI: uri}
+	}
+	return
+}
+
+func (a *ACL) resolve(ctx context.Context, host string) (ips []net.IP) {
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+		return
+	}
+	resolver := a.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver.LookupIPAddr
+	}
+	addrs, err := resolver(ctx, host)
+	if err != nil {
+		return
+	}
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return
+}
+
+type aclContextKey struct{}
+
+//withACL will return the context carrying the acl, it is used by dialer to check the connecting ip.
+func withACL(ctx context.Context, acl *ACL) context.Context {
+	return context.WithValue(ctx, aclContextKey{}, acl)
+}
+
+//aclControl will return the Control func of net.Dialer to check the connecting ip by the acl in context,
+//it return nil if the context is not having acl.
+func aclControl(ctx context.Context, uri string) func(network, address string, c syscall.RawConn) error {
+	acl, ok := ctx.Value(aclContextKey{}).(*ACL)
+	if !ok || acl == nil {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) (err error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return
+		}
+		if idx := strings.LastIndex(host, "%"); idx > 0 {
+			//remove the ipv6 zone
+			host = host[:idx]
+		}
+		err = acl.CheckIP(uri, net.ParseIP(host))
+		return
+	}
+}
+
+//networkDialers will return whether any dialer is dialing the uri host by network.
+func networkDialers(dialers []Dialer) bool {
+	for _, dialer := range dialers {
+		if local, ok := dialer.(LocalDialer); !ok || !local.Local() {
+			return true
+		}
+	}
+	return false
+}
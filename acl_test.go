@@ -0,0 +1,226 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestACL(t *testing.T) {
+	acl, err := NewACL(util.Map{
+		"default": "deny",
+		"rules": []util.Map{
+			{
+				"name":   "echo",
+				"action": "allow",
+				"scheme": "tcp",
+				"host":   "echo",
+			},
+			{
+				"name":   "internal",
+				"action": "deny",
+				"cidr":   []string{"private", "loopback", "link-local", "unspecified", "1.1.1.1"},
+			},
+			{
+				"action": "allow",
+				"host":   "*.example.com",
+				"port":   "80-443",
+			},
+			{
+				"action": "allow",
+				"scheme": "http*",
+				"cidr":   []string{"8.8.0.0/16"},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	acl.Resolver = func(ctx context.Context, host string) (addrs []net.IPAddr, err error) {
+		switch host {
+		case "www.example.com":
+			addrs = []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}
+		case "rebind.example.com":
+			addrs = []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.0.0.1")}}
+		case "dns.google":
+			addrs = []net.IPAddr{{IP: net.ParseIP("8.8.8.8")}, {IP: net.ParseIP("8.8.4.4")}}
+		case "mixed.google":
+			addrs = []net.IPAddr{{IP: net.ParseIP("8.8.8.8")}, {IP: net.ParseIP("9.9.9.9")}}
+		default:
+			err = fmt.Errorf("not found")
+		}
+		return
+	}
+	for uri, allowed := range map[string]bool{
+		"tcp://echo":                      true,
+		"tcp://ECHO":                      true,
+		"tcp://echo.":                     true,
+		"http://WWW.Example.com.":         true,
+		"tcp://127.0.0.1:80":              false,
+		"tcp://[::1]:80":                  false,
+		"http://169.254.169.254/latest":   false,
+		"tcp://0.0.0.0:22":                false,
+		"tcp://192.168.1.1:22":            false,
+		"tcp://1.1.1.1:53":                false,
+		"http://www.example.com":          true,
+		"https://www.example.com":         true,
+		"tcp://www.example.com:22":        false,
+		"http://rebind.example.com":       false,
+		"http://unknown.example.com":      false,
+		"http://dns.google":               true,
+		"tcp://dns.google:53":             false,
+		"http://mixed.google":             false,
+		"tcp://93.184.216.34:80":          false,
+		"https://[fe80::1%25eth0]:443/xx": false,
+	} {
+		err = acl.Check(context.Background(), uri)
+		if allowed != (err == nil) {
+			t.Errorf("%v->%v", uri, err)
+			return
+		}
+		if err != nil {
+			if ClassifyError(err) != ErrClassDenied {
+				t.Error(err)
+				return
+			}
+			fmt.Println(err)
+		}
+	}
+	if acl.Rules[2].Name != "rule2" || acl.Rules[1].String() != "internal(deny)" {
+		t.Error(acl.Rules)
+		return
+	}
+	//default allow
+	acl, _ = NewACL(util.Map{})
+	if acl.Check(context.Background(), "tcp://127.0.0.1:80") != nil {
+		t.Error("error")
+		return
+	}
+	//host is matched by lowercase and without trailing dot
+	acl, _ = NewACL(util.Map{"rules": []util.Map{{"action": "deny", "host": "metadata.internal"}}})
+	for _, uri := range []string{"tcp://metadata.internal:80", "tcp://METADATA.internal:80", "tcp://metadata.internal.:80"} {
+		if acl.Check(context.Background(), uri) == nil {
+			t.Error(uri)
+			return
+		}
+	}
+	//
+	//test error
+	for _, option := range []util.Map{
+		{"default": "xx"},
+		{"rules": []util.Map{{"action": "xx"}}},
+		{"rules": []util.Map{{"action": "deny", "cidr": []string{"xx"}}}},
+		{"rules": []util.Map{{"action": "deny", "port": "100-1"}}},
+		{"rules": []util.Map{{"action": "deny", "port": "x"}}},
+	} {
+		_, err = NewACL(option)
+		if err == nil {
+			t.Error(option)
+			return
+		}
+	}
+	err = acl.Check(context.Background(), "%EX")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPoolACL(t *testing.T) {
+	pool := NewPool()
+	err := pool.Bootstrap(util.Map{
+		"echo": 1,
+		"tcp":  1,
+		"acl": util.Map{
+			"rules": []util.Map{
+				{
+					"action": "allow",
+					"host":   "echo",
+				},
+				{
+					"action": "deny",
+					"cidr":   []string{"loopback"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := pool.Dial(1, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+	_, err = pool.Dial(2, "tcp://"+l.Addr().String(), nil)
+	if _, ok := err.(*ACLError); !ok {
+		t.Error(err)
+		return
+	}
+	_, err = pool.Dial(2, "tcp://localhost:"+fmt.Sprintf("%v", l.Addr().(*net.TCPAddr).Port), nil)
+	if _, ok := err.(*ACLError); !ok {
+		t.Error(err)
+		return
+	}
+	//the host resolved to other ip when dialing (dns rebinding) is denied by the connecting ip
+	pool.ACL.Resolver = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+	}
+	if pool.ACL.Check(context.Background(), "tcp://localhost:80") != nil {
+		t.Error("error")
+		return
+	}
+	_, err = pool.Dial(3, "tcp://localhost:"+fmt.Sprintf("%v", l.Addr().(*net.TCPAddr).Port), nil)
+	if ClassifyError(err) != ErrClassDenied {
+		t.Error(err)
+		return
+	}
+	explain := pool.Explain("tcp://" + l.Addr().String())
+	if len(explain.Chosen) > 0 || len(explain.Error) < 1 {
+		t.Error(explain)
+		return
+	}
+	//the cidr rules are skipped for local dialer
+	pool = NewPool()
+	err = pool.Bootstrap(util.Map{
+		"echo": 1,
+		"acl": util.Map{
+			"rules": []util.Map{{"action": "deny", "cidr": []string{"private", "loopback"}}},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err = pool.Dial(4, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	if pool.ACL.Check(context.Background(), "tcp://echo") == nil {
+		t.Error("error")
+		return
+	}
+	//
+	//test error
+	err = NewPool().Bootstrap(util.Map{
+		"acl": util.Map{"default": "xx"},
+	})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
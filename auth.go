@@ -0,0 +1,323 @@
+package dialer
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Centny/gwf/util"
+)
+
+//Authorizer is the interface to decide whether the sid can dial the uri by dialer, it is called by Pool before dialing.
+type Authorizer interface {
+	//return nil if authorized, the *AuthError is recommended for denied.
+	Authorize(sid uint64, uri string, dialer string) error
+}
+
+//AuthorizerF is the func implementation of Authorizer
+type AuthorizerF func(sid uint64, uri string, dialer string) error
+
+//Authorize will call the func
+func (a AuthorizerF) Authorize(sid uint64, uri string, dialer string) error {
+	return a(sid, uri, dialer)
+}
+
+//AuthError is the error of sid is not authorized to dial uri by dialer.
+type AuthError struct {
+	SID    uint64
+	URI    string
+	Dialer string
+}
+
+func (a *AuthError) Error() string {
+	return fmt.Sprintf("sid(%v) is not authorized to dial uri(%v) by dialer(%v)", a.SID, a.URI, a.Dialer)
+}
+
+//sidRange is the sid range like 1-100, the * is all sid
+type sidRange struct {
+	min, max uint64
+}
+
+func parseSIDRange(sid string) (r sidRange, err error) {
+	sid = strings.TrimSpace(sid)
+	if sid == "*" {
+		r.min, r.max = 0, ^uint64(0)
+		return
+	}
+	parts := strings.SplitN(sid, "-", 2)
+	r.min, err = strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 64)
+	if err == nil && len(parts) > 1 {
+		r.max, err = strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+	} else {
+		r.max = r.min
+	}
+	if err != nil || r.min > r.max {
+		err = fmt.Errorf("the sid range(%v) is invalid", sid)
+	}
+	return
+}
+
+func (s sidRange) contains(sid uint64) bool {
+	return sid >= s.min && sid <= s.max
+}
+
+//StaticRule is the rule to allow the sid in range or having label to dial uri.
+type StaticRule struct {
+	Name    string   //the rule name
+	SID     string   //the sid range like 1-100, * is all sid, empty is not matched by sid
+	Label   string   //the label bound to sid, empty is not matched by label
+	Allow   []string //the allowed uri glob, like tcp://cmd*, see uriPattern
+	Deny    []string //the denied uri glob, it is checked before allow
+	Dialers []string //the allowed dialer names, empty is all dialers
+	sids    sidRange
+	allows  []*uriPattern
+	denies  []*uriPattern
+}
+
+//uriPattern is the uri glob like scheme://host:port/path?query, the parts are matched separately by glob,
+//so the userinfo or query of uri can't bypass the host glob. the omitted or empty part is matched any,
+//the first ? is the query separator, and the pattern without :// is matched by scheme only, like * or tcp.
+type uriPattern struct {
+	scheme, host, port, path, query string
+}
+
+func parseURIPattern(pattern string) (p *uriPattern) {
+	p = &uriPattern{}
+	idx := strings.Index(pattern, "://")
+	if idx < 0 {
+		p.scheme = strings.ToLower(pattern)
+		return
+	}
+	p.scheme = strings.ToLower(pattern[:idx])
+	rest := pattern[idx+3:]
+	if idx = strings.Index(rest, "?"); idx >= 0 {
+		rest, p.query = rest[:idx], rest[idx+1:]
+	}
+	if idx = strings.Index(rest, "/"); idx >= 0 {
+		rest, p.path = rest[:idx], rest[idx:]
+	}
+	if idx = strings.LastIndex(rest, ":"); idx >= 0 && idx > strings.LastIndex(rest, "]") {
+		rest, p.port = rest[:idx], rest[idx+1:]
+	}
+	rest = strings.TrimSuffix(strings.TrimPrefix(rest, "["), "]")
+	p.host = strings.TrimSuffix(strings.ToLower(rest), ".")
+	return
+}
+
+//Match will return whether the target uri is matched by all parts of pattern.
+func (u *uriPattern) Match(target *url.URL) bool {
+	port := target.Port()
+	if len(port) < 1 {
+		if val := uriPort(target); val > 0 {
+			port = strconv.FormatInt(val, 10)
+		}
+	}
+	return matchPart(u.scheme, target.Scheme) && matchPart(u.host, uriHostname(target)) &&
+		matchPart(u.port, port) && matchPart(u.path, target.Path) && matchPart(u.query, target.RawQuery)
+}
+
+func matchPart(pattern, value string) bool {
+	return len(pattern) < 1 || matchGlob(pattern, value)
+}
+
+//StaticAuthorizerSchema is the config schema of StaticAuthorizer
+var StaticAuthorizerSchema = NewSchema(
+	&SchemaField{Name: "labels", Type: FieldMap, Schema: &Schema{Open: true}, Description: "the label to sid range list"},
+	&SchemaField{Name: "rules", Type: FieldMapArray, Description: "the allow rules", Schema: NewSchema(
+		&SchemaField{Name: "name", Type: FieldString, Description: "the rule name"},
+		&SchemaField{Name: "sid", Type: FieldString, Description: "the sid range like 1-100, * is all sid"},
+		&SchemaField{Name: "label", Type: FieldString, Description: "the label bound to sid"},
+		&SchemaField{Name: "allow", Type: FieldStringArray, Description: "the allowed uri glob"},
+		&SchemaField{Name: "deny", Type: FieldStringArray, Description: "the denied uri glob"},
+		&SchemaField{Name: "dialers", Type: FieldStringArray, Description: "the allowed dialer names"},
+	)},
+)
+
+//NewStaticRule will return new StaticRule by config.
+func NewStaticRule(option util.Map) (rule *StaticRule, err error) {
+	rule = &StaticRule{
+		Name:    option.StrVal("name"),
+		SID:     option.StrVal("sid"),
+		Label:   option.StrVal("label"),
+		Allow:   option.AryStrVal("allow"),
+		Deny:    option.AryStrVal("deny"),
+		Dialers: option.AryStrVal("dialers"),
+	}
+	err = rule.parse()
+	return
+}
+
+func (s *StaticRule) parse() (err error) {
+	if len(s.SID) < 1 && len(s.Label) < 1 {
+		err = fmt.Errorf("the static rule sid or label is required")
+		return
+	}
+	if len(s.SID) > 0 {
+		s.sids, err = parseSIDRange(s.SID)
+	}
+	s.allows, s.denies = nil, nil
+	for _, pattern := range s.Allow {
+		s.allows = append(s.allows, parseURIPattern(pattern))
+	}
+	for _, pattern := range s.Deny {
+		s.denies = append(s.denies, parseURIPattern(pattern))
+	}
+	return
+}
+
+//Match will return whether the rule is applied to the sid having labels.
+func (s *StaticRule) Match(sid uint64, labels []string) bool {
+	if len(s.SID) > 0 && s.sids.contains(sid) {
+		return true
+	}
+	if len(s.Label) > 0 {
+		for _, label := range labels {
+			if label == s.Label {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Allowed will return whether the uri is allowed to dial by dialer, the rule must be parsed by NewStaticRule or AddRule.
+func (s *StaticRule) Allowed(uri string, dialer string) bool {
+	target, err := url.Parse(uri)
+	return err == nil && s.allowed(target, dialer)
+}
+
+func (s *StaticRule) allowed(target *url.URL, dialer string) bool {
+	if len(s.Dialers) > 0 {
+		found := false
+		for _, name := range s.Dialers {
+			if name == dialer {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, pattern := range s.denies {
+		if pattern.Match(target) {
+			return false
+		}
+	}
+	for _, pattern := range s.allows {
+		if pattern.Match(target) {
+			return true
+		}
+	}
+	return false
+}
+
+//StaticAuthorizer is an implementation of the Authorizer interface by static rules,
+//the uri is authorized when any rule applied to the sid allows it, otherwise it is denied.
+type StaticAuthorizer struct {
+	Rules     []*StaticRule
+	labels    map[uint64][]string
+	ranges    map[string][]sidRange
+	labelsLck sync.RWMutex
+}
+
+//NewStaticAuthorizer will return new StaticAuthorizer by config like:
+//	{
+//		"labels": {"admin": ["1", "100-200"]},
+//		"rules": [
+//			{"label": "admin", "allow": ["*"]},
+//			{"sid": "*", "allow": ["tcp://*"], "deny": ["tcp://cmd*"]}
+//		]
+//	}
+func NewStaticAuthorizer(option util.Map) (auth *StaticAuthorizer, err error) {
+	auth = &StaticAuthorizer{
+		labels:    map[uint64][]string{},
+		ranges:    map[string][]sidRange{},
+		labelsLck: sync.RWMutex{},
+	}
+	labels := option.MapVal("labels")
+	for label := range labels {
+		for _, sid := range labels.AryStrVal(label) {
+			var r sidRange
+			r, err = parseSIDRange(sid)
+			if err != nil {
+				return
+			}
+			auth.ranges[label] = append(auth.ranges[label], r)
+		}
+	}
+	for _, ruleOption := range option.AryMapVal("rules") {
+		var rule *StaticRule
+		rule, err = NewStaticRule(ruleOption)
+		if err == nil {
+			err = auth.AddRule(rule)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//AddRule will append the rule to authorizer
+func (s *StaticAuthorizer) AddRule(rules ...*StaticRule) (err error) {
+	for _, rule := range rules {
+		err = rule.parse()
+		if err != nil {
+			return
+		}
+		if len(rule.Name) < 1 {
+			rule.Name = fmt.Sprintf("rule%v", len(s.Rules))
+		}
+		s.Rules = append(s.Rules, rule)
+	}
+	return
+}
+
+//Bind will bind the labels to sid, it is used by host application to bind the identity and roles on runtime.
+func (s *StaticAuthorizer) Bind(sid uint64, labels ...string) {
+	s.labelsLck.Lock()
+	s.labels[sid] = append(s.labels[sid], labels...)
+	s.labelsLck.Unlock()
+}
+
+//Unbind will remove all labels bound to sid by Bind
+func (s *StaticAuthorizer) Unbind(sid uint64) {
+	s.labelsLck.Lock()
+	delete(s.labels, sid)
+	s.labelsLck.Unlock()
+}
+
+//Labels will return all labels of sid, which is bound by Bind or configured by sid range.
+func (s *StaticAuthorizer) Labels(sid uint64) (labels []string) {
+	s.labelsLck.RLock()
+	labels = append(labels, s.labels[sid]...)
+	s.labelsLck.RUnlock()
+	for label, ranges := range s.ranges {
+		for _, r := range ranges {
+			if r.contains(sid) {
+				labels = append(labels, label)
+				break
+			}
+		}
+	}
+	return
+}
+
+//Authorize will return nil if any rule applied to sid allows the uri, or *AuthError if denied.
+func (s *StaticAuthorizer) Authorize(sid uint64, uri string, dialer string) (err error) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	labels := s.Labels(sid)
+	for _, rule := range s.Rules {
+		if rule.Match(sid, labels) && rule.allowed(target, dialer) {
+			return
+		}
+	}
+	err = &AuthError{SID: sid, URI: uri, Dialer: dialer}
+	return
+}
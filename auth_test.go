@@ -0,0 +1,191 @@
+package dialer
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestStaticAuthorizer(t *testing.T) {
+	auth, err := NewStaticAuthorizer(util.Map{
+		"labels": util.Map{
+			"admin": []string{"1", "100-200"},
+		},
+		"rules": []util.Map{
+			{
+				"label": "admin",
+				"allow": []string{"*"},
+			},
+			{
+				"name":    "user",
+				"sid":     "*",
+				"allow":   []string{"tcp://*"},
+				"deny":    []string{"tcp://cmd*"},
+				"dialers": []string{"tcp", "echo"},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	auth.Bind(10, "admin")
+	for _, c := range []struct {
+		SID     uint64
+		URI     string
+		Dialer  string
+		Allowed bool
+	}{
+		{1, "tcp://cmd?exec=bash", "cmd", true},
+		{150, "tcp://cmd?exec=bash", "cmd", true},
+		{10, "tcp://cmd?exec=bash", "cmd", true},
+		{11, "tcp://cmd?exec=bash", "cmd", false},
+		{11, "tcp://cmd?exec=bash", "tcp", false},
+		{11, "tcp://x@cmd?exec=id", "tcp", false},
+		{11, "tcp://x:y@CMD.?exec=id", "tcp", false},
+		{11, "tcp://echo?x=cmd", "echo", true},
+		{11, "tcp://echo", "echo", true},
+		{11, "tcp://echo", "web", false},
+		{11, "http://web?dir=/", "web", false},
+	} {
+		err = auth.Authorize(c.SID, c.URI, c.Dialer)
+		if c.Allowed != (err == nil) {
+			t.Errorf("%v->%v", c, err)
+			return
+		}
+		if err != nil {
+			if ClassifyError(err) != ErrClassDenied {
+				t.Error(err)
+				return
+			}
+			fmt.Println(err)
+		}
+	}
+	auth.Unbind(10)
+	if auth.Authorize(10, "tcp://cmd?exec=bash", "cmd") == nil {
+		t.Error("error")
+		return
+	}
+	if auth.Rules[0].Name != "rule0" || auth.Rules[1].Name != "user" {
+		t.Error(auth.Rules)
+		return
+	}
+	//
+	//test error
+	for _, option := range []util.Map{
+		{"labels": util.Map{"admin": []string{"x"}}},
+		{"labels": util.Map{"admin": []string{"10-1"}}},
+		{"rules": []util.Map{{"allow": []string{"*"}}}},
+		{"rules": []util.Map{{"sid": "x"}}},
+	} {
+		_, err = NewStaticAuthorizer(option)
+		if err == nil {
+			t.Error(option)
+			return
+		}
+	}
+	err = auth.Authorize(1, "%EX", "tcp")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPoolAuthorizer(t *testing.T) {
+	pool := NewPool()
+	err := pool.Bootstrap(util.Map{
+		"echo":     1,
+		"fallback": []string{"denied"},
+		"dialers": []util.Map{
+			{
+				"type": "echo",
+			},
+		},
+		"authorizer": util.Map{
+			"rules": []util.Map{
+				{
+					"sid":   "1-10",
+					"allow": []string{"tcp://echo"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := pool.Dial(1, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	_, err = pool.Dial(11, "tcp://echo", nil)
+	if derr, ok := err.(*DialError); !ok || len(derr.Failures) != 2 || derr.Failures[1].Class != ErrClassDenied {
+		t.Error(err)
+		return
+	}
+	if len(pool.Stats()) != 1 || pool.Stats()[0].Dials != 1 {
+		t.Error(util.S2Json(pool.Stats()))
+		return
+	}
+	//authorizer func
+	pool.Fallback = nil
+	pool.Authorizer = AuthorizerF(func(sid uint64, uri string, dialer string) error {
+		if sid == 100 {
+			return nil
+		}
+		return &AuthError{SID: sid, URI: uri, Dialer: dialer}
+	})
+	_, err = pool.Dial(1, "tcp://echo", nil)
+	if _, ok := err.(*AuthError); !ok {
+		t.Error(err)
+		return
+	}
+	conn, err = pool.Dial(100, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	//
+	//test error
+	err = NewPool().Bootstrap(util.Map{
+		"authorizer": util.Map{"rules": []util.Map{{}}},
+	})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestURIPattern(t *testing.T) {
+	for _, c := range []struct {
+		Pattern string
+		URI     string
+		Matched bool
+	}{
+		{"*", "tcp://cmd?exec=bash", true},
+		{"tcp", "tcp://cmd?exec=bash", true},
+		{"http", "tcp://cmd?exec=bash", false},
+		{"tcp://cmd*", "tcp://x@cmd?exec=id", true},
+		{"tcp://cmd*", "tcp://echo?x=cmd", false},
+		{"tcp://*.example.com", "tcp://www.example.com.evil.net", false},
+		{"tcp://*.example.com", "tcp://WWW.Example.com.:22", true},
+		{"tcp://*.example.com:22", "tcp://www.example.com:23", false},
+		{"http://*.example.com:80", "http://www.example.com/x", true},
+		{"tcp://[::1]:22", "tcp://[::1]:22", true},
+		{"tcp://cmd?exec=bash", "tcp://cmd?exec=bash", true},
+		{"tcp://cmd?exec=bash", "tcp://cmd?exec=sh", false},
+		{"http://web/dir/*", "http://web/dir/x", true},
+		{"http://web/dir/*", "http://web/etc/x", false},
+	} {
+		target, _ := url.Parse(c.URI)
+		if parseURIPattern(c.Pattern).Match(target) != c.Matched {
+			t.Errorf("%v->%v", c.Pattern, c.URI)
+			return
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/url"
 	"regexp"
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/Centny/gwf/log"
@@ -64,6 +66,8 @@ type BalancedDialer struct {
 	Timeout         int64
 	Conf            util.Map
 	matcher         *regexp.Regexp
+	stats           statsTable
+	shutdown        uint32
 }
 
 func NewBalancedDialer() *BalancedDialer {
@@ -129,6 +133,26 @@ func (b *BalancedDialer) Name() string {
 	return b.ID
 }
 
+//BalancedDialerSchema is the config schema of BalancedDialer
+var BalancedDialerSchema = NewSchema(
+	&SchemaField{Name: "id", Type: FieldString, Required: true, Description: "the dialer name"},
+	&SchemaField{Name: "matcher", Type: FieldString, Default: ".*", Description: "the regexp to match uri"},
+	&SchemaField{Name: "timeout", Type: FieldInt, Default: 3000, Description: "the timeout of waiting free dialer in millisecond"},
+	&SchemaField{Name: "delay", Type: FieldInt, Default: 500, Description: "the delay of retrying dialer in millisecond"},
+	&SchemaField{Name: "policy", Type: FieldMapArray, Description: "the limit policy of host", Schema: NewSchema(
+		&SchemaField{Name: "matcher", Type: FieldString, Required: true, Description: "the regexp to match host"},
+		&SchemaField{Name: "limit", Type: FieldIntArray, Required: true, Description: "the limit of [time, count]"},
+	)},
+	&SchemaField{Name: "filter", Type: FieldMapArray, Description: "the access filter of uri", Schema: NewSchema(
+		&SchemaField{Name: "matcher", Type: FieldString, Required: true, Description: "the regexp to match uri"},
+		&SchemaField{Name: "access", Type: FieldInt, Description: "the access value"},
+	)},
+	&SchemaField{Name: "dialers", Type: FieldDialers, Description: "the balanced dialers", Schema: NewSchema(
+		&SchemaField{Name: "limit", Type: FieldIntArray, Description: "the limit of [time, count]"},
+		&SchemaField{Name: "fail_remove", Type: FieldInt, Default: 0, Description: "remove dialer after fail times"},
+	)},
+)
+
 //initial dialer
 func (b *BalancedDialer) Bootstrap(options util.Map) (err error) {
 	b.Conf = options
@@ -137,9 +161,17 @@ func (b *BalancedDialer) Bootstrap(options util.Map) (err error) {
 		err = fmt.Errorf("the dialer id is required")
 		return
 	}
+	err = BalancedDialerSchema.Validate("", options, false)
+	if err != nil {
+		return
+	}
 	matcher := options.StrVal("matcher")
 	if len(matcher) > 0 {
 		b.matcher, err = regexp.Compile(matcher)
+		if err != nil {
+			err = fmt.Errorf("compile matcher(%v) fail with %v", matcher, err)
+			return
+		}
 	}
 	b.Timeout = options.IntValV("timeout", 3000)
 	b.Delay = options.IntValV("delay", 500)
@@ -166,7 +198,7 @@ func (b *BalancedDialer) Bootstrap(options util.Map) (err error) {
 		dtype := option.StrVal("type")
 		dialer := NewDialer(dtype)
 		if dialer == nil {
-			return fmt.Errorf("create dialer fail with type(%v) not supported by %v", dtype, util.S2Json(option))
+			return fmt.Errorf("create dialer fail with type(%v) not registered by %v", dtype, util.S2Json(option))
 		}
 		err := dialer.Bootstrap(option)
 		if err != nil {
@@ -186,12 +218,43 @@ func (b *BalancedDialer) Options() util.Map {
 	return b.Conf
 }
 
+//Shutdown will refuse the new dial and shutdown all balanced dialers, it return the first error of shutting down dialer.
+func (b *BalancedDialer) Shutdown(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapUint32(&b.shutdown, 0, 1) {
+		return
+	}
+	<-b.dialersLock
+	dialers := []Dialer{}
+	for _, dialer := range b.dialers {
+		dialers = append(dialers, dialer)
+	}
+	b.dialersLock <- 1
+	for _, dialer := range dialers {
+		if shutdowner, ok := dialer.(Shutdowner); ok {
+			if serr := shutdowner.Shutdown(ctx); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	}
+	return
+}
+
 //Matched uri
 func (b *BalancedDialer) Matched(uri string) bool {
 	return b.matcher.MatchString(uri)
 }
 
 func (b *BalancedDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
+	r, err = b.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext will dial uri by the balanced dialers with context, it will stop retry when context is done.
+func (b *BalancedDialer) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
+	if atomic.LoadUint32(&b.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
 	for _, f := range b.Filters {
 		if f.Matcher.MatchString(uri) {
 			if f.Access < 1 {
@@ -215,6 +278,10 @@ func (b *BalancedDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (
 			err = fmt.Errorf("dial to %v timeout", uri)
 			break
 		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
 		<-b.dialersLock
 		//do dialer limit
 		sortedNames := b.sortedDialer(1)
@@ -288,7 +355,9 @@ func (b *BalancedDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (
 			used[1]++
 			hostUsed[1]++
 			b.dialersLock <- 1
-			r, err = dialer.Dial(sid, uri, pipe)
+			dialBegin := time.Now()
+			r, err = DialContext(ctx, dialer, sid, uri, pipe)
+			b.stats.dialed(name, time.Since(dialBegin), err, connStats(r))
 			<-b.dialersLock
 			if err == nil {
 				used[2] = 0
@@ -297,6 +366,12 @@ func (b *BalancedDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (
 				log.D("BalancedDialer dail to %v with dialer(%v) success", uri, dialer)
 				return
 			}
+			if ctx.Err() != nil {
+				//canceled is not the dialer fail
+				err = ctx.Err()
+				b.dialersLock <- 1
+				return
+			}
 			failed[name]++
 			used[2]++
 			hostUsed[2]++
@@ -315,7 +390,123 @@ func (b *BalancedDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (
 			log.D("BalancedDialer dial to %v is waiting to connect", uri)
 			showed = now
 		}
-		time.Sleep(time.Duration(b.Delay) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(b.Delay) * time.Millisecond):
+		}
+	}
+	return
+}
+
+//Stats will return the accounting of all child dialers
+func (b *BalancedDialer) Stats() []*DialerStats {
+	return b.stats.Snapshot()
+}
+
+//BalancedUsage is the usage of child dialer on BalancedDialer, it is the usage of dialer on all hosts if Host is empty.
+type BalancedUsage struct {
+	Dialer string
+	Host   string
+	Begin  int64 //the begin time of limit window in millisecond
+	Used   int64 //the used count in limit window
+	Fail   int64 //the continuous fail count
+}
+
+//Usage will return the usage of all child dialers sorted by dialer name and host.
+func (b *BalancedDialer) Usage() (usages []*BalancedUsage) {
+	<-b.dialersLock
+	defer func() {
+		b.dialersLock <- 1
+	}()
+	var names []string
+	for name := range b.dialersUsed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		used := b.dialersUsed[name]
+		usages = append(usages, &BalancedUsage{Dialer: name, Begin: used[0], Used: used[1], Fail: used[2]})
+		var hosts []string
+		for host := range b.dialersHostUsed[name] {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			used = b.dialersHostUsed[name][host]
+			usages = append(usages, &BalancedUsage{Dialer: name, Host: host, Begin: used[0], Used: used[1], Fail: used[2]})
+		}
+	}
+	return
+}
+
+//BalancedExplain is the dry-run decision of BalancedDialer
+type BalancedExplain struct {
+	Filter   string                  //the matched filter
+	Access   bool                    //whether the uri is accessable by filter
+	Policy   string                  //the matched policy
+	Chosen   string                  //the child dialer name which would be chosen
+	Error    string                  //the error message when uri is invalid
+	Children []*BalancedChildExplain //the child dialers sorted by used
+}
+
+//BalancedChildExplain is the dry-run decision of the child dialer on BalancedDialer
+type BalancedChildExplain struct {
+	Name      string
+	Matched   bool    //whether the Matched of child dialer accept the uri
+	Limit     []int64 //the limit config as [time,limit]
+	Used      []int64 //the used as [begin,used,fail]
+	Limited   bool    //whether the child dialer is excluded by limit
+	HostUsed  []int64 //the used on uri host as [begin,used,fail]
+	Policied  bool    //whether the child dialer is excluded by policy
+	Available bool    //whether the child dialer would be tried
+}
+
+//Explain will return the decision of filter, policy and limit on uri without dialing.
+func (b *BalancedDialer) Explain(uri string) (explain *BalancedExplain) {
+	explain = &BalancedExplain{Access: true}
+	for _, f := range b.Filters {
+		if f.Matcher.MatchString(uri) {
+			explain.Filter = f.Matcher.String()
+			explain.Access = f.Access > 0
+			break
+		}
+	}
+	target, err := url.Parse(uri)
+	if err != nil {
+		explain.Error = err.Error()
+		return
+	}
+	var policy *BalancedPolicy
+	for _, p := range b.PolicyList {
+		if p.Matcher.MatchString(uri) {
+			policy = p
+			explain.Policy = p.Matcher.String()
+			break
+		}
+	}
+	<-b.dialersLock
+	defer func() {
+		b.dialersLock <- 1
+	}()
+	now := util.Now()
+	for _, name := range b.sortedDialer(1) {
+		dialer := b.dialers[name]
+		child := &BalancedChildExplain{
+			Name:     name,
+			Matched:  dialer.Matched(uri),
+			Limit:    dialer.Options().AryInt64Val("limit"),
+			Used:     append([]int64{}, b.dialersUsed[name]...),
+			HostUsed: append([]int64{}, b.dialersHostUsed[name][target.Host]...),
+		}
+		child.Limited = len(child.Limit) > 1 && now-child.Used[0] <= child.Limit[0] && child.Used[1] >= child.Limit[1]
+		if policy != nil && len(child.HostUsed) > 2 {
+			child.Policied = now-child.HostUsed[0] <= policy.Limit[0] && child.HostUsed[1] >= policy.Limit[1]
+		}
+		child.Available = explain.Access && child.Matched && !child.Limited && !child.Policied
+		if child.Available && len(explain.Chosen) < 1 {
+			explain.Chosen = name
+		}
+		explain.Children = append(explain.Children, child)
 	}
 	return
 }
@@ -1,6 +1,7 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"sync"
@@ -71,9 +72,8 @@ func (o *OnceDialer) String() string {
 }
 
 func TestBalancedDialerDefaul(t *testing.T) {
-	NewDialer = func(t string) Dialer {
-		return &OnceDialer{}
-	}
+	RegisterDialerType("once", func() Dialer { return &OnceDialer{} }, "testing once dialer")
+	defer UnregisterDialerType("once")
 	dialer := NewBalancedDialer()
 	err := dialer.Bootstrap(util.Map{
 		"id":      "t1",
@@ -120,7 +120,6 @@ func TestBalancedDialerDefaul(t *testing.T) {
 		return
 	}
 	fmt.Println("--->", err)
-	NewDialer = DefaultDialerCreator
 	//
 	//
 	dialer = NewBalancedDialer()
@@ -259,12 +258,8 @@ func (t *TimeDialer) String() string {
 }
 
 func TestBalancedDialerPolicy(t *testing.T) {
-	NewDialer = func(t string) Dialer {
-		return &TimeDialer{}
-	}
-	defer func() {
-		NewDialer = DefaultDialerCreator
-	}()
+	RegisterDialerType("time", func() Dialer { return &TimeDialer{} }, "testing time dialer")
+	defer UnregisterDialerType("time")
 	dialer := NewBalancedDialer()
 	err := dialer.Bootstrap(util.Map{
 		"id":      "t1",
@@ -327,12 +322,8 @@ func TestBalancedDialerPolicy(t *testing.T) {
 }
 
 func TestBalancedDialerLimit(t *testing.T) {
-	NewDialer = func(t string) Dialer {
-		return &TimeDialer{}
-	}
-	defer func() {
-		NewDialer = DefaultDialerCreator
-	}()
+	RegisterDialerType("time", func() Dialer { return &TimeDialer{} }, "testing time dialer")
+	defer UnregisterDialerType("time")
 	dialer := NewBalancedDialer()
 	err := dialer.Bootstrap(util.Map{
 		"id":      "t1",
@@ -391,6 +382,34 @@ func TestBalancedDialerLimit(t *testing.T) {
 	wg.Wait()
 }
 
+type FailDialer struct {
+	OnceDialer
+}
+
+func (f *FailDialer) Matched(uri string) bool {
+	return true
+}
+
+func (f *FailDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
+	err = fmt.Errorf("fail")
+	return
+}
+
+func TestBalancedDialerContext(t *testing.T) {
+	dialer := NewBalancedDialer()
+	dialer.Timeout = 10000
+	dialer.Delay = 20
+	dialer.AddDialer(&FailDialer{OnceDialer{ID: "f0", conf: util.Map{}}})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := dialer.DialContext(ctx, 10, "tcp://xx:100", nil)
+	if err != context.DeadlineExceeded || time.Since(begin) > time.Second {
+		t.Errorf("%v,%v", err, time.Since(begin))
+		return
+	}
+}
+
 func TestXX(t *testing.T) {
 	xx := make(chan int, 1)
 	xx <- 1
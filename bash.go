@@ -113,6 +113,28 @@ func (c *Cmd) Read(p []byte) (n int, err error) {
 	return
 }
 
+//Resize will change the window size of the command terminal.
+func (c *Cmd) Resize(rows, cols int) (err error) {
+	c.Rows, c.Cols = rows, cols
+	if c.pipe == nil {
+		return
+	}
+	//not using Fd() to keep the pipe non-blocking when reading
+	raw, err := c.pipe.SyscallConn()
+	if err != nil {
+		return
+	}
+	var ws WinSize
+	ws.Row, ws.Col = uint16(rows), uint16(cols)
+	cerr := raw.Control(func(fd uintptr) {
+		err = SetWindowRect(&ws, fd)
+	})
+	if cerr != nil {
+		err = cerr
+	}
+	return
+}
+
 //Close the command.
 func (c *Cmd) Close() error {
 	c.pipe.Close()
@@ -200,6 +222,26 @@ func SetFileWinSize(t *os.File, rows, cols int) (err error) {
 	return
 }
 
+//ResizeConn is the interface to change the window size of remote terminal, like the cmd session.
+type ResizeConn interface {
+	Resize(rows, cols int) error
+}
+
+//ResizeFileTo will resize conn to the window size of local terminal file,
+//it return error if conn is not ResizeConn or t is not a terminal.
+func ResizeFileTo(conn interface{}, t *os.File) (err error) {
+	resizer, ok := conn.(ResizeConn)
+	if !ok {
+		err = fmt.Errorf("%v is not supported resize", conn)
+		return
+	}
+	rows, cols, err := GetFileWinSize(t)
+	if err == nil {
+		err = resizer.Resize(rows, cols)
+	}
+	return
+}
+
 type WinSize struct {
 	Row    uint16
 	Col    uint16
@@ -36,3 +36,41 @@ func SetWindowRect(ws *WinSize, fd uintptr) error {
 	}
 	return nil
 }
+
+func ioctlTermios(fd, req uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		fd,
+		req,
+		uintptr(unsafe.Pointer(termios)),
+	)
+	if errno != 0 {
+		return syscall.Errno(errno)
+	}
+	return nil
+}
+
+//MakeRaw will put the terminal of fd into raw mode, the restore func is used to restore the old state.
+func MakeRaw(fd uintptr) (restore func() error, err error) {
+	var old syscall.Termios
+	err = ioctlTermios(fd, syscall.TIOCGETA, &old)
+	if err != nil {
+		return
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = ioctlTermios(fd, syscall.TIOCSETA, &raw)
+	if err != nil {
+		return
+	}
+	restore = func() error {
+		return ioctlTermios(fd, syscall.TIOCSETA, &old)
+	}
+	return
+}
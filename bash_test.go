@@ -59,3 +59,67 @@ func TestGetSize(t *testing.T) {
 		return
 	}
 }
+
+func TestMakeRawAndResize(t *testing.T) {
+	pty, vty, err := pty.Open()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		pty.Close()
+		vty.Close()
+	}()
+	restore, err := MakeRaw(vty.Fd())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = restore()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = MakeRaw(os.Stdout.Fd())
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//resize
+	err = SetFileWinSize(vty, 30, 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cmd := NewCmd("n1", "", "bash")
+	err = ResizeFileTo(&CombinedRWC{Resizer: cmd.Resize}, vty)
+	if err != nil || cmd.Rows != 30 || cmd.Cols != 100 {
+		t.Errorf("%v,%v,%v", cmd.Rows, cmd.Cols, err)
+		return
+	}
+	err = ResizeFileTo(&CombinedRWC{}, vty)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	err = ResizeFileTo(&ReusableRWC{Raw: &CombinedRWC{}}, vty)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	err = ResizeFileTo(&ReusableRWC{Raw: NewEchoReadWriteCloser()}, vty)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	err = ResizeFileTo(NewEchoReadWriteCloser(), vty)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	err = ResizeFileTo(&CombinedRWC{Resizer: cmd.Resize}, os.Stdout)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
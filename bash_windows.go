@@ -1,6 +1,7 @@
 package dialer
 
 import (
+	"fmt"
 	"os/exec"
 )
 
@@ -17,3 +18,9 @@ func GetWindowRect(ws *WinSize, fd uintptr) error {
 func SetWindowRect(ws *WinSize, fd uintptr) error {
 	return nil
 }
+
+//MakeRaw is not supported on windows
+func MakeRaw(fd uintptr) (restore func() error, err error) {
+	err = fmt.Errorf("raw mode is not supported on windows")
+	return
+}
@@ -7,12 +7,20 @@ export PATH=$PATH:$GOPATH/bin:$HOME/bin:$GOROOT/bin
 ##############################
 ######Install Dependence######
 echo "Installing Dependence"
+go get github.com/Centny/gwf/...
+go get github.com/kr/pty
+go get golang.org/x/net/webdav
+go get golang.org/x/text/...
+go get gopkg.in/yaml.v3
 ##############################
 #########Running Clear#########
 #########Running Test#########
 echo "Running Test"
 pkgs="\
  github.com/sutils/dialer\
+ github.com/sutils/dialer/metrics\
+ github.com/sutils/dialer/cmd/dialerd\
+ github.com/sutils/dialer/cmd/dialer\
 "
 
 echo "mode: set" > a.out
@@ -2,6 +2,7 @@ package dialer
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/url"
@@ -55,31 +56,33 @@ func (c *CmdStdinWriter) Write(p []byte) (n int, err error) {
 
 //CmdDialer is an implementation of the Dialer interface for dial command
 type CmdDialer struct {
-	Replace     []byte
-	CloseTag    []byte
-	PS1         string
-	Dir         string
-	LC          string
-	Prefix      string
-	Env         []string
-	Reuse       int64
-	ReuseDelay  time.Duration
-	running     map[string]*ReusableRWC
-	runningLck  sync.RWMutex
-	loopRunning bool
-	conf        util.Map
+	Replace    []byte
+	CloseTag   []byte
+	PS1        string
+	Dir        string
+	LC         string
+	Prefix     string
+	Env        []string
+	Reuse      int64
+	ReuseDelay time.Duration
+	running    map[string]*ReusableRWC
+	runningLck sync.RWMutex
+	loopStop   chan int
+	loopDone   chan int
+	shutdown   uint32
+	conf       util.Map
 }
 
 //NewCmdDialer will return new CmdDialer
 func NewCmdDialer() *CmdDialer {
 	cmd := &CmdDialer{
-		CloseTag:    nil,
-		running:     map[string]*ReusableRWC{},
-		runningLck:  sync.RWMutex{},
-		Reuse:       3600000,
-		ReuseDelay:  30 * time.Second,
-		loopRunning: true,
-		conf:        util.Map{},
+		CloseTag:   nil,
+		running:    map[string]*ReusableRWC{},
+		runningLck: sync.RWMutex{},
+		Reuse:      3600000,
+		ReuseDelay: 30 * time.Second,
+		loopStop:   make(chan int),
+		conf:       util.Map{},
 	}
 	if runtime.GOOS == "windows" {
 		// cmd.Replace = []byte("\r")
@@ -92,9 +95,12 @@ func (c *CmdDialer) Name() string {
 	return "cmd"
 }
 
-func (c *CmdDialer) loopReuse() {
+func (c *CmdDialer) loopReuse(done chan int) {
+	defer close(done)
 	log.D("CmdDailer the reuse time loop is starting")
-	for c.loopRunning {
+	timer := time.NewTimer(c.ReuseDelay)
+	defer timer.Stop()
+	for {
 		c.runningLck.Lock()
 		now := util.Now()
 		for name, reused := range c.running {
@@ -104,14 +110,35 @@ func (c *CmdDialer) loopReuse() {
 			}
 		}
 		c.runningLck.Unlock()
-		time.Sleep(c.ReuseDelay)
+		select {
+		case <-c.loopStop:
+			log.D("CmdDailer the reuse time loop is stopped")
+			return
+		case <-timer.C:
+			timer.Reset(c.ReuseDelay)
+		}
 	}
-	log.D("CmdDailer the reuse time loop is stopped")
 }
 
+//CmdDialerSchema is the config schema of CmdDialer
+var CmdDialerSchema = NewSchema(
+	&SchemaField{Name: "PS1", Type: FieldString, Description: "the PS1 environment of command"},
+	&SchemaField{Name: "Dir", Type: FieldString, Description: "the working directory of command"},
+	&SchemaField{Name: "LC", Type: FieldString, Description: "the LC_ALL environment of command"},
+	&SchemaField{Name: "Prefix", Type: FieldString, Description: "the command to run before exec"},
+	&SchemaField{Name: "Env", Type: FieldMap, Schema: &Schema{Open: true}, Description: "the environment of command"},
+	&SchemaField{Name: "reuse", Type: FieldInt, Default: 3600000, Description: "the reuse timeout of command in millisecond"},
+	&SchemaField{Name: "reuse_delay", Type: FieldInt, Default: 30000, Description: "the delay of checking reuse timeout in millisecond"},
+).Merge(PipeSchema)
+
 //Bootstrap the dilaer
 func (c *CmdDialer) Bootstrap(options util.Map) error {
+	err := CmdDialerSchema.Validate("", options, false)
+	if err != nil {
+		return err
+	}
 	if options != nil {
+		c.conf = options
 		c.PS1 = options.StrVal("PS1")
 		c.Dir = options.StrVal("Dir")
 		c.LC = options.StrVal("LC")
@@ -122,8 +149,9 @@ func (c *CmdDialer) Bootstrap(options util.Map) error {
 		c.Reuse = options.IntValV("reuse", 3600000)
 		c.ReuseDelay = time.Duration(options.IntValV("reuse_delay", 30000)) * time.Millisecond
 	}
-	if c.Reuse > 0 {
-		go c.loopReuse()
+	if c.Reuse > 0 && c.loopDone == nil {
+		c.loopDone = make(chan int)
+		go c.loopReuse(c.loopDone)
 	}
 	return nil
 }
@@ -141,6 +169,10 @@ func (c *CmdDialer) Matched(uri string) bool {
 func (c *CmdDialer) onCmdPaused(r *ReusableRWC) {
 	c.runningLck.Lock()
 	defer c.runningLck.Unlock()
+	if atomic.LoadUint32(&c.shutdown) == 1 {
+		r.Destory()
+		return
+	}
 	c.running[r.Name] = r
 	r.Last = util.Now()
 	log.D("CmdDialer add session to reuse by %v->%p", r.Name, r)
@@ -148,10 +180,28 @@ func (c *CmdDialer) onCmdPaused(r *ReusableRWC) {
 
 //Dial will start command and pipe to stdin/stdout
 func (c *CmdDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	raw, err = c.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext will start command and pipe to stdin/stdout with context
+func (c *CmdDialer) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	if atomic.LoadUint32(&c.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
 	remote, err := url.Parse(uri)
 	if err != nil {
 		return
 	}
+	config, err := NewPipeConfig(c.conf, remote.Query())
+	if err != nil {
+		return
+	}
 	reuse := remote.Query().Get("reuse")
 	var reusable *ReusableRWC
 	if len(reuse) > 0 {
@@ -173,6 +223,7 @@ func (c *CmdDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw C
 	var cmdWriter io.Writer
 	var cmdCloser func() error
 	var cmdStart func() error
+	var cmdResizer func(rows, cols int) error
 	switch runtime.GOOS {
 	case "windows":
 		cmd := exec.Command("cmd", "/C", runnable)
@@ -211,6 +262,7 @@ func (c *CmdDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw C
 			case "Dir":
 			case "LC":
 			case "exec":
+			case "half_close", "idle_timeout", "max_lifetime", "rate_up", "rate_down":
 			default:
 				cmd.Raw.Env = append(cmd.Raw.Env, fmt.Sprintf("%v=%v", key, vals[0]))
 			}
@@ -221,6 +273,7 @@ func (c *CmdDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw C
 		cmdWriter = cmd
 		cmdCloser = cmd.Close
 		cmdStart = cmd.Start
+		cmdResizer = cmd.Resize
 	}
 	//
 	lc := remote.Query().Get("LC")
@@ -248,12 +301,14 @@ func (c *CmdDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw C
 			Closer: cmdCloser,
 		}
 	}
+	combined.Resizer = cmdResizer
 	err = cmdStart()
 	if err == nil {
 		reusable = NewReusableRWC(combined)
 		reusable.Name = reuse
 		reusable.Reused = len(reuse) > 0 && c.Reuse > 0
 		reusable.OnPaused = c.onCmdPaused
+		reusable.Config = config
 		raw = reusable
 		if pipe != nil {
 			err = reusable.Pipe(pipe)
@@ -262,12 +317,34 @@ func (c *CmdDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw C
 	return
 }
 
-//Shutdown the dialer
-func (c *CmdDialer) Shutdown() (err error) {
-	c.loopRunning = false
+//Shutdown will stop the reuse time loop and destroy all paused commands, the new dial is refused.
+//it is changed from Shutdown() to implement Shutdowner, the caller of old api should call Shutdown(context.Background()).
+func (c *CmdDialer) Shutdown(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapUint32(&c.shutdown, 0, 1) {
+		return
+	}
+	close(c.loopStop)
+	c.runningLck.Lock()
+	for name, reused := range c.running {
+		reused.Destory()
+		delete(c.running, name)
+	}
+	c.runningLck.Unlock()
+	if c.loopDone != nil {
+		select {
+		case <-c.loopDone:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
 	return
 }
 
+//Local will return true, the command is started on local host.
+func (c *CmdDialer) Local() bool {
+	return true
+}
+
 func (c *CmdDialer) String() string {
 	return "Cmd"
 }
@@ -276,8 +353,19 @@ func (c *CmdDialer) String() string {
 type CombinedRWC struct {
 	io.Reader
 	io.Writer
-	Closer func() error
-	closed uint32
+	Closer  func() error
+	Resizer func(rows, cols int) error
+	closed  uint32
+}
+
+//Resize will call resizer to change the window size, it return error if resizer is not set.
+func (c *CombinedRWC) Resize(rows, cols int) (err error) {
+	if c.Resizer == nil {
+		err = fmt.Errorf("CombinedRWC is not supported resize")
+		return
+	}
+	err = c.Resizer(rows, cols)
+	return
 }
 
 //Close will call closer only once
@@ -294,18 +382,21 @@ func (c *CombinedRWC) Close() (err error) {
 //ReusableRWC
 type ReusableRWC struct {
 	Raw      io.ReadWriteCloser
+	Config   *PipeConfig
 	paused   uint32
 	piped    uint32
 	Name     string
 	Reused   bool
 	Last     int64
 	OnPaused func(r *ReusableRWC)
+	stats    *ConnStats
 }
 
 func NewReusableRWC(raw io.ReadWriteCloser) (reusable *ReusableRWC) {
 	reusable = &ReusableRWC{
-		Raw:  raw,
-		Last: util.Now(),
+		Raw:   raw,
+		Last:  util.Now(),
+		stats: NewConnStats(),
 	}
 	return
 }
@@ -367,17 +458,33 @@ func (r *ReusableRWC) Destory() (err error) {
 
 func (r *ReusableRWC) Pipe(raw io.ReadWriteCloser) (err error) {
 	if atomic.CompareAndSwapUint32(&r.piped, 0, 1) {
-		go r.copyAndClose(r, raw)
-		go r.copyAndClose(raw, r)
+		pipeCopy(r, raw, r.stats, r.Config)
 	} else {
 		err = fmt.Errorf("piped")
 	}
 	return
 }
 
-func (r *ReusableRWC) copyAndClose(src io.ReadWriteCloser, dst io.ReadWriteCloser) {
+//Resize will change the window size of raw if it is ResizeConn
+func (r *ReusableRWC) Resize(rows, cols int) (err error) {
+	resizer, ok := r.Raw.(ResizeConn)
+	if !ok {
+		err = fmt.Errorf("ReusableRWC raw is not supported resize")
+		return
+	}
+	err = resizer.Resize(rows, cols)
+	return
+}
+
+//Stats will return the accounting of piped connection
+func (r *ReusableRWC) Stats() *ConnStats {
+	return r.stats
+}
 
-	io.Copy(dst, src)
-	dst.Close()
-	src.Close()
+//PipeConfig will return the config of piping, it is created if not set.
+func (r *ReusableRWC) PipeConfig() *PipeConfig {
+	if r.Config == nil {
+		r.Config = &PipeConfig{}
+	}
+	return r.Config
 }
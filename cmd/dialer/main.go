@@ -0,0 +1,159 @@
+//Command dialer is the netcat-style client to bridge stdin/stdout to the uri which is dialed by the Pool of config.
+//
+//the local terminal is put into raw mode when dialing to cmd, and the window size changes are forwarded to remote.
+//it can be used to debug the dialer config, or as ssh ProxyCommand.
+//
+//usage:
+//	dialer -config dialer.yml 'tcp://cmd?exec=bash'
+//	dialer -config dialer.yml socks-id://host:22
+//	ssh -o ProxyCommand='dialer -config dialer.yml tcp://%h:%p' host
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/sutils/dialer"
+)
+
+//stdio is the io.ReadWriteCloser to wrap stdin/stdout, the done channel is closed when it is closed.
+type stdio struct {
+	io.Reader
+	io.Writer
+	done chan struct{}
+	once sync.Once
+}
+
+func newStdio(r io.Reader, w io.Writer) *stdio {
+	return &stdio{
+		Reader: r,
+		Writer: w,
+		done:   make(chan struct{}),
+	}
+}
+
+//Close will mark the stdio is done, the stdin/stdout is not closed.
+func (s *stdio) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+//client is the running dialer client
+type client struct {
+	Pool  *dialer.Pool
+	Conn  dialer.Conn
+	stdio *stdio
+}
+
+//isCmdURI will return true if the uri is dialing to cmd, like tcp://cmd?exec=bash
+func isCmdURI(uri string) bool {
+	remote, err := url.Parse(uri)
+	return err == nil && remote.Host == "cmd"
+}
+
+//start will load config and bootstrap pool, then dial uri with the stdio of in/out.
+func start(filename string, sid uint64, uri string, in io.Reader, out io.Writer) (c *client, err error) {
+	config, err := dialer.LoadConfig(filename)
+	if err != nil {
+		return
+	}
+	c = &client{
+		Pool:  dialer.NewPool(),
+		stdio: newStdio(in, out),
+	}
+	err = config.Bootstrap(c.Pool)
+	if err != nil {
+		return
+	}
+	c.Conn, err = c.Pool.Dial(sid, uri, c.stdio)
+	if err != nil {
+		c.Pool.Shutdown(context.Background())
+	}
+	return
+}
+
+//Done will return the channel which is closed when the session is closed.
+func (c *client) Done() <-chan struct{} {
+	return c.stdio.done
+}
+
+//stop will close the session and shutdown the pool.
+func (c *client) stop(ctx context.Context) (err error) {
+	c.Conn.Close()
+	err = c.Pool.Shutdown(ctx)
+	return
+}
+
+//forwardResize will resize the remote to local terminal when window size is changed until done.
+func forwardResize(conn dialer.Conn, terminal *os.File, done <-chan struct{}) {
+	err := dialer.ResizeFileTo(conn, terminal)
+	if err != nil {
+		log.D("dialer resize %v fail with %v", conn, err)
+		return
+	}
+	changed := make(chan os.Signal, 1)
+	notifyResize(changed)
+	defer stopResize(changed)
+	for {
+		select {
+		case <-changed:
+			err = dialer.ResizeFileTo(conn, terminal)
+			if err != nil {
+				log.W("dialer resize %v fail with %v", conn, err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func main() {
+	filename := flag.String("config", "dialer.yml", "the JSON/YAML config file of pool")
+	sid := flag.Uint64("sid", uint64(os.Getpid()), "the session id of dialing")
+	raw := flag.Bool("raw", false, "put the local terminal into raw mode, it is always enabled when dialing to cmd")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %v [options] <uri>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	uri := flag.Arg(0)
+	//the raw mode must be applied before stdin is piped, so the first input is not read in cooked mode
+	var restore func() error
+	if *raw || isCmdURI(uri) {
+		var err error
+		restore, err = dialer.MakeRaw(os.Stdin.Fd())
+		if err != nil {
+			log.D("dialer make raw terminal fail with %v", err)
+			restore = nil
+		}
+	}
+	c, err := start(*filename, *sid, uri, os.Stdin, os.Stdout)
+	if err != nil {
+		if restore != nil {
+			restore()
+		}
+		fmt.Fprintf(os.Stderr, "dialer dial to %v fail with %v\n", uri, err)
+		os.Exit(1)
+	}
+	if restore != nil {
+		defer restore()
+		go forwardResize(c.Conn, os.Stdin, c.Done())
+	}
+	<-c.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	c.stop(ctx)
+}
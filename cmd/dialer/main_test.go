@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kr/pty"
+	"github.com/sutils/dialer"
+)
+
+func writeConfig(dir, data string) (filename string, err error) {
+	filename = filepath.Join(dir, "dialer.yml")
+	err = ioutil.WriteFile(filename, []byte(data), os.ModePerm)
+	return
+}
+
+//syncBuffer is the output of client which is safe to read when writing
+type syncBuffer struct {
+	bytes.Buffer
+	lck chan int
+}
+
+func newSyncBuffer() *syncBuffer {
+	buf := &syncBuffer{lck: make(chan int, 1)}
+	buf.lck <- 1
+	return buf
+}
+
+func (s *syncBuffer) Write(p []byte) (n int, err error) {
+	<-s.lck
+	n, err = s.Buffer.Write(p)
+	s.lck <- 1
+	return
+}
+
+func (s *syncBuffer) String() string {
+	<-s.lck
+	defer func() { s.lck <- 1 }()
+	return s.Buffer.String()
+}
+
+func (s *syncBuffer) waitContains(sub string) bool {
+	for i := 0; i < 200; i++ {
+		if strings.Contains(s.String(), sub) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestDialer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dialer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	filename, err := writeConfig(dir, `
+dialers:
+  - type: echo
+  - type: cmd
+`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//echo
+	in, writer := io.Pipe()
+	out := newSyncBuffer()
+	c, err := start(filename, 1, "tcp://echo", in, out)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(writer, "abc")
+	if !out.waitContains("abc") {
+		t.Error(out.String())
+		return
+	}
+	writer.Close()
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Error("not done")
+		return
+	}
+	err = c.stop(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//cmd with resize
+	terminal, vty, err := pty.Open()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		terminal.Close()
+		vty.Close()
+	}()
+	err = dialer.SetFileWinSize(vty, 30, 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	in, writer = io.Pipe()
+	out = newSyncBuffer()
+	c, err = start(filename, 2, "tcp://cmd?exec=bash+--norc", in, out)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go forwardResize(c.Conn, vty, c.Done())
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprintf(writer, "stty size\n")
+	if !out.waitContains("30 100") {
+		t.Error(out.String())
+		return
+	}
+	err = dialer.SetFileWinSize(vty, 40, 120)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = dialer.ResizeFileTo(c.Conn, vty)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(writer, "stty size\n")
+	if !out.waitContains("40 120") {
+		t.Error(out.String())
+		return
+	}
+	fmt.Fprintf(writer, "exit\n")
+	select {
+	case <-c.Done():
+	case <-time.After(3 * time.Second):
+		t.Error("not done")
+		return
+	}
+	c.stop(context.Background())
+	//resize not supported
+	in, writer = io.Pipe()
+	c, err = start(filename, 3, "tcp://echo", in, newSyncBuffer())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	forwardResize(c.Conn, vty, c.Done())
+	writer.Close()
+	c.stop(context.Background())
+}
+
+func TestDialerError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dialer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	//config not found
+	_, err = start(filepath.Join(dir, "none.yml"), 1, "tcp://echo", os.Stdin, os.Stdout)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//config invalid
+	filename, err := writeConfig(dir, "echo: x\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = start(filename, 1, "tcp://echo", os.Stdin, os.Stdout)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//not matched
+	filename, err = writeConfig(dir, "echo: 1\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = start(filename, 1, "xx://none", os.Stdin, os.Stdout)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	if !isCmdURI("tcp://cmd?exec=bash") || isCmdURI("tcp://host:22") || isCmdURI("%x://") {
+		t.Error("error")
+		return
+	}
+}
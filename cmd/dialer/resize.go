@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func notifyResize(changed chan os.Signal) {
+	signal.Notify(changed, syscall.SIGWINCH)
+}
+
+func stopResize(changed chan os.Signal) {
+	signal.Stop(changed)
+}
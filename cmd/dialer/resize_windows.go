@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+)
+
+//the window size changes is not notified on windows
+func notifyResize(changed chan os.Signal) {
+}
+
+func stopResize(changed chan os.Signal) {
+}
@@ -0,0 +1,242 @@
+//Command dialerd is the standalone daemon to serve the dialer Pool over handshake protocol.
+//
+//the client connects to dialerd and sends the handshake request having sid and uri,
+//then the connection is piped to the remote which is dialed by Pool, see dialer.DialHandshake.
+//the sid of handshake is not authenticated, so listen on trusted network or unix socket only.
+//the mux listener carries many streams over one connection, it is used by dialer.MuxDialer.
+//the agent mode connects to dialer.ReverseDialer behind NAT, the stream opened by server is dialed by Pool.
+//the http listener serves CONNECT and plain http proxy request by Pool, it can be used by browser or curl -x.
+//
+//usage:
+//	dialerd -config dialer.yml -listen 127.0.0.1:8080 -listen unix:/tmp/dialerd.sock -mux 127.0.0.1:8081
+//	dialerd -config dialer.yml -agent server:9090 -name agent-1 -token xxx
+//	dialerd -config dialer.yml -http :3128 -http-user alice:xxx:100
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/sutils/dialer"
+)
+
+type listenFlags []string
+
+func (l *listenFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listenFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+//daemon is the running dialerd
+type daemon struct {
+	Pool          *dialer.Pool
+	Server        *dialer.HandshakeServer
+	Mux           *dialer.MuxServer
+	HTTP          *dialer.HTTPProxyServer
+	Listeners     []net.Listener
+	MuxListeners  []net.Listener
+	HTTPListeners []net.Listener
+	Agent         *dialer.ReverseAgent
+	watcher       *dialer.ConfigWatcher
+	served        chan error
+}
+
+//options is the options of starting daemon
+type options struct {
+	Filename    string
+	Listens     []string
+	MuxListens  []string
+	HTTPListens []string
+	HTTPUsers   []string //the http proxy users like name:password:sid, empty is not authentication required
+	Agent       string   //the ReverseDialer address to connect as agent
+	Name        string   //the agent name
+	Token       string   //the agent token
+	Watch       time.Duration
+	Timeout     time.Duration
+}
+
+//listen will listen on all address, the listened is closed when error.
+func listen(listens []string) (ls []net.Listener, err error) {
+	for _, value := range listens {
+		var l net.Listener
+		l, err = dialer.ListenAddress(value)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			ls = nil
+			return
+		}
+		ls = append(ls, l)
+	}
+	return
+}
+
+//start will load config and bootstrap pool, then serve handshake, mux and http proxy on all listen address,
+//and connect to the ReverseDialer as agent if set.
+func start(o *options) (d *daemon, err error) {
+	if len(o.Listens) < 1 && len(o.MuxListens) < 1 && len(o.HTTPListens) < 1 && len(o.Agent) < 1 {
+		err = fmt.Errorf("the listen address or agent server address is required")
+		return
+	}
+	if len(o.Agent) > 0 && len(o.Name) < 1 {
+		err = fmt.Errorf("the agent name is required")
+		return
+	}
+	var users dialer.StaticProxyAuthenticator
+	for _, value := range o.HTTPUsers {
+		var name string
+		var user *dialer.ProxyUser
+		name, user, err = dialer.ParseProxyUser(value)
+		if err != nil {
+			return
+		}
+		if users == nil {
+			users = dialer.StaticProxyAuthenticator{}
+		}
+		users[name] = user
+	}
+	config, err := dialer.LoadConfig(o.Filename)
+	if err != nil {
+		return
+	}
+	d = &daemon{
+		Pool: dialer.NewPool(),
+	}
+	err = config.Bootstrap(d.Pool)
+	if err != nil {
+		return
+	}
+	d.Server = dialer.NewHandshakeServer(d.Pool)
+	d.Mux = dialer.NewMuxServer(d.Pool)
+	d.HTTP = dialer.NewHTTPProxyServer(d.Pool)
+	if users != nil {
+		d.HTTP.Auth = users
+	}
+	if o.Timeout > 0 {
+		d.Server.Timeout = o.Timeout
+		d.Mux.Timeout = o.Timeout
+		d.HTTP.Timeout = o.Timeout
+	}
+	d.Listeners, err = listen(o.Listens)
+	if err == nil {
+		d.MuxListeners, err = listen(o.MuxListens)
+	}
+	if err == nil {
+		d.HTTPListeners, err = listen(o.HTTPListens)
+	}
+	if err != nil {
+		d.stop(context.Background())
+		return
+	}
+	d.served = make(chan error, len(d.Listeners)+len(d.MuxListeners)+len(d.HTTPListeners))
+	for _, l := range d.Listeners {
+		go func(l net.Listener) {
+			d.served <- d.Server.Serve(l)
+		}(l)
+	}
+	for _, l := range d.MuxListeners {
+		go func(l net.Listener) {
+			d.served <- d.Mux.Serve(l)
+		}(l)
+	}
+	for _, l := range d.HTTPListeners {
+		go func(l net.Listener) {
+			d.served <- d.HTTP.Serve(l)
+		}(l)
+	}
+	if o.Watch > 0 {
+		d.watcher, err = dialer.WatchConfig(d.Pool, o.Filename, o.Watch)
+		if err != nil {
+			d.stop(context.Background())
+			return
+		}
+	}
+	if len(o.Agent) > 0 {
+		d.Agent = dialer.NewReverseAgent(d.Pool, o.Agent, o.Name, o.Token)
+		if o.Timeout > 0 {
+			d.Agent.Timeout = o.Timeout
+		}
+		d.Agent.Start()
+	}
+	return
+}
+
+//stop will close all listeners, shutdown the mux sessions, http proxy and the pool until ctx done.
+func (d *daemon) stop(ctx context.Context) (err error) {
+	if d.watcher != nil {
+		d.watcher.Stop()
+	}
+	if d.Agent != nil {
+		d.Agent.Stop(ctx)
+	}
+	for _, l := range d.Listeners {
+		l.Close()
+	}
+	for _, l := range d.MuxListeners {
+		l.Close()
+	}
+	for _, l := range d.HTTPListeners {
+		l.Close()
+	}
+	if d.Mux != nil {
+		d.Mux.Shutdown(ctx)
+	}
+	if d.HTTP != nil {
+		d.HTTP.Shutdown(ctx)
+	}
+	err = d.Pool.Shutdown(ctx)
+	return
+}
+
+func main() {
+	var listens, muxListens, httpListens, httpUsers listenFlags
+	o := &options{}
+	flag.StringVar(&o.Filename, "config", "dialer.yml", "the JSON/YAML config file of pool")
+	flag.DurationVar(&o.Watch, "watch", 0, "the interval of checking config file to reload, 0 is not reload")
+	flag.DurationVar(&o.Timeout, "timeout", 10*time.Second, "the timeout of handshake and dialing")
+	grace := flag.Duration("grace", 30*time.Second, "the timeout of waiting sessions closed when shutdown")
+	flag.Var(&listens, "listen", "the listen address like 127.0.0.1:8080 or unix:/tmp/dialerd.sock, it can be set multiple times")
+	flag.Var(&muxListens, "mux", "the mux listen address like 127.0.0.1:8081 or unix:/tmp/dialerd-mux.sock, it can be set multiple times")
+	flag.Var(&httpListens, "http", "the http proxy listen address like 127.0.0.1:3128, it can be set multiple times")
+	flag.Var(&httpUsers, "http-user", "the http proxy user like name:password:sid, it can be set multiple times")
+	flag.StringVar(&o.Agent, "agent", "", "the reverse dialer address to connect as agent, like server:9090")
+	flag.StringVar(&o.Name, "name", "", "the agent name registered on reverse dialer")
+	flag.StringVar(&o.Token, "token", "", "the agent token")
+	flag.Parse()
+	o.Listens, o.MuxListens, o.HTTPListens, o.HTTPUsers = listens, muxListens, httpListens, httpUsers
+	d, err := start(o)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dialerd start fail with %v\n", err)
+		os.Exit(1)
+	}
+	log.I("dialerd is started on %v, mux on %v, http on %v, agent to %v", listens.String(), muxListens.String(), httpListens.String(), o.Agent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		log.I("dialerd receive signal %v, shutting down", sig)
+	case err = <-d.served:
+		log.W("dialerd serve fail with %v, shutting down", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *grace)
+	defer cancel()
+	err = d.stop(ctx)
+	if err != nil {
+		log.W("dialerd shutdown fail with %v", err)
+		os.Exit(1)
+	}
+	log.I("dialerd is stopped")
+}
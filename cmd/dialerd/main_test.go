@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+	"github.com/sutils/dialer"
+)
+
+func writeConfig(dir, data string) (filename string, err error) {
+	filename = filepath.Join(dir, "dialer.yml")
+	err = ioutil.WriteFile(filename, []byte(data), os.ModePerm)
+	return
+}
+
+func echoHandshake(network, address string, sid uint64, uri string) (err error) {
+	conn, err := dialer.DialHandshake(context.Background(), network, address, sid, uri)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "abc")
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(conn, buf)
+	if err == nil && string(buf) != "abc" {
+		err = fmt.Errorf("echo fail with %v", string(buf))
+	}
+	return
+}
+
+func TestDialerd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dialerd")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	//the echo server on localhost
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	filename, err := writeConfig(dir, `
+dialers:
+  - type: echo
+  - type: tcp
+acl:
+  rules:
+    - action: deny
+      host: denied.local
+`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sock := filepath.Join(dir, "dialerd.sock")
+	d, err := start(&options{
+		Filename:    filename,
+		Listens:     []string{"127.0.0.1:0", "unix:" + sock},
+		MuxListens:  []string{"127.0.0.1:0"},
+		HTTPListens: []string{"127.0.0.1:0"},
+		HTTPUsers:   []string{"u1:p1:1"},
+		Watch:       10 * time.Millisecond,
+		Timeout:     time.Second,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	address := d.Listeners[0].Addr().String()
+	//echo dialer
+	err = echoHandshake("tcp", address, 1, "tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//tcp dialer to localhost
+	err = echoHandshake("tcp", address, 2, "tcp://"+echo.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//unix socket
+	err = echoHandshake("unix", sock, 3, "tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//mux
+	mux := dialer.NewMuxDialer()
+	err = mux.Bootstrap(util.Map{"id": "mux", "address": d.MuxListeners[0].Addr().String()})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	muxConn, err := mux.Dial(10, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(muxConn, "abc")
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(muxConn, buf)
+	if err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", string(buf), err)
+		return
+	}
+	muxConn.Close()
+	mux.Shutdown(context.Background())
+	//http proxy
+	proxyConn, err := net.Dial("tcp", d.HTTPListeners[0].Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	target := echo.Addr().String()
+	fmt.Fprintf(proxyConn, "CONNECT %v HTTP/1.1\r\nHost: %v\r\nProxy-Authorization: Basic dTE6cDE=\r\n\r\n", target, target)
+	reader := bufio.NewReader(proxyConn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("%v,%v", res, err)
+		return
+	}
+	fmt.Fprintf(proxyConn, "abc")
+	_, err = io.ReadFull(reader, buf)
+	if err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", string(buf), err)
+		return
+	}
+	proxyConn.Close()
+	//fail status
+	err = echoHandshake("tcp", address, 4, "tcp://denied.local:80")
+	if cerr, ok := err.(*dialer.CodeError); !ok || cerr.Code() != dialer.CodeDenied {
+		t.Error(err)
+		return
+	}
+	err = echoHandshake("tcp", address, 5, "tcp://127.0.0.1:1")
+	if cerr, ok := err.(*dialer.CodeError); !ok || cerr.Code() != dialer.CodeRefused {
+		t.Error(err)
+		return
+	}
+	//reload by config changed
+	_, err = writeConfig(dir, "dialers:\n  - type: tcp\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 100 && d.Pool.FindDialer("echo") != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = echoHandshake("tcp", address, 6, "tcp://echo")
+	if cerr, ok := err.(*dialer.CodeError); !ok || cerr.Code() != dialer.CodeOther {
+		t.Error(err)
+		return
+	}
+	//shutdown
+	conn, err := dialer.DialHandshake(context.Background(), "tcp", address, 7, "tcp://"+echo.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = d.stop(ctx)
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+		return
+	}
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.DialHandshake(context.Background(), "tcp", address, 8, "tcp://echo")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDialerdAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dialerd")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	filename, err := writeConfig(dir, "echo: 1\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//the reverse dialer on server
+	pool := dialer.NewPool()
+	err = pool.Bootstrap(util.Map{
+		"dialers": []util.Map{
+			{"type": "reverse", "id": "reverse", "tokens": util.Map{"agent-1": "xxx"}},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer pool.Shutdown(context.Background())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reverse := pool.FindDialer("reverse").(*dialer.ReverseDialer)
+	go reverse.Serve(l)
+	//start as agent
+	d, err := start(&options{
+		Filename: filename,
+		Agent:    l.Addr().String(),
+		Name:     "agent-1",
+		Token:    "xxx",
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 100 && len(reverse.Agents()) < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn, err := pool.Dial(1, "tcp://agent-1/tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(conn, "abc")
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", string(buf), err)
+		return
+	}
+	conn.Close()
+	err = d.stop(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 100 && len(reverse.Agents()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(reverse.Agents()) != 0 {
+		t.Error("not disconnected")
+		return
+	}
+}
+
+func TestDialerdError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dialerd")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	filename, err := writeConfig(dir, "echo: 1\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//not listen
+	_, err = start(&options{Filename: filename})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//agent name required
+	_, err = start(&options{Filename: filename, Agent: "127.0.0.1:9090"})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//http user invalid
+	_, err = start(&options{Filename: filename, HTTPListens: []string{"127.0.0.1:0"}, HTTPUsers: []string{"u1"}})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//config not found
+	_, err = start(&options{Filename: filepath.Join(dir, "none.yml"), Listens: []string{"127.0.0.1:0"}})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//listen fail
+	_, err = start(&options{Filename: filename, Listens: []string{"127.0.0.1:0"}, MuxListens: []string{"127.0.0.1:x"}})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//the file which is not socket is kept
+	regular := filepath.Join(dir, "regular.sock")
+	ioutil.WriteFile(regular, []byte("data"), 0600)
+	_, err = start(&options{Filename: filename, Listens: []string{"unix:" + regular}})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	if data, _ := ioutil.ReadFile(regular); string(data) != "data" {
+		t.Error(string(data))
+		return
+	}
+	//config invalid
+	filename, err = writeConfig(dir, "echo: x\n")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = start(&options{Filename: filename, Listens: []string{"127.0.0.1:0"}})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	listens := listenFlags{}
+	listens.Set(":80")
+	listens.Set(":81")
+	if listens.String() != ":80,:81" {
+		t.Error(listens.String())
+		return
+	}
+}
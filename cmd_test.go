@@ -1,9 +1,13 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -131,7 +135,7 @@ func TestCmdDialer(t *testing.T) {
 	raw.Close()
 	//
 	//
-	cmd.Shutdown()
+	cmd.Shutdown(context.Background())
 	time.Sleep(500 * time.Millisecond)
 	cmd.Name()
 	cmd.Options()
@@ -191,3 +195,61 @@ func TestCmdDialer2(t *testing.T) {
 		return
 	}
 }
+
+func TestCmdDialerEnv(t *testing.T) {
+	cmd := NewCmdDialer()
+	cmd.Bootstrap(nil)
+	raw, err := cmd.Dial(10, "tcp://cmd?exec=env&e1=1&rate_up=1000&rate_down=1000&idle_timeout=10000", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer raw.Close()
+	//the pty is returned error when command exited
+	data, _ := ioutil.ReadAll(raw)
+	env := string(data)
+	if !strings.Contains(env, "e1=1") || strings.Contains(env, "rate_up") || strings.Contains(env, "rate_down") || strings.Contains(env, "idle_timeout") {
+		t.Error(env)
+		return
+	}
+}
+
+func TestCmdDialerReusePool(t *testing.T) {
+	cmd := NewCmdDialer()
+	cmd.Bootstrap(util.Map{"reuse": 10000})
+	defer cmd.Shutdown(context.Background())
+	pool := NewPool()
+	pool.AddDialer(cmd)
+	uri := "tcp://cmd?exec=bash+--norc&reuse=pool"
+	local, remote := net.Pipe()
+	_, err := pool.Dial(1, uri, remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	local.Close()
+	time.Sleep(200 * time.Millisecond)
+	//the reused connection is having done stats
+	dialed := make(chan error, 1)
+	go func() {
+		conn, err := pool.Dial(2, uri, nil)
+		if err == nil {
+			conn.Close()
+		}
+		dialed <- err
+	}()
+	select {
+	case err = <-dialed:
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("dial reused cmd is blocked")
+		return
+	}
+	if all := pool.Stats(); len(all) != 1 || all[0].Success != 2 {
+		t.Error(util.S2Json(all))
+		return
+	}
+}
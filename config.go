@@ -0,0 +1,272 @@
+package dialer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Centny/gwf/util"
+	"gopkg.in/yaml.v3"
+)
+
+//ConfigSource is the source file and line of one config path.
+type ConfigSource struct {
+	File string
+	Line int
+}
+
+func (c *ConfigSource) String() string {
+	return fmt.Sprintf("%v:%v", c.File, c.Line)
+}
+
+//Config is the options loaded from JSON/YAML file by LoadConfig, it is having the source of each config path.
+//
+//the config file supports:
+//	include: the map having include key is merged by the included files, the keys of map is overriding included,
+//	         the include is file path or path list which is relative to current file, like {"include": "dialers.yml"}
+//	${ENV}: the environment substitution in string, the ${ENV:-default} is used when ENV is not set, $${ is escaped to ${
+//	file:path: the string value is replaced by the trimmed content of file, which is relative to current file, like "file:secret.txt"
+type Config struct {
+	Options util.Map
+	Files   []string //the absolute path of config file and all included files
+	sources map[string]*ConfigSource
+}
+
+//LoadConfig will load the config from JSON/YAML file, the JSON is parsed as YAML.
+func LoadConfig(filename string) (config *Config, err error) {
+	config = &Config{
+		sources: map[string]*ConfigSource{},
+	}
+	loader := &configLoader{config: config, loading: map[string]bool{}, loaded: map[string]bool{}}
+	val, err := loader.loadFile("", filename)
+	if err != nil {
+		return
+	}
+	options, ok := val.(util.Map)
+	if !ok {
+		err = fmt.Errorf("%v the config root must be map", config.Source(""))
+		return
+	}
+	config.Options = options
+	return
+}
+
+//Source will return the source of config path like dialers[0].id, it return nil if not found.
+func (c *Config) Source(path string) *ConfigSource {
+	return c.sources[path]
+}
+
+//Annotate will add the source of config path to the error which is returned by validating config.
+func (c *Config) Annotate(err error) error {
+	if serr, ok := err.(*SchemaError); ok {
+		for _, ferr := range serr.Errors {
+			path := ferr.Path
+			for len(path) > 0 && c.Source(path) == nil {
+				//the required key is not exists, using the source of parent
+				path = parentPath(path)
+			}
+			if source := c.Source(path); source != nil {
+				ferr.Source = source.String()
+			}
+		}
+	}
+	return err
+}
+
+//Bootstrap will bootstrap the pool by config, the validating error is annotated with source.
+func (c *Config) Bootstrap(pool *Pool) error {
+	return c.Annotate(pool.Bootstrap(c.Options))
+}
+
+//Reload will reload the pool by config, the validating error is annotated with source.
+func (c *Config) Reload(pool *Pool) error {
+	return c.Annotate(pool.Reload(c.Options))
+}
+
+func parentPath(path string) string {
+	index := strings.LastIndexAny(path, ".[")
+	if index < 0 {
+		return ""
+	}
+	return path[:index]
+}
+
+type configLoader struct {
+	config  *Config
+	loading map[string]bool
+	loaded  map[string]bool
+}
+
+func (c *configLoader) loadFile(path, filename string) (val interface{}, err error) {
+	filename, err = filepath.Abs(filename)
+	if err != nil {
+		return
+	}
+	if c.loading[filename] {
+		err = fmt.Errorf("include %v is recursive", filename)
+		return
+	}
+	c.loading[filename] = true
+	defer delete(c.loading, filename)
+	if !c.loaded[filename] {
+		c.loaded[filename] = true
+		c.config.Files = append(c.config.Files, filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	root := &yaml.Node{}
+	err = yaml.Unmarshal(data, root)
+	if err != nil {
+		err = fmt.Errorf("parse %v fail with %v", filename, err)
+		return
+	}
+	if len(root.Content) < 1 {
+		err = fmt.Errorf("parse %v fail with empty content", filename)
+		return
+	}
+	val, err = c.load(filename, path, root.Content[0])
+	return
+}
+
+func (c *configLoader) load(filename, path string, node *yaml.Node) (val interface{}, err error) {
+	if _, ok := c.config.sources[path]; !ok {
+		c.config.sources[path] = &ConfigSource{File: filename, Line: node.Line}
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		val, err = c.loadMap(filename, path, node)
+	case yaml.SequenceNode:
+		items := []interface{}{}
+		for index, child := range node.Content {
+			var item interface{}
+			item, err = c.load(filename, fmt.Sprintf("%v[%v]", path, index), child)
+			if err != nil {
+				return
+			}
+			items = append(items, item)
+		}
+		val = items
+	case yaml.AliasNode:
+		val, err = c.load(filename, path, node.Alias)
+	default:
+		err = node.Decode(&val)
+		if err != nil {
+			err = fmt.Errorf("%v:%v parse %v fail with %v", filename, node.Line, path, err)
+			return
+		}
+		if str, ok := val.(string); ok && node.Tag == "!!str" {
+			val, err = c.substitute(filename, str)
+			if err != nil {
+				err = fmt.Errorf("%v:%v parse %v fail with %v", filename, node.Line, path, err)
+			}
+		}
+	}
+	return
+}
+
+func (c *configLoader) loadMap(filename, path string, node *yaml.Node) (val interface{}, err error) {
+	options := util.Map{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "include" {
+			continue
+		}
+		var includes []string
+		include := node.Content[i+1]
+		if include.Kind == yaml.SequenceNode {
+			err = include.Decode(&includes)
+		} else {
+			includes = []string{include.Value}
+		}
+		if err != nil {
+			err = fmt.Errorf("%v:%v parse include fail with %v", filename, include.Line, err)
+			return
+		}
+		for _, name := range includes {
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(filepath.Dir(filename), name)
+			}
+			var included interface{}
+			included, err = c.loadFile(path, name)
+			if err != nil {
+				err = fmt.Errorf("%v:%v include fail with %v", filename, include.Line, err)
+				return
+			}
+			includedOptions, ok := included.(util.Map)
+			if !ok {
+				err = fmt.Errorf("%v:%v include %v fail with content is not map", filename, include.Line, name)
+				return
+			}
+			for key, v := range includedOptions {
+				options[key] = v
+			}
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		if key == "include" {
+			continue
+		}
+		keyPath := joinPath(path, key)
+		//the key of current file is overriding included
+		c.config.sources[keyPath] = &ConfigSource{File: filename, Line: node.Content[i].Line}
+		options[key], err = c.load(filename, keyPath, node.Content[i+1])
+		if err != nil {
+			return
+		}
+	}
+	val = options
+	return
+}
+
+//substitute will replace the ${ENV} by environment and replace the file:path by file content.
+func (c *configLoader) substitute(filename, str string) (val string, err error) {
+	if strings.HasPrefix(str, "file:") && !strings.HasPrefix(str, "file://") {
+		name := strings.TrimPrefix(str, "file:")
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(filename), name)
+		}
+		var data []byte
+		data, err = ioutil.ReadFile(name)
+		val = strings.TrimRight(string(data), "\r\n")
+		return
+	}
+	buf := []string{}
+	for {
+		begin := strings.Index(str, "${")
+		if begin < 0 {
+			break
+		}
+		if begin > 0 && str[begin-1] == '$' {
+			//escaped
+			buf = append(buf, str[:begin-1], "${")
+			str = str[begin+2:]
+			continue
+		}
+		end := strings.Index(str[begin:], "}")
+		if end < 0 {
+			err = fmt.Errorf("the environment substitution(%v) is not closed", str[begin:])
+			return
+		}
+		name := str[begin+2 : begin+end]
+		def, hasDef := "", false
+		if index := strings.Index(name, ":-"); index >= 0 {
+			name, def, hasDef = name[:index], name[index+2:], true
+		}
+		env, ok := os.LookupEnv(name)
+		if !ok || (len(env) < 1 && hasDef) {
+			if !hasDef {
+				err = fmt.Errorf("the environment(%v) is not set", name)
+				return
+			}
+			env = def
+		}
+		buf = append(buf, str[:begin], env)
+		str = str[begin+end+1:]
+	}
+	val = strings.Join(append(buf, str), "")
+	return
+}
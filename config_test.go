@@ -0,0 +1,140 @@
+package dialer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(files map[string]string) (dir string, err error) {
+	dir, err = ioutil.TempDir("", "config")
+	if err != nil {
+		return
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), os.ModePerm)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func TestLoadConfig(t *testing.T) {
+	os.Setenv("CONFIG_TEST_ID", "s0")
+	os.Setenv("CONFIG_TEST_EMPTY", "")
+	dir, err := writeConfigFiles(map[string]string{
+		"main.yml": `
+include: base.json
+echo: 1
+dialers:
+  - type: socks
+    id: ${CONFIG_TEST_ID}
+    address: "127.0.0.1:${CONFIG_TEST_PORT:-1080}"
+    matcher: "$${x}"
+    password: file:secret.txt
+  - include: [tcp.yml]
+    bind: ${CONFIG_TEST_EMPTY:-127.0.0.1:0}
+`,
+		"base.json": `{
+  "echo": 0,
+  "web": 1
+}`,
+		"tcp.yml": `
+type: tcp
+bind: 0.0.0.0:0
+`,
+		"secret.txt": "abc\n",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	config, err := LoadConfig(filepath.Join(dir, "main.yml"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	options := config.Options
+	dialers := options.AryMapVal("dialers")
+	if options.IntVal("echo") != 1 || options.IntVal("web") != 1 || len(dialers) != 2 {
+		t.Error(options)
+		return
+	}
+	if dialers[0].StrVal("id") != "s0" || dialers[0].StrVal("address") != "127.0.0.1:1080" ||
+		dialers[0].StrVal("matcher") != "${x}" || dialers[0].StrVal("password") != "abc" {
+		t.Error(dialers[0])
+		return
+	}
+	if dialers[1].StrVal("type") != "tcp" || dialers[1].StrVal("bind") != "127.0.0.1:0" {
+		t.Error(dialers[1])
+		return
+	}
+	if source := config.Source("dialers[0].id"); source == nil || source.Line != 6 || !strings.HasSuffix(source.File, "main.yml") {
+		t.Error(source)
+		return
+	}
+	if source := config.Source("dialers[1].type"); source == nil || source.Line != 2 || !strings.HasSuffix(source.File, "tcp.yml") {
+		t.Error(source)
+		return
+	}
+	if source := config.Source("web"); source == nil || source.Line != 3 || !strings.HasSuffix(source.File, "base.json") {
+		t.Error(source)
+		return
+	}
+	//validate error
+	err = config.Bootstrap(NewPool())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	config.Options["strict"] = 1
+	err = config.Bootstrap(NewPool())
+	if err == nil || !strings.Contains(err.Error(), "main.yml:9: dialers[0].password is unknown key") {
+		t.Error(err)
+		return
+	}
+	ioutil.WriteFile(filepath.Join(dir, "required.yml"), []byte("dialers:\n  - type: socks\n"), os.ModePerm)
+	config, err = LoadConfig(filepath.Join(dir, "required.yml"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = config.Bootstrap(NewPool())
+	if err == nil || !strings.Contains(err.Error(), "required.yml:2: dialers[0].id is required") {
+		t.Error(err)
+		return
+	}
+	//
+	//test error
+	dir, err = writeConfigFiles(map[string]string{
+		"array.yml":     "- 1\n",
+		"empty.yml":     "",
+		"invalid.yml":   "a: [\n",
+		"recursive.yml": "include: recursive.yml\n",
+		"env.yml":       "a: ${CONFIG_TEST_NONE}\n",
+		"unclosed.yml":  "a: ${CONFIG_TEST_NONE\n",
+		"secret.yml":    "a: file:none.txt\n",
+		"include.yml":   "include: array.yml\n",
+		"includes.yml":  "include: {a: 1}\n",
+		"none.yml":      "include: [none.yml, x.yml]\n",
+		"nested.yml":    "a:\n  - b: ${CONFIG_TEST_NONE}\n",
+		"int.yml":       "a: !!int x\n",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"array.yml", "empty.yml", "invalid.yml", "recursive.yml", "env.yml", "unclosed.yml",
+		"secret.yml", "include.yml", "includes.yml", "none.yml", "nested.yml", "int.yml", "not.yml"} {
+		_, err = LoadConfig(filepath.Join(dir, name))
+		if err == nil {
+			t.Error(name)
+			return
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package dialer
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/Centny/gwf/util"
 )
@@ -82,3 +87,243 @@ func TestPool(t *testing.T) {
 		return
 	}
 }
+
+type SlowDialer struct {
+	OnceDialer
+	delay time.Duration
+}
+
+func (s *SlowDialer) Matched(uri string) bool {
+	return uri == "slow"
+}
+
+func (s *SlowDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
+	time.Sleep(s.delay)
+	r = s
+	return
+}
+
+func TestPoolDialContext(t *testing.T) {
+	pool := NewPool()
+	pool.AddDialer(&SlowDialer{delay: 200 * time.Millisecond}, NewEchoDialer())
+	//test adapter
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := pool.DialContext(ctx, 10, "slow", nil)
+	if err != context.DeadlineExceeded || time.Since(begin) > 150*time.Millisecond {
+		t.Errorf("%v,%v", err, time.Since(begin))
+		return
+	}
+	_, err = pool.DialContext(context.Background(), 10, "slow", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//test context dialer
+	conn, err := pool.DialContext(context.Background(), 10, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.DialContext(canceled, 10, "tcp://echo", nil)
+	if err != context.Canceled {
+		t.Error(err)
+		return
+	}
+	_, err = DialContext(canceled, &SlowDialer{}, 10, "slow", nil)
+	if err != context.Canceled {
+		t.Error(err)
+		return
+	}
+	//not matched
+	_, err = pool.DialContext(context.Background(), 10, "xx://none", nil)
+	if err == nil || err.Error() != "dial to xx://none fail: no dialer matched uri(xx://none)" || ClassifyError(err) != ErrClassUnsupported || ErrorCode(err) != CodeUnsupported {
+		t.Error(err)
+		return
+	}
+	fmt.Printf("%v test done...\n", pool)
+}
+
+func TestPoolFallback(t *testing.T) {
+	fail := &FailDialer{OnceDialer{ID: "fail", conf: util.Map{}}}
+	pool := NewPool()
+	pool.AddDialer(fail, NewTCPDialer())
+	//not fallback
+	_, err := pool.Dial(10, "tcp://127.0.0.1:1", nil)
+	if err == nil || err.Error() != "fail" {
+		t.Error(err)
+		return
+	}
+	//fallback by error class not matched
+	pool.Fallback = []string{ErrClassRefused}
+	_, err = pool.Dial(10, "tcp://127.0.0.1:1", nil)
+	if derr, ok := err.(*DialError); !ok || len(derr.Failures) != 1 {
+		t.Error(err)
+		return
+	}
+	//fallback all
+	pool.Fallback = []string{"*"}
+	_, err = pool.Dial(10, "tcp://127.0.0.1:1", nil)
+	if derr, ok := err.(*DialError); !ok || len(derr.Failures) != 2 ||
+		derr.Failures[0].Class != ErrClassOther || derr.Failures[1].Class != ErrClassRefused {
+		t.Error(err)
+		return
+	}
+	fmt.Println(err)
+	//fallback to success
+	pool = NewPool()
+	err = pool.Bootstrap(util.Map{
+		"fallback": []string{ErrClassOther},
+		"echo":     1,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pool.Dialers = append([]Dialer{fail}, pool.Dialers...)
+	conn, err := pool.Dial(10, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+}
+
+func waitGoroutines(max int, timeout time.Duration) int {
+	begin := time.Now()
+	for runtime.NumGoroutine() > max && time.Since(begin) < timeout {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func TestPoolShutdown(t *testing.T) {
+	before := runtime.NumGoroutine()
+	pool := NewPool()
+	err := pool.Bootstrap(util.Map{
+		"dialers": []util.Map{
+			{"type": "cmd", "reuse_delay": 10},
+			{"type": "web"},
+			{"type": "echo"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := pool.Dial(10, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Close()
+	}()
+	//wait session closed
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	begin := time.Now()
+	err = pool.Shutdown(ctx)
+	if err != nil || time.Since(begin) < 100*time.Millisecond || len(pool.Sessions()) > 0 {
+		t.Errorf("%v,%v", err, time.Since(begin))
+		return
+	}
+	if after := waitGoroutines(before, time.Second); after > before {
+		t.Errorf("%v,%v", before, after)
+		return
+	}
+	//refuse new dial
+	_, err = pool.Dial(11, "tcp://echo", nil)
+	if err != ErrShutdown {
+		t.Error(err)
+		return
+	}
+	err = pool.Reload(util.Map{"echo": 1})
+	if err != ErrShutdown {
+		t.Error(err)
+		return
+	}
+	err = pool.Shutdown(ctx)
+	if err != ErrShutdown {
+		t.Error(err)
+		return
+	}
+	//force close
+	pool = NewPool()
+	pool.AddDialer(NewEchoDialer())
+	conn, err = pool.Dial(10, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = pool.Shutdown(ctx)
+	if err != context.DeadlineExceeded || len(pool.Sessions()) > 0 {
+		t.Error(err)
+		return
+	}
+	_, err = conn.Write([]byte("abc"))
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDialerShutdown(t *testing.T) {
+	child := NewEchoDialer()
+	balanced := NewBalancedDialer()
+	balanced.ID = "b0"
+	balanced.AddDialer(child)
+	dialers := map[string]Dialer{
+		"tcp://cmd":            NewCmdDialer(),
+		"tcp://echo":           NewEchoDialer(),
+		"http://web?dir=/tmp":  NewWebDialer(),
+		"tcp://127.0.0.1:1":    NewTCPDialer(),
+		"tcp://replay?file=xx": NewReplayDialer(),
+		"tcp://echo?b=1":       balanced,
+	}
+	socks := NewSocksProxyDialer()
+	err := socks.Bootstrap(util.Map{"id": "s0", "address": "127.0.0.1:1"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dialers["tcp://127.0.0.1:2"] = socks
+	for uri, dialer := range dialers {
+		if dialer != socks && dialer != balanced {
+			dialer.Bootstrap(util.Map{})
+		}
+		shutdowner, ok := dialer.(Shutdowner)
+		if !ok {
+			t.Error(dialer)
+			return
+		}
+		err = shutdowner.Shutdown(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, err = dialer.Dial(10, uri, nil)
+		if err != ErrShutdown {
+			t.Errorf("%v,%v", dialer, err)
+			return
+		}
+		//shutdown again
+		err = shutdowner.Shutdown(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	_, err = child.Dial(10, "tcp://echo", nil)
+	if err != ErrShutdown {
+		t.Error(err)
+		return
+	}
+}
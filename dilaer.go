@@ -1,9 +1,13 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/Centny/gwf/util"
 )
@@ -19,27 +23,44 @@ type Conn interface {
 
 type CopyPipable struct {
 	io.ReadWriteCloser
-	piped uint32
+	Config *PipeConfig
+	piped  uint32
+	stats  *ConnStats
 }
 
 func NewCopyPipable(raw io.ReadWriteCloser) *CopyPipable {
-	return &CopyPipable{ReadWriteCloser: raw}
+	return &CopyPipable{
+		ReadWriteCloser: raw,
+		Config:          &PipeConfig{HalfClose: true},
+		stats:           NewConnStats(),
+	}
 }
 
 func (c *CopyPipable) Pipe(r io.ReadWriteCloser) (err error) {
 	if atomic.CompareAndSwapUint32(&c.piped, 0, 1) {
-		go c.copyAndClose(c, r)
-		go c.copyAndClose(r, c)
+		pipeCopy(c, r, c.stats, c.Config)
 	} else {
 		err = fmt.Errorf("piped")
 	}
 	return
 }
 
-func (c *CopyPipable) copyAndClose(src io.ReadWriteCloser, dst io.ReadWriteCloser) {
-	io.Copy(dst, src)
-	dst.Close()
-	src.Close()
+//CloseWrite will shut down the writing side of raw connection if supported.
+func (c *CopyPipable) CloseWrite() error {
+	return closeWrite(c.ReadWriteCloser)
+}
+
+//Stats will return the accounting of piped connection
+func (c *CopyPipable) Stats() *ConnStats {
+	return c.stats
+}
+
+//PipeConfig will return the config of piping, it is created if not set.
+func (c *CopyPipable) PipeConfig() *PipeConfig {
+	if c.Config == nil {
+		c.Config = &PipeConfig{}
+	}
+	return c.Config
 }
 
 // Dialer is the interface that wraps the dialer
@@ -55,85 +76,561 @@ type Dialer interface {
 	Dial(sid uint64, uri string, raw io.ReadWriteCloser) (r Conn, err error)
 }
 
+//ContextDialer is the interface that wraps the dialer which can be canceled or time-bounded by context.
+type ContextDialer interface {
+	Dialer
+	//dial raw connection with context
+	DialContext(ctx context.Context, sid uint64, uri string, raw io.ReadWriteCloser) (r Conn, err error)
+}
+
+//Shutdowner is the interface that wraps the dialer which can be shutdown.
+//the shutdown dialer must refuse new dial by ErrShutdown, release all resources and stop all goroutines,
+//the ctx is the deadline of waiting the resources released.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+//LocalDialer is the interface that wraps the dialer which is not connecting to the uri host by network, like cmd/echo/web,
+//the cidr rules of ACL are not applied to it because its host is not resolvable.
+type LocalDialer interface {
+	Local() bool
+}
+
+//ListenHandover is the interface that wraps the dialer which is accepting on the listen address, like ReverseDialer,
+//Pool.Reload hands over the listener from the changed dialer to the new dialer instead of listening on the address again.
+type ListenHandover interface {
+	//Handover will stop accepting on the listener of address and return it, it returns nil if not listening on the address.
+	Handover(address string) net.Listener
+	//Takeover will accept on the listener of address, the listener is used instead of listening if it is called before Bootstrap.
+	Takeover(address string, l net.Listener)
+}
+
+//ErrShutdown is the error of dialing by shutdown dialer or pool.
+var ErrShutdown = fmt.Errorf("shutdown")
+
+//DialContext will dial the uri by dialer with context.
+//if the dialer is not ContextDialer, it will be adapted by dialing in goroutine,
+//and the connection dialed after context done will be closed.
+func DialContext(ctx context.Context, dialer Dialer, sid uint64, uri string, raw io.ReadWriteCloser) (r Conn, err error) {
+	if cdialer, ok := dialer.(ContextDialer); ok {
+		r, err = cdialer.DialContext(ctx, sid, uri, raw)
+		return
+	}
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+	if ctx.Done() == nil {
+		r, err = dialer.Dial(sid, uri, raw)
+		return
+	}
+	type dialResult struct {
+		r   Conn
+		err error
+	}
+	dialed := make(chan dialResult, 1)
+	go func() {
+		r, err := dialer.Dial(sid, uri, raw)
+		dialed <- dialResult{r: r, err: err}
+	}()
+	select {
+	case res := <-dialed:
+		r, err = res.r, res.err
+	case <-ctx.Done():
+		err = ctx.Err()
+		go func() {
+			res := <-dialed
+			if res.err == nil && res.r != nil {
+				res.r.Close()
+			}
+		}()
+	}
+	return
+}
+
 //Pool is the set of Dialer
 type Pool struct {
 	Dialers []Dialer
+	//the error class list to fallback to next matched dialer when dial fail, "*" is all error class.
+	Fallback []string
+	//the route table to route uri to dialer by name, the dialer is matched by Dialers order if it is nil.
+	Routes *RouteTable
+	//whether reject the unknown config keys when Bootstrap, it is also enabled by "strict" config key.
+	Strict bool
+	//the destination access control list which is checked before any dialer, it is not checked if nil.
+	ACL *ACL
+	//the authorizer to check whether sid can dial uri by dialer before dialing, it is not checked if nil.
+	Authorizer Authorizer
+	//the total rate limit of all sessions on pool, it is not limited if nil.
+	Limit       *RateLimit
+	managed     []*managedDialer //the dialers created by config
+	config      *poolConfig      //the last applied config
+	draining    []Dialer         //the dialers removed by Reload, they are shutdown when all sessions closed
+	dialing     map[*Session]bool
+	shutdown    bool
+	configLck   sync.RWMutex
+	reloadLck   sync.Mutex
+	limits      map[string]*RateLimit
+	limitsLck   sync.Mutex
+	sessions    map[uint64][]*Session //the live sessions by sid, the sid may be used by many sessions, like the http proxy user
+	sessionsLck sync.RWMutex
+	stats       statsTable
+	hooks       poolHooks
 }
 
 //NewPool will return new Pool
 func NewPool() (pool *Pool) {
-	pool = &Pool{}
+	pool = &Pool{
+		dialing:     map[*Session]bool{},
+		configLck:   sync.RWMutex{},
+		reloadLck:   sync.Mutex{},
+		limits:      map[string]*RateLimit{},
+		limitsLck:   sync.Mutex{},
+		sessions:    map[uint64][]*Session{},
+		sessionsLck: sync.RWMutex{},
+	}
 	return
 }
 
 //AddDialer will append dialer which is bootstraped to pool
 func (p *Pool) AddDialer(dialers ...Dialer) (err error) {
+	p.configLck.Lock()
 	p.Dialers = append(p.Dialers, dialers...)
+	p.configLck.Unlock()
 	return
 }
 
+//PoolSchema is the config schema of Pool
+var PoolSchema = NewSchema(
+	&SchemaField{Name: "strict", Type: FieldInt, Default: 0, Description: "whether reject the unknown config keys"},
+	&SchemaField{Name: "fallback", Type: FieldStringArray, Description: "the error class list to fallback"},
+	&SchemaField{Name: "rate_up", Type: FieldInt, Description: "the total bytes per second from local to remote"},
+	&SchemaField{Name: "rate_down", Type: FieldInt, Description: "the total bytes per second from remote to local"},
+	&SchemaField{Name: "dialers", Type: FieldDialers, Description: "the dialer list"},
+	&SchemaField{Name: "standard", Type: FieldInt, Default: 0, Description: "whether add cmd/echo/web/tcp dialer"},
+	&SchemaField{Name: "cmd", Type: FieldInt, Default: 0, Description: "whether add cmd dialer"},
+	&SchemaField{Name: "echo", Type: FieldInt, Default: 0, Description: "whether add echo dialer"},
+	&SchemaField{Name: "web", Type: FieldInt, Default: 0, Description: "whether add web dialer"},
+	&SchemaField{Name: "tcp", Type: FieldInt, Default: 0, Description: "whether add tcp dialer"},
+	&SchemaField{Name: "acl", Type: FieldMap, Schema: ACLSchema, Description: "the destination access control list"},
+	&SchemaField{Name: "authorizer", Type: FieldMap, Schema: StaticAuthorizerSchema, Description: "the static authorizer"},
+	&SchemaField{Name: "routes", Type: FieldMapArray, Schema: RouteSchema, Description: "the route list"},
+	&SchemaField{Name: "default_route", Type: FieldString, Description: "the default dialer name when not route matched"},
+)
+
+//Bootstrap will validate the config by PoolSchema and the schema of dialer type, then create all dialers.
 func (p *Pool) Bootstrap(options util.Map) error {
-	dialerOptions := options.AryMapVal("dialers")
-	for _, option := range dialerOptions {
+	config, err := p.parseConfig(options)
+	if err != nil {
+		return err
+	}
+	p.reloadLck.Lock()
+	defer p.reloadLck.Unlock()
+	dialers := p.ListDialers()
+	var created []*managedDialer
+	for _, spec := range config.dialers {
+		dialer, err := spec.bootstrap()
+		if err != nil {
+			shutdownManaged(created)
+			return err
+		}
+		dialers = append(dialers, dialer)
+		created = append(created, &managedDialer{Dialer: dialer, key: spec.key})
+	}
+	err = config.checkRoutes(dialers)
+	if err != nil {
+		shutdownManaged(created)
+		return err
+	}
+	p.configLck.Lock()
+	p.Dialers = dialers
+	p.managed = append(p.managed, created...)
+	p.config = config
+	p.Fallback = config.fallback
+	if config.rate {
+		p.Limit = NewRateLimit(config.rateUp, config.rateDown)
+	}
+	if config.acl != nil {
+		p.ACL = config.acl
+	}
+	if config.authorizer != nil {
+		p.Authorizer = config.authorizer
+	}
+	if config.routes != nil {
+		p.Routes = config.routes
+	}
+	p.configLck.Unlock()
+	p.applyDialerRates(config)
+	return nil
+}
+
+//poolConfig is the parsed config of Pool
+type poolConfig struct {
+	fallback         []string
+	rate             bool
+	rateUp, rateDown int64
+	acl              *ACL
+	authorizer       Authorizer
+	routes           *RouteTable
+	dialers          []*dialerSpec
+}
+
+//dialerSpec is the parsed config of one dialer, the dialer is not bootstrapped.
+type dialerSpec struct {
+	Dialer
+	key              string   //the config without rate keys, it is used to diff dialer on Reload
+	option           util.Map //the bootstrap options, it is nil for standard dialer
+	rate             bool
+	rateUp, rateDown int64
+}
+
+func (d *dialerSpec) name() string {
+	if name := d.Name(); len(name) > 0 {
+		return name
+	}
+	return d.option.StrVal("id")
+}
+
+func (d *dialerSpec) bootstrap() (dialer Dialer, err error) {
+	if d.option != nil {
+		err = d.Dialer.Bootstrap(d.option)
+	}
+	dialer = d.Dialer
+	return
+}
+
+//managedDialer is the dialer created by Pool config.
+type managedDialer struct {
+	Dialer
+	key string
+}
+
+func (p *Pool) parseConfig(options util.Map) (config *poolConfig, err error) {
+	err = PoolSchema.Validate("", options, p.Strict || options.IntValV("strict", 0) > 0)
+	if err != nil {
+		return
+	}
+	config = &poolConfig{
+		fallback: options.AryStrVal("fallback"),
+		rate:     options.Exist("rate_up") || options.Exist("rate_down"),
+		rateUp:   options.IntValV("rate_up", 0),
+		rateDown: options.IntValV("rate_down", 0),
+	}
+	for _, option := range options.AryMapVal("dialers") {
 		dtype := option.StrVal("type")
 		dialer := NewDialer(dtype)
 		if dialer == nil {
-			return fmt.Errorf("create dialer fail by %v", util.S2Json(option))
+			err = fmt.Errorf("create dialer fail with type(%v) not registered by %v", dtype, util.S2Json(option))
+			return
 		}
-		err := dialer.Bootstrap(option)
-		if err != nil {
-			return err
+		key := util.Map{}
+		for k, v := range option {
+			if k != "total_rate_up" && k != "total_rate_down" {
+				key[k] = v
+			}
 		}
-		p.Dialers = append(p.Dialers, dialer)
+		config.dialers = append(config.dialers, &dialerSpec{
+			Dialer:   dialer,
+			key:      util.S2Json(key),
+			option:   option,
+			rate:     option.Exist("total_rate_up") || option.Exist("total_rate_down"),
+			rateUp:   option.IntValV("total_rate_up", 0),
+			rateDown: option.IntValV("total_rate_down", 0),
+		})
 	}
+	var standard []Dialer
 	if options.IntValV("standard", 0) > 0 {
-		p.Dialers = append(p.Dialers, NewCmdDialer(), NewEchoDialer(),
+		standard = append(standard, NewCmdDialer(), NewEchoDialer(),
 			NewWebDialer(), NewTCPDialer())
 	} else {
 		if options.IntValV("cmd", 0) > 0 {
-			p.Dialers = append(p.Dialers, NewCmdDialer())
+			standard = append(standard, NewCmdDialer())
 		}
 		if options.IntValV("echo", 0) > 0 {
-			p.Dialers = append(p.Dialers, NewEchoDialer())
+			standard = append(standard, NewEchoDialer())
 		}
 		if options.IntValV("web", 0) > 0 {
-			p.Dialers = append(p.Dialers, NewWebDialer())
+			standard = append(standard, NewWebDialer())
 		}
 		if options.IntValV("tcp", 0) > 0 {
-			p.Dialers = append(p.Dialers, NewTCPDialer())
+			standard = append(standard, NewTCPDialer())
+		}
+	}
+	for _, dialer := range standard {
+		config.dialers = append(config.dialers, &dialerSpec{
+			Dialer: dialer,
+			key:    util.S2Json(util.Map{"standard": dialer.Name()}),
+		})
+	}
+	if aclOption := options.MapVal("acl"); aclOption != nil {
+		config.acl, err = NewACL(aclOption)
+		if err != nil {
+			return
+		}
+	}
+	if authOption := options.MapVal("authorizer"); authOption != nil {
+		config.authorizer, err = NewStaticAuthorizer(authOption)
+		if err != nil {
+			return
+		}
+	}
+	routeOptions := options.AryMapVal("routes")
+	defaultRoute := options.StrVal("default_route")
+	if len(routeOptions) > 0 || len(defaultRoute) > 0 {
+		config.routes = NewRouteTable()
+		config.routes.Default = defaultRoute
+		for _, option := range routeOptions {
+			var route *Route
+			route, err = NewRoute(option)
+			if err == nil {
+				err = config.routes.AddRoute(route)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+//checkRoutes will check all dialers on routes is exists.
+func (p *poolConfig) checkRoutes(dialers []Dialer) (err error) {
+	if p.routes == nil {
+		return
+	}
+	for _, route := range p.routes.Routes {
+		if findDialer(dialers, route.Dialer) == nil {
+			err = fmt.Errorf("the dialer(%v) on route(%v) is not found", route.Dialer, route.Name)
+			return
+		}
+	}
+	if len(p.routes.Default) > 0 && findDialer(dialers, p.routes.Default) == nil {
+		err = fmt.Errorf("the dialer(%v) on default route is not found", p.routes.Default)
+	}
+	return
+}
+
+func (p *Pool) applyDialerRates(config *poolConfig) {
+	for _, spec := range config.dialers {
+		//the rate is reset to unlimited when it is removed from config
+		p.DialerLimit(spec.name()).SetRate(spec.rateUp, spec.rateDown)
+	}
+}
+
+//ListDialers will return all dialers on pool by order.
+func (p *Pool) ListDialers() (dialers []Dialer) {
+	p.configLck.RLock()
+	dialers = append(dialers, p.Dialers...)
+	p.configLck.RUnlock()
+	return
+}
+
+//FindDialer will return the dialer by name, it return nil if not found.
+func (p *Pool) FindDialer(name string) Dialer {
+	return findDialer(p.ListDialers(), name)
+}
+
+func findDialer(dialers []Dialer, name string) Dialer {
+	for _, dialer := range dialers {
+		if dialer.Name() == name {
+			return dialer
 		}
 	}
 	return nil
 }
 
+//matchDialers will return the dialers which can dial the uri by order, it must be called with configLck.
+func (p *Pool) matchDialers(uri string) (dialers []Dialer, err error) {
+	if p.Routes == nil {
+		for _, dialer := range p.Dialers {
+			if dialer.Matched(uri) {
+				dialers = append(dialers, dialer)
+			}
+		}
+		return
+	}
+	names, err := p.Routes.Match(uri)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		dialer := findDialer(p.Dialers, name)
+		if dialer == nil {
+			err = fmt.Errorf("the dialer(%v) routed by uri(%v) is not found", name, uri)
+			return
+		}
+		dialers = append(dialers, dialer)
+	}
+	return
+}
+
 //Dial the uri by dialer poo
 func (p *Pool) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
-	for _, dialer := range p.Dialers {
-		if dialer.Matched(uri) {
-			r, err = dialer.Dial(sid, uri, pipe)
+	r, err = p.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext the uri by dialer pool with context
+func (p *Pool) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
+	p.configLck.RLock()
+	if p.shutdown {
+		p.configLck.RUnlock()
+		err = ErrShutdown
+		return
+	}
+	acl, authorizer, fallback, limit := p.ACL, p.Authorizer, p.Fallback, p.Limit
+	dialers, merr := p.matchDialers(uri)
+	p.configLck.RUnlock()
+	if acl != nil {
+		err = acl.check(ctx, uri, networkDialers(dialers))
+		if err != nil {
 			return
 		}
+		//the connecting ip is checked again by dialer
+		ctx = withACL(ctx, acl)
+	}
+	if merr != nil {
+		err = merr
+		return
 	}
-	err = fmt.Errorf("uri(%v) is not supported(not matched dialer)", uri)
+	session, spipe := p.newSession(sid, uri, pipe, limit)
+	var failures []*DialFailure
+	for _, dialer := range dialers {
+		event := &DialEvent{SID: sid, URI: uri, Dialer: dialer.Name()}
+		err = nil
+		if authorizer != nil {
+			err = authorizer.Authorize(sid, uri, event.Dialer)
+		}
+		if err == nil {
+			err = p.beginDial(session, dialer)
+		}
+		if err == nil {
+			p.fireDialStart(event)
+			begin := time.Now()
+			r, err = DialContext(ctx, dialer, sid, uri, spipe)
+			latency := time.Since(begin)
+			p.fireDialDone(event, err)
+			if err == nil {
+				session.Dialer = event.Dialer
+				session.conn = r
+				session.Stats = connStats(r)
+				if session.Stats == nil {
+					session.Stats = NewConnStats()
+					session.ownStats = true
+				}
+				if config := connPipeConfig(r); config != nil {
+					session.Limit = config.Limit
+				}
+				session.share(p.DialerLimit(session.Dialer))
+				p.stats.dialed(session.Dialer, latency, nil, session.Stats)
+				session.Stats.OnDone(func(stats *ConnStats) {
+					p.firePipeClosed(event, stats)
+				})
+				r = &SessionConn{Conn: r, Session: session}
+				p.addSession(session)
+				p.endDial(session)
+				if p.isShutdown() {
+					//the pool is shutdown when dialing
+					r.Close()
+					r, err = nil, ErrShutdown
+				}
+				return
+			}
+			p.endDial(session)
+			p.stats.dialed(event.Dialer, latency, err, nil)
+		}
+		if len(fallback) < 1 {
+			return
+		}
+		class := ClassifyError(err)
+		failures = append(failures, &DialFailure{
+			Dialer: dialer.Name(),
+			Class:  class,
+			Err:    err,
+		})
+		if ctx.Err() != nil || !fallbackOn(fallback, class) {
+			break
+		}
+	}
+	if len(failures) > 0 {
+		err = &DialError{URI: uri, Failures: failures}
+		return
+	}
+	err = &DialError{URI: uri, Err: fmt.Errorf("no dialer matched uri(%v)", uri)}
 	return
 }
 
+//Shutdown will refuse new dials and wait all live sessions closed until ctx is done,
+//then kill the remaining sessions and shutdown all dialers which implement Shutdowner.
+//it return ctx.Err() if the sessions are killed, or the first error of shutting down dialer.
+func (p *Pool) Shutdown(ctx context.Context) (err error) {
+	p.reloadLck.Lock()
+	defer p.reloadLck.Unlock()
+	p.configLck.Lock()
+	if p.shutdown {
+		p.configLck.Unlock()
+		err = ErrShutdown
+		return
+	}
+	p.shutdown = true
+	dialers := append(append([]Dialer{}, p.Dialers...), p.draining...)
+	p.draining = nil
+	p.configLck.Unlock()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for p.used() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		for _, session := range p.Sessions() {
+			session.Kill()
+		}
+	}
+	for _, dialer := range dialers {
+		if shutdowner, ok := dialer.(Shutdowner); ok {
+			if serr := shutdowner.Shutdown(ctx); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	}
+	return
+}
+
+func (p *Pool) isShutdown() bool {
+	p.configLck.RLock()
+	defer p.configLck.RUnlock()
+	return p.shutdown
+}
+
+//Stats will return the accounting of all dialers on pool
+func (p *Pool) Stats() []*DialerStats {
+	return p.stats.Snapshot()
+}
+
+func fallbackOn(fallbacks []string, class string) bool {
+	for _, fallback := range fallbacks {
+		if fallback == "*" || fallback == class {
+			return true
+		}
+	}
+	return false
+}
+
+//DefaultDialerCreator will create the dialer by the type registered by RegisterDialerType
 func DefaultDialerCreator(t string) (dialer Dialer) {
-	switch t {
-	case "balance":
-		dialer = NewBalancedDialer()
-	case "cmd":
-		dialer = NewCmdDialer()
-	case "echo":
-		dialer = NewEchoDialer()
-	case "socks":
-		dialer = NewSocksProxyDialer()
-	case "tcp":
-		dialer = NewTCPDialer()
-	case "web":
-		dialer = NewWebDialer()
+	dtype := LookupDialerType(t)
+	if dtype != nil {
+		dialer = dtype.Factory()
 	}
 	return
 }
 
+//NewDialer is the creator to create dialer by type when Bootstrap
 var NewDialer = DefaultDialerCreator
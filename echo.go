@@ -1,18 +1,24 @@
 package dialer
 
 import (
+	"context"
 	"io"
 	"net/url"
 	"sync"
+	"sync/atomic"
 
 	"github.com/Centny/gwf/util"
 )
 
 //EchoDialer is an implementation of the Dialer interface for echo tcp connection.
 type EchoDialer struct {
-	conf util.Map
+	conf     util.Map
+	shutdown uint32
 }
 
+//EchoDialerSchema is the config schema of EchoDialer
+var EchoDialerSchema = PipeSchema.Merge()
+
 //NewEchoDialer will return new EchoDialer
 func NewEchoDialer() (dialer *EchoDialer) {
 	dialer = &EchoDialer{
@@ -27,24 +33,62 @@ func (e *EchoDialer) Name() string {
 }
 
 //Bootstrap the dialer
-func (e *EchoDialer) Bootstrap(options util.Map) error {
-	e.conf = options
-	return nil
+func (e *EchoDialer) Bootstrap(options util.Map) (err error) {
+	err = EchoDialerSchema.Validate("", options, false)
+	if err == nil {
+		e.conf = options
+	}
+	return
 }
 
 func (e *EchoDialer) Options() util.Map {
 	return e.conf
 }
 
+//Shutdown will refuse the new dial, the echo connection is not having resource to release.
+func (e *EchoDialer) Shutdown(ctx context.Context) (err error) {
+	atomic.StoreUint32(&e.shutdown, 1)
+	return
+}
+
 //Matched will return whetheer uri is invalid
 func (e *EchoDialer) Matched(uri string) bool {
 	target, err := url.Parse(uri)
 	return err == nil && target.Scheme == "tcp" && target.Host == "echo"
 }
 
+//Local will return true, the data is echoed on local host.
+func (e *EchoDialer) Local() bool {
+	return true
+}
+
 //Dial one echo connection.
 func (e *EchoDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
-	r = NewEchoReadWriteCloser()
+	r, err = e.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext one echo connection with context.
+func (e *EchoDialer) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (r Conn, err error) {
+	if atomic.LoadUint32(&e.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+	target, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	config, err := NewPipeConfig(e.conf, target.Query())
+	if err != nil {
+		return
+	}
+	echo := NewEchoReadWriteCloser()
+	echo.Config = config
+	r = echo
 	if pipe != nil {
 		err = r.Pipe(pipe)
 	}
@@ -53,62 +97,85 @@ func (e *EchoDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (r Co
 
 //EchoReadWriteCloser is an implementation of the io.ReadWriteCloser interface for pipe write to read.
 type EchoReadWriteCloser struct {
-	pipe chan []byte
-	lck  sync.RWMutex
+	Config *PipeConfig
+	pipe   chan []byte
+	closed chan int
+	rest   []byte //the data is not read by last Read
+	lck    sync.RWMutex
+	stats  *ConnStats
 }
 
 //NewEchoReadWriteCloser will return new EchoReadWriteCloser
 func NewEchoReadWriteCloser() *EchoReadWriteCloser {
 	return &EchoReadWriteCloser{
-		pipe: make(chan []byte, 1),
-		lck:  sync.RWMutex{},
+		pipe:   make(chan []byte, 1),
+		closed: make(chan int),
+		lck:    sync.RWMutex{},
+		stats:  NewConnStats(),
 	}
 }
 
 func (e *EchoReadWriteCloser) Write(p []byte) (n int, err error) {
-	if e.pipe == nil {
+	select {
+	case <-e.closed:
 		err = io.EOF
 		return
+	default:
+	}
+	//the p may be reused by caller after Write return, so it is copied
+	buf := make([]byte, len(p))
+	copy(buf, p)
+	select {
+	case e.pipe <- buf:
+		n = len(p)
+	case <-e.closed:
+		err = io.EOF
 	}
-	n = len(p)
-	e.pipe <- p[:]
 	return
 }
 
 func (e *EchoReadWriteCloser) Read(p []byte) (n int, err error) {
-	if e.pipe == nil {
-		err = io.EOF
+	if len(e.rest) > 0 {
+		n = copy(p, e.rest)
+		e.rest = e.rest[n:]
 		return
 	}
-	buf := <-e.pipe
-	if buf == nil {
+	select {
+	case buf := <-e.pipe:
+		n = copy(p, buf)
+		e.rest = buf[n:]
+	case <-e.closed:
 		err = io.EOF
-		return
 	}
-	n = copy(p, buf)
 	return
 }
 
-//Close echo read writer closer.
+//Close echo read writer closer, the blocked Read and Write return io.EOF.
 func (e *EchoReadWriteCloser) Close() (err error) {
 	e.lck.Lock()
-	if e.pipe != nil {
-		e.pipe <- nil
-		close(e.pipe)
-		e.pipe = nil
+	select {
+	case <-e.closed:
+	default:
+		close(e.closed)
 	}
 	e.lck.Unlock()
 	return
 }
 
 func (e *EchoReadWriteCloser) Pipe(raw io.ReadWriteCloser) (err error) {
-	go e.copyAndClose(e, raw)
-	go e.copyAndClose(raw, e)
+	pipeCopy(e, raw, e.stats, e.Config)
 	return
 }
 
-func (e *EchoReadWriteCloser) copyAndClose(src io.ReadWriteCloser, dst io.ReadWriteCloser) {
-	io.Copy(dst, src)
-	dst.Close()
-	src.Close()
+//Stats will return the accounting of piped connection
+func (e *EchoReadWriteCloser) Stats() *ConnStats {
+	return e.stats
+}
+
+//PipeConfig will return the config of piping, it is created if not set.
+func (e *EchoReadWriteCloser) PipeConfig() *PipeConfig {
+	if e.Config == nil {
+		e.Config = &PipeConfig{}
+	}
+	return e.Config
 }
@@ -35,4 +35,28 @@ func TestEcho(t *testing.T) {
 	conn.Close()
 	dialer.Name()
 	dialer.Options()
+	//read by small buffer and closed
+	echo := NewEchoReadWriteCloser()
+	buf := []byte("abcdef")
+	echo.Write(buf)
+	copy(buf, "xxxxxx")
+	readed := make([]byte, 6)
+	_, err = io.ReadFull(echo, readed[:4])
+	if err == nil {
+		_, err = io.ReadFull(echo, readed[4:])
+	}
+	if err != nil || string(readed) != "abcdef" {
+		t.Errorf("%v,%v", string(readed), err)
+		return
+	}
+	echo.Close()
+	echo.Close()
+	if _, err = echo.Write(buf); err != io.EOF {
+		t.Error(err)
+		return
+	}
+	if _, err = echo.Read(readed); err != io.EOF {
+		t.Error(err)
+		return
+	}
 }
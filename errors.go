@@ -0,0 +1,127 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+//the error class of dial fail
+const (
+	ErrClassCanceled    = "canceled"
+	ErrClassTimeout     = "timeout"
+	ErrClassRefused     = "refused"
+	ErrClassUnreachable = "unreachable"
+	ErrClassDNS         = "dns"
+	ErrClassProxy       = "proxy"
+	ErrClassUnsupported = "unsupported"
+	ErrClassDenied      = "denied"
+	ErrClassOther       = "other"
+)
+
+//ClassifyError will return the error class of dial fail
+func ClassifyError(err error) string {
+	for err != nil {
+		switch e := err.(type) {
+		case *DialError:
+			if len(e.Failures) < 1 {
+				return ErrClassUnsupported
+			}
+			err = e.Failures[len(e.Failures)-1].Err
+			continue
+		case *CodeError:
+			return codeClass(e.Code())
+		case *ACLError, *AuthError:
+			return ErrClassDenied
+		case *net.DNSError:
+			return ErrClassDNS
+		case syscall.Errno:
+			switch e {
+			case syscall.ECONNREFUSED:
+				return ErrClassRefused
+			case syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+				return ErrClassUnreachable
+			case syscall.ETIMEDOUT:
+				return ErrClassTimeout
+			}
+			return ErrClassOther
+		case *net.OpError:
+			if e.Timeout() {
+				return ErrClassTimeout
+			}
+			err = e.Err
+			continue
+		case *os.SyscallError:
+			err = e.Err
+			continue
+		}
+		switch err {
+		case context.Canceled:
+			return ErrClassCanceled
+		case context.DeadlineExceeded:
+			return ErrClassTimeout
+		}
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			return ErrClassTimeout
+		}
+		break
+	}
+	return ErrClassOther
+}
+
+//codeClass will return the error class of status code which is responded by handshake/mux remote.
+func codeClass(code byte) string {
+	switch code {
+	case CodeCanceled:
+		return ErrClassCanceled
+	case CodeTimeout:
+		return ErrClassTimeout
+	case CodeRefused:
+		return ErrClassRefused
+	case CodeUnreachable:
+		return ErrClassUnreachable
+	case CodeDNS:
+		return ErrClassDNS
+	case CodeUnsupported:
+		return ErrClassUnsupported
+	case CodeDenied:
+		return ErrClassDenied
+	case CodeOther:
+		return ErrClassOther
+	default:
+		//the proxy, bad request and shutdown is the fail of remote server
+		return ErrClassProxy
+	}
+}
+
+//DialFailure is the fail info of one dialer.
+type DialFailure struct {
+	Dialer string
+	Class  string
+	Err    error
+}
+
+//DialError is the aggregated error of all dialers which is tried to dial,
+//it is classified as unsupported if no dialer is tried.
+type DialError struct {
+	URI      string
+	Failures []*DialFailure
+	Err      error //the cause of no dialer is tried
+}
+
+func (d *DialError) Error() string {
+	if len(d.Failures) < 1 {
+		if d.Err != nil {
+			return fmt.Sprintf("dial to %v fail: %v", d.URI, d.Err)
+		}
+		return fmt.Sprintf("uri(%v) is not supported(not matched dialer)", d.URI)
+	}
+	msgs := []string{}
+	for _, failure := range d.Failures {
+		msgs = append(msgs, fmt.Sprintf("dialer(%v) %v fail with %v", failure.Dialer, failure.Class, failure.Err))
+	}
+	return fmt.Sprintf("dial to %v fail: %v", d.URI, strings.Join(msgs, "; "))
+}
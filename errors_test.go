@@ -0,0 +1,64 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := map[string]error{
+		ErrClassCanceled:    context.Canceled,
+		ErrClassTimeout:     context.DeadlineExceeded,
+		ErrClassRefused:     &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+		ErrClassUnreachable: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)},
+		ErrClassDNS:         &net.OpError{Op: "dial", Err: &net.DNSError{Name: "xx"}},
+		ErrClassProxy:       &CodeError{Inner: fmt.Errorf("xx"), ByteCode: 0x10},
+		ErrClassUnsupported: &DialError{URI: "xx"},
+		ErrClassOther:       fmt.Errorf("xx"),
+	}
+	for class, err := range cases {
+		if ClassifyError(err) != class {
+			t.Errorf("%v->%v", class, ClassifyError(err))
+			return
+		}
+	}
+	if ClassifyError(syscall.EPERM) != ErrClassOther {
+		t.Error("error")
+		return
+	}
+	if ClassifyError(&DialError{URI: "xx", Failures: []*DialFailure{{Err: context.Canceled}}}) != ErrClassCanceled {
+		t.Error("error")
+		return
+	}
+	_, err := net.Dial("tcp", "127.0.0.1:1")
+	if ClassifyError(err) != ErrClassRefused {
+		t.Error(err)
+		return
+	}
+	//the code responded by remote
+	codes := map[byte]string{
+		CodeOther:       ErrClassOther,
+		CodeBadRequest:  ErrClassProxy,
+		CodeDenied:      ErrClassDenied,
+		CodeRefused:     ErrClassRefused,
+		CodeUnreachable: ErrClassUnreachable,
+		CodeTimeout:     ErrClassTimeout,
+		CodeDNS:         ErrClassDNS,
+		CodeCanceled:    ErrClassCanceled,
+		CodeUnsupported: ErrClassUnsupported,
+		CodeShutdown:    ErrClassProxy,
+		CodeProxy:       ErrClassProxy,
+	}
+	for code, class := range codes {
+		cerr := &CodeError{Inner: fmt.Errorf("xx"), ByteCode: code}
+		if ClassifyError(cerr) != class || ClassifyError(&DialError{URI: "xx", Failures: []*DialFailure{{Err: cerr}}}) != class {
+			t.Errorf("%v->%v", code, ClassifyError(cerr))
+			return
+		}
+	}
+	fmt.Println(&DialError{URI: "xx", Failures: []*DialFailure{{Dialer: "a", Class: ErrClassOther, Err: fmt.Errorf("xx")}}})
+}
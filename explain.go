@@ -0,0 +1,79 @@
+package dialer
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/Centny/gwf/util"
+)
+
+//Explanation is the dry-run routing result of one uri on Pool.
+type Explanation struct {
+	URI     string
+	Chosen  string           //the dialer name which would be chosen, empty if not dialer chosen
+	Error   string           //the error message when uri is not routed
+	Dialers []*DialerExplain //all dialers on pool by order
+}
+
+//DialerExplain is the routing decision of one dialer on Pool.
+type DialerExplain struct {
+	Index    int
+	Name     string
+	Matched  bool             //whether the Matched of dialer accept the uri
+	Routes   []string         //the route names which route uri to this dialer
+	Order    int              //the dial order when fallback, 0 is not dialed
+	Chosen   bool             //whether the dialer would be chosen
+	Balanced *BalancedExplain //the decision of balanced dialer
+}
+
+//Explain will return the routing decision of uri without dialing.
+func (p *Pool) Explain(uri string) (explain *Explanation) {
+	explain = &Explanation{URI: uri}
+	p.configLck.RLock()
+	acl, routes, all := p.ACL, p.Routes, append([]Dialer{}, p.Dialers...)
+	dialers, err := p.matchDialers(uri)
+	p.configLck.RUnlock()
+	if acl != nil {
+		if aerr := acl.check(context.Background(), uri, networkDialers(dialers)); aerr != nil {
+			dialers, err = nil, aerr
+		}
+	}
+	if err != nil {
+		explain.Error = err.Error()
+	}
+	target, _ := url.Parse(uri)
+	for index, dialer := range all {
+		name := dialer.Name()
+		dexplain := &DialerExplain{
+			Index:   index,
+			Name:    name,
+			Matched: dialer.Matched(uri),
+		}
+		if routes != nil && target != nil {
+			for _, route := range routes.Routes {
+				if route.Dialer == name && route.Match(target) {
+					dexplain.Routes = append(dexplain.Routes, route.Name)
+				}
+			}
+		}
+		for order, candidate := range dialers {
+			if candidate == dialer {
+				dexplain.Order = order + 1
+				dexplain.Chosen = order == 0
+				break
+			}
+		}
+		if dexplain.Chosen {
+			explain.Chosen = name
+		}
+		if balanced, ok := dialer.(*BalancedDialer); ok {
+			dexplain.Balanced = balanced.Explain(uri)
+		}
+		explain.Dialers = append(explain.Dialers, dexplain)
+	}
+	return
+}
+
+func (e *Explanation) String() string {
+	return util.S2Json(e)
+}
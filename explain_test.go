@@ -0,0 +1,85 @@
+package dialer
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestExplain(t *testing.T) {
+	balanced := NewBalancedDialer()
+	balanced.ID = "b0"
+	balanced.matcher = regexp.MustCompile("^time.*$")
+	balanced.AddDialer(
+		&TimeDialer{ID: "i0", conf: util.Map{"limit": []int{100000, 1}}},
+		&TimeDialer{ID: "i1", conf: util.Map{"limit": []int{100000, 1}}},
+	)
+	balanced.AddPolicy("^time-host.*$", []int64{100000, 1})
+	balanced.AddFilter("^time-deny.*$", 0)
+	pool := NewPool()
+	pool.AddDialer(balanced, NewEchoDialer(), NewTCPDialer())
+	//
+	explain := pool.Explain("tcp://echo")
+	if explain.Chosen != "echo" || explain.Dialers[0].Matched || !explain.Dialers[1].Chosen ||
+		explain.Dialers[2].Chosen || !explain.Dialers[2].Matched {
+		t.Error(explain)
+		return
+	}
+	fmt.Println(explain)
+	//
+	explain = pool.Explain("time://x")
+	if explain.Chosen != "b0" || explain.Dialers[0].Balanced == nil || len(explain.Dialers[0].Balanced.Chosen) < 1 {
+		t.Error(explain)
+		return
+	}
+	_, err := balanced.Dial(10, "time://x", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	explain = pool.Explain("time://x")
+	if bexplain := explain.Dialers[0].Balanced; bexplain.Chosen != bexplain.Children[0].Name || !bexplain.Children[1].Limited {
+		t.Error(explain)
+		return
+	}
+	fmt.Println(explain)
+	//policy
+	_, err = balanced.Dial(10, "time-host://x", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	explain = pool.Explain("time-host://x")
+	if bexplain := explain.Dialers[0].Balanced; len(bexplain.Chosen) > 0 || bexplain.Policy != "^time-host.*$" {
+		t.Error(explain)
+		return
+	}
+	//filter
+	explain = pool.Explain("time-deny://x")
+	if bexplain := explain.Dialers[0].Balanced; bexplain.Access || len(bexplain.Chosen) > 0 {
+		t.Error(explain)
+		return
+	}
+	//routes
+	pool.Routes = NewRouteTable()
+	pool.Routes.AddRoute(&Route{Name: "r0", Dialer: "tcp", Scheme: "tcp"})
+	explain = pool.Explain("tcp://echo")
+	if explain.Chosen != "tcp" || len(explain.Dialers[2].Routes) != 1 {
+		t.Error(explain)
+		return
+	}
+	explain = pool.Explain("http://echo")
+	if len(explain.Chosen) > 0 || len(explain.Error) < 1 {
+		t.Error(explain)
+		return
+	}
+	//
+	//test error
+	explain = pool.Explain("%X")
+	if len(explain.Error) < 1 || len(explain.Dialers[0].Balanced.Error) < 1 {
+		t.Error(explain)
+		return
+	}
+}
@@ -0,0 +1,260 @@
+package dialer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Centny/gwf/log"
+)
+
+//HandshakeVersion is the version of handshake protocol
+const HandshakeVersion = 1
+
+//the status code of handshake response, it is also the byte code of CodeError
+const (
+	CodeOK          byte = 0x00
+	CodeOther       byte = 0x01 //the other dial error
+	CodeBadRequest  byte = 0x02 //the handshake request is invalid
+	CodeDenied      byte = 0x03 //the uri is denied by acl or authorizer
+	CodeRefused     byte = 0x04 //the remote refused the connection
+	CodeUnreachable byte = 0x05 //the remote host or network is unreachable
+	CodeTimeout     byte = 0x06 //the dial is timeout
+	CodeDNS         byte = 0x07 //the host is not resolved
+	CodeCanceled    byte = 0x08 //the dial is canceled
+	CodeUnsupported byte = 0x09 //not dialer matched the uri
+	CodeShutdown    byte = 0x0a //the pool is shutdown
+	CodeProxy       byte = 0x10 //the proxy server fail, like socks proxy
+)
+
+var classCodes = map[string]byte{
+	ErrClassCanceled:    CodeCanceled,
+	ErrClassTimeout:     CodeTimeout,
+	ErrClassRefused:     CodeRefused,
+	ErrClassUnreachable: CodeUnreachable,
+	ErrClassDNS:         CodeDNS,
+	ErrClassProxy:       CodeProxy,
+	ErrClassUnsupported: CodeUnsupported,
+	ErrClassDenied:      CodeDenied,
+}
+
+//ErrorCode will return the status code of dial error, the code of *CodeError is returned directly.
+func ErrorCode(err error) byte {
+	if err == nil {
+		return CodeOK
+	}
+	if err == ErrShutdown {
+		return CodeShutdown
+	}
+	for {
+		derr, ok := err.(*DialError)
+		if !ok || len(derr.Failures) < 1 {
+			break
+		}
+		err = derr.Failures[len(derr.Failures)-1].Err
+	}
+	if cerr, ok := err.(*CodeError); ok {
+		return cerr.Code()
+	}
+	if code, ok := classCodes[ClassifyError(err)]; ok {
+		return code
+	}
+	return CodeOther
+}
+
+//WriteHandshakeRequest will write the handshake request, the frame is:
+//	| version(1) | sid(8) | uri length(2) | uri |
+//the integer is big endian.
+func WriteHandshakeRequest(w io.Writer, sid uint64, uri string) (err error) {
+	if len(uri) < 1 || len(uri) > 0xffff {
+		err = fmt.Errorf("the uri length must be in 1-65535, but %v", len(uri))
+		return
+	}
+	buf := make([]byte, 11+len(uri))
+	buf[0] = HandshakeVersion
+	binary.BigEndian.PutUint64(buf[1:], sid)
+	binary.BigEndian.PutUint16(buf[9:], uint16(len(uri)))
+	copy(buf[11:], uri)
+	_, err = w.Write(buf)
+	return
+}
+
+//ReadHandshakeRequest will read the handshake request which is written by WriteHandshakeRequest.
+func ReadHandshakeRequest(r io.Reader) (sid uint64, uri string, err error) {
+	buf := make([]byte, 11)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return
+	}
+	if buf[0] != HandshakeVersion {
+		err = fmt.Errorf("the handshake version(%v) is not supported", buf[0])
+		return
+	}
+	sid = binary.BigEndian.Uint64(buf[1:])
+	length := binary.BigEndian.Uint16(buf[9:])
+	if length < 1 {
+		err = fmt.Errorf("the handshake uri is empty")
+		return
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	uri = string(data)
+	return
+}
+
+//WriteHandshakeResponse will write the handshake response by dial error, the frame is:
+//	| status(1) |                               on success
+//	| status(1) | message length(2) | message | on fail
+func WriteHandshakeResponse(w io.Writer, dialErr error) (err error) {
+	code := ErrorCode(dialErr)
+	if code == CodeOK {
+		_, err = w.Write([]byte{CodeOK})
+		return
+	}
+	msg := dialErr.Error()
+	if len(msg) > 0xffff {
+		msg = msg[:0xffff]
+	}
+	buf := make([]byte, 3+len(msg))
+	buf[0] = code
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(msg)))
+	copy(buf[3:], msg)
+	_, err = w.Write(buf)
+	return
+}
+
+//ReadHandshakeResponse will read the handshake response, it return *CodeError having status code if dial fail on server.
+func ReadHandshakeResponse(r io.Reader) (err error) {
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf[:1])
+	if err != nil || buf[0] == CodeOK {
+		return
+	}
+	_, err = io.ReadFull(r, buf[1:])
+	if err != nil {
+		return
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(buf[1:]))
+	_, err = io.ReadFull(r, msg)
+	if err != nil {
+		return
+	}
+	err = &CodeError{Inner: fmt.Errorf("%s", msg), ByteCode: buf[0]}
+	return
+}
+
+//DialHandshake will connect to the handshake server and dial uri by sid, the returned connection is piped to remote.
+func DialHandshake(ctx context.Context, network, address string, sid uint64, uri string) (conn net.Conn, err error) {
+	var dialer net.Dialer
+	conn, err = dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return
+	}
+	stop := watchContext(ctx, conn)
+	err = WriteHandshakeRequest(conn, sid, uri)
+	if err == nil {
+		err = ReadHandshakeResponse(conn)
+	}
+	if stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		conn = nil
+	}
+	return
+}
+
+//HandshakeServer is the server to serve Pool by handshake protocol,
+//the client sends the handshake request and receives the response, then the connection is piped by Pool.Dial.
+//the sid of handshake request is supplied by client and it is not verified,
+//so the Authorizer or StaticRule by sid is not protection for it, the server should be listened on trusted network or unix socket.
+type HandshakeServer struct {
+	Pool    *Pool
+	Timeout time.Duration //the timeout of reading request and dialing, default is 10s
+	lck     sync.Mutex
+	ls      map[net.Listener]bool
+}
+
+//NewHandshakeServer will return new HandshakeServer by pool
+func NewHandshakeServer(pool *Pool) *HandshakeServer {
+	return &HandshakeServer{
+		Pool:    pool,
+		Timeout: 10 * time.Second,
+		lck:     sync.Mutex{},
+		ls:      map[net.Listener]bool{},
+	}
+}
+
+//Serve will accept the connection on listener and serve it, it return when listener is closed.
+func (h *HandshakeServer) Serve(l net.Listener) (err error) {
+	h.lck.Lock()
+	h.ls[l] = true
+	h.lck.Unlock()
+	defer func() {
+		h.lck.Lock()
+		delete(h.ls, l)
+		h.lck.Unlock()
+	}()
+	log.D("HandshakeServer start serve on %v", l.Addr())
+	var conn net.Conn
+	for {
+		conn, err = l.Accept()
+		if err != nil {
+			break
+		}
+		go h.ServeConn(conn)
+	}
+	log.D("HandshakeServer serve on %v is stopped by %v", l.Addr(), err)
+	return
+}
+
+//ServeConn will read the handshake request and pipe the connection by Pool.
+func (h *HandshakeServer) ServeConn(conn net.Conn) (err error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	sid, uri, err := ReadHandshakeRequest(conn)
+	if err != nil {
+		WriteHandshakeResponse(conn, &CodeError{Inner: err, ByteCode: CodeBadRequest})
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	raw, err := h.Pool.DialContext(ctx, sid, uri, nil)
+	cancel()
+	if err != nil {
+		log.D("HandshakeServer dial %v by sid(%v) fail with %v", uri, sid, err)
+		WriteHandshakeResponse(conn, err)
+		conn.Close()
+		return
+	}
+	err = WriteHandshakeResponse(conn, nil)
+	if err == nil {
+		err = raw.Pipe(conn)
+	}
+	if err != nil {
+		raw.Close()
+		conn.Close()
+	}
+	return
+}
+
+//Close will close all serving listeners, the piped connections are not closed.
+func (h *HandshakeServer) Close() (err error) {
+	h.lck.Lock()
+	defer h.lck.Unlock()
+	for l := range h.ls {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
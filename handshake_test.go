@@ -0,0 +1,189 @@
+package dialer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestHandshakeFrame(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := WriteHandshakeRequest(buf, 100, "tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sid, uri, err := ReadHandshakeRequest(buf)
+	if err != nil || sid != 100 || uri != "tcp://echo" {
+		t.Errorf("%v,%v,%v", sid, uri, err)
+		return
+	}
+	//response
+	for _, dialErr := range []error{
+		nil,
+		ErrShutdown,
+		&DialError{URI: "x"},
+		&ACLError{URI: "x"},
+		&DialError{URI: "x", Failures: []*DialFailure{{Err: &CodeError{Inner: fmt.Errorf("proxy"), ByteCode: CodeProxy}}}},
+		context.DeadlineExceeded,
+		fmt.Errorf("other"),
+	} {
+		buf.Reset()
+		err = WriteHandshakeResponse(buf, dialErr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = ReadHandshakeResponse(buf)
+		if dialErr == nil {
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			continue
+		}
+		cerr, ok := err.(*CodeError)
+		if !ok || cerr.Code() != ErrorCode(dialErr) || cerr.Error() != dialErr.Error() {
+			t.Errorf("%v,%v", dialErr, err)
+			return
+		}
+	}
+	codes := []byte{
+		ErrorCode(ErrShutdown), ErrorCode(&DialError{URI: "x"}), ErrorCode(&AuthError{}),
+		ErrorCode(context.Canceled), ErrorCode(fmt.Errorf("other")),
+	}
+	if fmt.Sprintf("%v", codes) != fmt.Sprintf("%v", []byte{CodeShutdown, CodeUnsupported, CodeDenied, CodeCanceled, CodeOther}) {
+		t.Error(codes)
+		return
+	}
+	//error
+	if err = WriteHandshakeRequest(buf, 1, ""); err == nil {
+		t.Error(err)
+		return
+	}
+	if err = WriteHandshakeRequest(buf, 1, strings.Repeat("x", 0x10000)); err == nil {
+		t.Error(err)
+		return
+	}
+	for _, data := range [][]byte{
+		{},
+		{2, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 'x'},
+		{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0},
+		{1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2, 'x'},
+	} {
+		_, _, err = ReadHandshakeRequest(bytes.NewBuffer(data))
+		if err == nil {
+			t.Error(data)
+			return
+		}
+	}
+	for _, data := range [][]byte{{}, {1}, {1, 0, 2, 'x'}} {
+		err = ReadHandshakeResponse(bytes.NewBuffer(data))
+		if _, ok := err.(*CodeError); ok || err == nil {
+			t.Error(data)
+			return
+		}
+	}
+}
+
+func TestHandshakeServer(t *testing.T) {
+	pool := NewPool()
+	err := pool.Bootstrap(util.Map{
+		"echo": 1,
+		"acl": util.Map{
+			"rules": []util.Map{{"action": "deny", "host": "deny"}},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	server := NewHandshakeServer(pool)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+	address := l.Addr().String()
+	conn, err := DialHandshake(context.Background(), "tcp", address, 1, "tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(conn, "abc")
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", string(buf), err)
+		return
+	}
+	if pool.Session(1) == nil {
+		t.Error("error")
+		return
+	}
+	conn.Close()
+	//dial fail
+	_, err = DialHandshake(context.Background(), "tcp", address, 2, "tcp://deny")
+	if cerr, ok := err.(*CodeError); !ok || cerr.Code() != CodeDenied {
+		t.Error(err)
+		return
+	}
+	_, err = DialHandshake(context.Background(), "tcp", address, 2, "xx://none")
+	if cerr, ok := err.(*CodeError); !ok || cerr.Code() != CodeUnsupported {
+		t.Error(err)
+		return
+	}
+	//bad request
+	raw, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	raw.Write([]byte{9, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 'x'})
+	err = ReadHandshakeResponse(raw)
+	if cerr, ok := err.(*CodeError); !ok || cerr.Code() != CodeBadRequest {
+		t.Error(err)
+		return
+	}
+	raw.Close()
+	//request timeout
+	timeouted := NewHandshakeServer(pool)
+	timeouted.Timeout = 50 * time.Millisecond
+	local, remote := net.Pipe()
+	go timeouted.ServeConn(remote)
+	err = ReadHandshakeResponse(local)
+	if cerr, ok := err.(*CodeError); !ok || cerr.Code() != CodeBadRequest {
+		t.Error(err)
+		return
+	}
+	local.Close()
+	//context canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DialHandshake(ctx, "tcp", address, 3, "tcp://echo")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	server.Close()
+	if err = <-served; err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = DialHandshake(context.Background(), "tcp", address, 3, "tcp://echo")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,72 @@
+package dialer
+
+import (
+	"fmt"
+	"sync"
+)
+
+//DialEvent is the event info of dialing on Pool
+type DialEvent struct {
+	SID    uint64
+	URI    string
+	Dialer string
+}
+
+func (d *DialEvent) String() string {
+	return fmt.Sprintf("%v->%v(%v)", d.SID, d.URI, d.Dialer)
+}
+
+type poolHooks struct {
+	dialStart  []func(event *DialEvent)
+	dialDone   []func(event *DialEvent, err error)
+	pipeClosed []func(event *DialEvent, stats *ConnStats)
+	lck        sync.RWMutex
+}
+
+//OnDialStart will add the hook which is called before one dialer dial the uri.
+func (p *Pool) OnDialStart(hook func(event *DialEvent)) {
+	p.hooks.lck.Lock()
+	p.hooks.dialStart = append(p.hooks.dialStart, hook)
+	p.hooks.lck.Unlock()
+}
+
+//OnDialDone will add the hook which is called after one dialer dial the uri, the err is nil when success.
+func (p *Pool) OnDialDone(hook func(event *DialEvent, err error)) {
+	p.hooks.lck.Lock()
+	p.hooks.dialDone = append(p.hooks.dialDone, hook)
+	p.hooks.lck.Unlock()
+}
+
+//OnPipeClosed will add the hook which is called after the session connection is closed.
+func (p *Pool) OnPipeClosed(hook func(event *DialEvent, stats *ConnStats)) {
+	p.hooks.lck.Lock()
+	p.hooks.pipeClosed = append(p.hooks.pipeClosed, hook)
+	p.hooks.lck.Unlock()
+}
+
+func (p *Pool) fireDialStart(event *DialEvent) {
+	p.hooks.lck.RLock()
+	hooks := p.hooks.dialStart
+	p.hooks.lck.RUnlock()
+	for _, hook := range hooks {
+		hook(event)
+	}
+}
+
+func (p *Pool) fireDialDone(event *DialEvent, err error) {
+	p.hooks.lck.RLock()
+	hooks := p.hooks.dialDone
+	p.hooks.lck.RUnlock()
+	for _, hook := range hooks {
+		hook(event, err)
+	}
+}
+
+func (p *Pool) firePipeClosed(event *DialEvent, stats *ConnStats) {
+	p.hooks.lck.RLock()
+	hooks := p.hooks.pipeClosed
+	p.hooks.lck.RUnlock()
+	for _, hook := range hooks {
+		hook(event, stats)
+	}
+}
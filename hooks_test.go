@@ -0,0 +1,61 @@
+package dialer
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestPoolHooks(t *testing.T) {
+	pool := NewPool()
+	pool.AddDialer(&FailDialer{OnceDialer{ID: "fail", conf: util.Map{}}}, NewEchoDialer())
+	pool.Fallback = []string{"*"}
+	var events []string
+	lck := sync.Mutex{}
+	record := func(format string, args ...interface{}) {
+		lck.Lock()
+		events = append(events, fmt.Sprintf(format, args...))
+		lck.Unlock()
+	}
+	pool.OnDialStart(func(event *DialEvent) {
+		record("start:%v", event)
+	})
+	pool.OnDialDone(func(event *DialEvent, err error) {
+		record("done:%v:%v", event, err)
+	})
+	pool.OnPipeClosed(func(event *DialEvent, stats *ConnStats) {
+		record("closed:%v:%v", event, stats.Up)
+	})
+	pool.OnPipeClosed(func(event *DialEvent, stats *ConnStats) {
+		record("closed2:%v", event.Dialer)
+	})
+	local, remote := net.Pipe()
+	_, err := pool.Dial(1, "tcp://echo", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(local, "abc")
+	buf := make([]byte, 1024)
+	local.Read(buf)
+	local.Close()
+	time.Sleep(100 * time.Millisecond)
+	lck.Lock()
+	defer lck.Unlock()
+	expect := []string{
+		"start:1->tcp://echo(fail)",
+		"done:1->tcp://echo(fail):fail",
+		"start:1->tcp://echo(echo)",
+		"done:1->tcp://echo(echo):<nil>",
+		"closed:1->tcp://echo(echo):3",
+		"closed2:echo",
+	}
+	if fmt.Sprintf("%v", events) != fmt.Sprintf("%v", expect) {
+		t.Error(events)
+		return
+	}
+}
@@ -0,0 +1,272 @@
+package dialer
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Centny/gwf/log"
+)
+
+//ProxyAuthenticator is the interface to authenticate the user of http proxy by Proxy-Authorization.
+type ProxyAuthenticator interface {
+	//return the sid of user if authenticated, the sid is used to dial by Pool, so the Authorizer rules of sid are applied.
+	Authenticate(username, password string) (sid uint64, err error)
+}
+
+//ProxyAuthenticatorF is the func implementation of ProxyAuthenticator
+type ProxyAuthenticatorF func(username, password string) (sid uint64, err error)
+
+//Authenticate will call the func
+func (p ProxyAuthenticatorF) Authenticate(username, password string) (sid uint64, err error) {
+	return p(username, password)
+}
+
+//ProxyUser is the user of http proxy, the authenticated user dials by SID.
+//all connections of one user are having the same sid, so the Pool.Kill and Pool.SetRate by sid are applied to all of them.
+type ProxyUser struct {
+	Password string
+	SID      uint64
+}
+
+//StaticProxyAuthenticator is an implementation of the ProxyAuthenticator interface by static users, the key is username.
+type StaticProxyAuthenticator map[string]*ProxyUser
+
+//Authenticate will return the sid of user if the password is matched.
+func (s StaticProxyAuthenticator) Authenticate(username, password string) (sid uint64, err error) {
+	user, ok := s[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		err = fmt.Errorf("the proxy user(%v) is not authenticated", username)
+		return
+	}
+	sid = user.SID
+	return
+}
+
+//ParseProxyUser will parse the proxy user from string like name:password:sid
+func ParseProxyUser(user string) (name string, proxyUser *ProxyUser, err error) {
+	parts := strings.SplitN(user, ":", 3)
+	if len(parts) < 3 || len(parts[0]) < 1 {
+		err = fmt.Errorf("the proxy user(%v) is invalid, it must be like name:password:sid", user)
+		return
+	}
+	sid, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		err = fmt.Errorf("the proxy user(%v) sid is invalid", user)
+		return
+	}
+	name, proxyUser = parts[0], &ProxyUser{Password: parts[1], SID: sid}
+	return
+}
+
+type httpProxySIDKey struct{}
+
+//HTTPProxyServer is the http proxy server to serve Pool, it accepts CONNECT and absolute-URI plain http request,
+//the target host:port is mapped to uri like tcp://host:port and dialed by Pool,
+//so the balance, socks chaining, acl and authorizer of Pool are applied.
+type HTTPProxyServer struct {
+	Pool    *Pool
+	Timeout time.Duration      //the timeout of dialing, default is 10s
+	Auth    ProxyAuthenticator //the authenticator of Proxy-Authorization, nil is not authentication required
+	SID     uint64             //the sid to dial when Auth is nil
+	Realm   string             //the realm of Proxy-Authenticate
+	//the func to map target host:port to dial uri, default is tcp://host:port
+	Mapper func(target string) (uri string)
+	server *http.Server
+	proxy  *httputil.ReverseProxy
+}
+
+//NewHTTPProxyServer will return new HTTPProxyServer by pool
+func NewHTTPProxyServer(pool *Pool) (h *HTTPProxyServer) {
+	h = &HTTPProxyServer{
+		Pool:    pool,
+		Timeout: 10 * time.Second,
+		Realm:   "dialer",
+	}
+	h.server = &http.Server{
+		Handler: h,
+		//the read/write timeout is not set, because the hijacked tunnel is long-lived
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	h.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {},
+		Transport: &http.Transport{
+			DialContext: h.dialHTTP,
+			//the connection can't be reused by other sid
+			DisableKeepAlives: true,
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.D("HTTPProxyServer proxy %v fail with %v", req.URL, err)
+			http.Error(w, err.Error(), httpProxyStatus(err))
+		},
+	}
+	return
+}
+
+//httpProxyStatus will return the http status code by dial error.
+func httpProxyStatus(err error) int {
+	switch ErrorCode(err) {
+	case CodeDenied:
+		return http.StatusForbidden
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	case CodeShutdown:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+//Serve will accept the connection on listener and serve it, it return when listener is closed or server is shutdown.
+func (h *HTTPProxyServer) Serve(l net.Listener) (err error) {
+	log.D("HTTPProxyServer start serve on %v", l.Addr())
+	err = h.server.Serve(l)
+	log.D("HTTPProxyServer serve on %v is stopped by %v", l.Addr(), err)
+	return
+}
+
+//ServeHTTP will serve the CONNECT and absolute-URI plain http request.
+func (h *HTTPProxyServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	sid, err := h.authenticate(req)
+	if err != nil {
+		log.D("HTTPProxyServer authenticate from %v fail with %v", req.RemoteAddr, err)
+		w.Header().Set("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q", h.Realm))
+		http.Error(w, err.Error(), http.StatusProxyAuthRequired)
+		return
+	}
+	if req.Method == http.MethodConnect {
+		h.serveConnect(w, req, sid)
+		return
+	}
+	if !req.URL.IsAbs() || len(req.URL.Host) < 1 {
+		http.Error(w, "the absolute-URI request is required", http.StatusBadRequest)
+		return
+	}
+	req = req.WithContext(context.WithValue(req.Context(), httpProxySIDKey{}, sid))
+	h.proxy.ServeHTTP(w, req)
+}
+
+//authenticate will return the sid by Proxy-Authorization.
+func (h *HTTPProxyServer) authenticate(req *http.Request) (sid uint64, err error) {
+	if h.Auth == nil {
+		sid = h.SID
+		return
+	}
+	authorization := req.Header.Get("Proxy-Authorization")
+	if len(authorization) < 1 {
+		err = fmt.Errorf("the proxy authorization is required")
+		return
+	}
+	//parse basic auth by http.Request
+	auth := &http.Request{Header: http.Header{"Authorization": {authorization}}}
+	username, password, ok := auth.BasicAuth()
+	if !ok {
+		err = fmt.Errorf("the proxy authorization is invalid")
+		return
+	}
+	sid, err = h.Auth.Authenticate(username, password)
+	return
+}
+
+//mapURI will return the dial uri of target host:port
+func (h *HTTPProxyServer) mapURI(target string) string {
+	if h.Mapper != nil {
+		return h.Mapper(target)
+	}
+	return "tcp://" + target
+}
+
+func (h *HTTPProxyServer) dial(ctx context.Context, sid uint64, target string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	raw, err = h.Pool.DialContext(ctx, sid, h.mapURI(target), pipe)
+	cancel()
+	return
+}
+
+//dialHTTP will dial the target of plain http request by Pool, it is used by transport.
+func (h *HTTPProxyServer) dialHTTP(ctx context.Context, network, address string) (conn net.Conn, err error) {
+	sid, _ := ctx.Value(httpProxySIDKey{}).(uint64)
+	local, remote := net.Pipe()
+	_, err = h.dial(ctx, sid, address, remote)
+	if err != nil {
+		local.Close()
+		remote.Close()
+		return
+	}
+	conn = local
+	return
+}
+
+//serveConnect will dial the target of CONNECT request, then the hijacked connection is piped to remote.
+func (h *HTTPProxyServer) serveConnect(w http.ResponseWriter, req *http.Request, sid uint64) {
+	target := req.URL.Host
+	if len(target) < 1 {
+		target = req.Host
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		http.Error(w, fmt.Sprintf("the CONNECT target(%v) is invalid", target), http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "the hijacking is not supported", http.StatusInternalServerError)
+		return
+	}
+	raw, err := h.dial(req.Context(), sid, target, nil)
+	if err != nil {
+		log.D("HTTPProxyServer dial %v by sid(%v) fail with %v", target, sid, err)
+		http.Error(w, err.Error(), httpProxyStatus(err))
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		raw.Close()
+		return
+	}
+	_, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err == nil && buf.Reader.Buffered() > 0 {
+		err = raw.Pipe(&httpProxyConn{Conn: conn, reader: buf.Reader})
+	} else if err == nil {
+		err = raw.Pipe(conn)
+	}
+	if err != nil {
+		raw.Close()
+		conn.Close()
+	}
+}
+
+//Shutdown will close all serving listeners and wait the plain http requests done until ctx done,
+//the piped connections of CONNECT are not closed.
+func (h *HTTPProxyServer) Shutdown(ctx context.Context) (err error) {
+	err = h.server.Shutdown(ctx)
+	return
+}
+
+//httpProxyConn is the hijacked connection, the data buffered by server is read first.
+type httpProxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (h *httpProxyConn) Read(p []byte) (n int, err error) {
+	n, err = h.reader.Read(p)
+	return
+}
+
+//CloseWrite will close the writing of the hijacked connection, it is used by pipe to forward the half close.
+func (h *httpProxyConn) CloseWrite() error {
+	return closeWrite(h.Conn)
+}
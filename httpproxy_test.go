@@ -0,0 +1,300 @@
+package dialer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func proxyGet(proxy, target string) (status int, body string, err error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	res, err := client.Get(target)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	status, body = res.StatusCode, string(data)
+	return
+}
+
+func proxyConnect(address, target, authorization string) (conn net.Conn, reader *bufio.Reader, status int, err error) {
+	conn, err = net.Dial("tcp", address)
+	if err != nil {
+		return
+	}
+	req := fmt.Sprintf("CONNECT %v HTTP/1.1\r\nHost: %v\r\n", target, target)
+	if len(authorization) > 0 {
+		req += "Proxy-Authorization: " + authorization + "\r\n"
+	}
+	//the data after request is sent before connected
+	_, err = fmt.Fprintf(conn, "%v\r\nabc", req)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	reader = bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	status = res.StatusCode
+	return
+}
+
+func TestHTTPProxyServer(t *testing.T) {
+	//the web server
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.Header.Get("Proxy-Authorization")) > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "hello")
+	}))
+	defer web.Close()
+	pool := NewPool()
+	err := pool.Bootstrap(util.Map{
+		"dialers": []util.Map{
+			{"type": "echo"},
+			{"type": "tcp"},
+		},
+		"authorizer": util.Map{
+			"rules": []util.Map{
+				{"sid": "1", "allow": []string{"*"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer pool.Shutdown(context.Background())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	server := NewHTTPProxyServer(pool)
+	server.Auth = StaticProxyAuthenticator{
+		"u1": {Password: "p1", SID: 1},
+		"u2": {Password: "p2", SID: 2},
+	}
+	server.Mapper = func(target string) string {
+		if target == "echo:80" {
+			return "tcp://echo"
+		}
+		return "tcp://" + target
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+	address := l.Addr().String()
+	//plain http
+	status, body, err := proxyGet("http://u1:p1@"+address, web.URL)
+	if err != nil || status != http.StatusOK || body != "hello" {
+		t.Errorf("%v,%v,%v", status, body, err)
+		return
+	}
+	//denied by authorizer
+	status, _, err = proxyGet("http://u2:p2@"+address, web.URL)
+	if err != nil || status != http.StatusForbidden {
+		t.Errorf("%v,%v", status, err)
+		return
+	}
+	//not authenticated
+	for _, proxy := range []string{"http://" + address, "http://u1:xx@" + address, "http://u3:p3@" + address} {
+		status, _, err = proxyGet(proxy, web.URL)
+		if err != nil || status != http.StatusProxyAuthRequired {
+			t.Errorf("%v,%v,%v", proxy, status, err)
+			return
+		}
+	}
+	//connect
+	conn, reader, status, err := proxyConnect(address, "echo:80", "Basic dTE6cDE=")
+	if err != nil || status != http.StatusOK {
+		t.Errorf("%v,%v", status, err)
+		return
+	}
+	fmt.Fprintf(conn, "def")
+	buf := make([]byte, 6)
+	_, err = io.ReadFull(reader, buf)
+	if err != nil || string(buf) != "abcdef" {
+		t.Errorf("%v,%v", string(buf), err)
+		return
+	}
+	//the connections of same user are all tracked
+	conn2, _, status, err := proxyConnect(address, "echo:80", "Basic dTE6cDE=")
+	if err != nil || status != http.StatusOK {
+		t.Errorf("%v,%v", status, err)
+		return
+	}
+	if sessions := pool.SessionsBySID(1); len(sessions) != 2 {
+		t.Error(sessions)
+		return
+	}
+	conn.Close()
+	conn2.Close()
+	//connect fail
+	for target, code := range map[string]int{
+		"echo":        http.StatusBadRequest,
+		"127.0.0.1:1": http.StatusBadGateway,
+	} {
+		_, _, status, err = proxyConnect(address, target, "Basic dTE6cDE=")
+		if err != nil || status != code {
+			t.Errorf("%v,%v,%v", target, status, err)
+			return
+		}
+	}
+	_, _, status, err = proxyConnect(address, "echo:80", "Basic dTI6cDI=")
+	if err != nil || status != http.StatusForbidden {
+		t.Errorf("%v,%v", status, err)
+		return
+	}
+	_, _, status, err = proxyConnect(address, "echo:80", "Bearer xx")
+	if err != nil || status != http.StatusProxyAuthRequired {
+		t.Errorf("%v,%v", status, err)
+		return
+	}
+	//shutdown
+	err = server.Shutdown(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-served; err != http.ErrServerClosed {
+		t.Error(err)
+		return
+	}
+}
+
+func TestHTTPProxyServerNoAuth(t *testing.T) {
+	pool := NewPool()
+	err := pool.Bootstrap(util.Map{"echo": 1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer pool.Shutdown(context.Background())
+	server := NewHTTPProxyServer(pool)
+	server.Timeout = 0
+	//all target is mapped to echo
+	server.Mapper = func(target string) string {
+		return "tcp://echo"
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	address := ts.Listener.Addr().String()
+	conn, reader, status, err := proxyConnect(address, "host:22", "")
+	if err != nil || status != http.StatusOK {
+		t.Errorf("%v,%v", status, err)
+		return
+	}
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(reader, buf)
+	conn.Close()
+	if err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", string(buf), err)
+		return
+	}
+	//not absolute uri
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Error(res.StatusCode)
+		return
+	}
+	//dial fail after pool shutdown
+	pool.Shutdown(context.Background())
+	status, _, err = proxyGet(ts.URL, "http://host/")
+	if err != nil || status != http.StatusServiceUnavailable {
+		t.Errorf("%v,%v", status, err)
+		return
+	}
+}
+
+func TestHTTPProxyConn(t *testing.T) {
+	server := NewHTTPProxyServer(NewPool())
+	if server.server.ReadHeaderTimeout <= 0 || server.server.IdleTimeout <= 0 {
+		t.Error("timeout is not set")
+		return
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.Close()
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer accepted.Close()
+	conn := &httpProxyConn{Conn: accepted, reader: bufio.NewReader(accepted)}
+	//the half close is forwarded
+	fmt.Fprintf(conn, "abc")
+	err = conn.CloseWrite()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := ioutil.ReadAll(client)
+	if err != nil || string(data) != "abc" {
+		t.Errorf("%v,%v", string(data), err)
+		return
+	}
+	//not supported
+	piped, _ := net.Pipe()
+	defer piped.Close()
+	conn = &httpProxyConn{Conn: piped, reader: bufio.NewReader(piped)}
+	if conn.CloseWrite() != ErrCloseWriteNotSupported {
+		t.Error("error")
+		return
+	}
+}
+
+func TestProxyAuthenticator(t *testing.T) {
+	name, user, err := ParseProxyUser("u1:p1:1")
+	if err != nil || name != "u1" || user.Password != "p1" || user.SID != 1 {
+		t.Errorf("%v,%v,%v", name, user, err)
+		return
+	}
+	for _, value := range []string{"u1", ":p1:1", "u1:p1:x", "u1:p:1:1"} {
+		if _, _, err = ParseProxyUser(value); err == nil {
+			t.Error(value)
+			return
+		}
+	}
+	auth := ProxyAuthenticatorF(func(username, password string) (uint64, error) {
+		return 100, nil
+	})
+	if sid, err := auth.Authenticate("u1", "p1"); err != nil || sid != 100 {
+		t.Errorf("%v,%v", sid, err)
+		return
+	}
+}
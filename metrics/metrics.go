@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sutils/dialer"
+)
+
+//Handler is an implementation of the http.Handler interface for exporting the Pool metrics in prometheus text format.
+type Handler struct {
+	Pool      *dialer.Pool
+	Namespace string
+}
+
+//NewHandler will return new Handler by pool
+func NewHandler(pool *dialer.Pool) *Handler {
+	return &Handler{
+		Pool:      pool,
+		Namespace: "dialer",
+	}
+}
+
+func (h *Handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	buf := bytes.NewBuffer(nil)
+	h.Write(buf)
+	resp.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	resp.Write(buf.Bytes())
+}
+
+//Write will write all metrics to writer
+func (h *Handler) Write(w io.Writer) {
+	out := &writer{Writer: w, namespace: h.Namespace}
+	var pooled, balanced []*dialerStats
+	for _, stats := range h.Pool.Stats() {
+		pooled = append(pooled, &dialerStats{DialerStats: stats})
+	}
+	var usages []*balancedUsage
+	for _, d := range h.Pool.ListDialers() {
+		if bdialer, ok := d.(*dialer.BalancedDialer); ok {
+			for _, stats := range bdialer.Stats() {
+				balanced = append(balanced, &dialerStats{balancer: bdialer.Name(), DialerStats: stats})
+			}
+			for _, usage := range bdialer.Usage() {
+				usages = append(usages, &balancedUsage{balancer: bdialer.Name(), BalancedUsage: usage})
+			}
+		}
+	}
+	h.writeDialers(out, "", pooled)
+	h.writeDialers(out, "balanced_", balanced)
+	h.writeUsages(out, "balanced_", usages, false)
+	h.writeUsages(out, "balanced_host_", usages, true)
+}
+
+type dialerStats struct {
+	*dialer.DialerStats
+	balancer string
+}
+
+func (d *dialerStats) labels(extra ...string) []string {
+	if len(d.balancer) > 0 {
+		return append([]string{"balancer", d.balancer, "dialer", d.Name}, extra...)
+	}
+	return append([]string{"dialer", d.Name}, extra...)
+}
+
+func (h *Handler) writeDialers(out *writer, prefix string, all []*dialerStats) {
+	out.header(prefix+"dial_total", "counter", "The number of dial attempts.")
+	for _, stats := range all {
+		out.sample(prefix+"dial_total", stats.labels(), float64(stats.Dials))
+	}
+	out.header(prefix+"dial_success_total", "counter", "The number of dial successes.")
+	for _, stats := range all {
+		out.sample(prefix+"dial_success_total", stats.labels(), float64(stats.Success))
+	}
+	out.header(prefix+"dial_fail_total", "counter", "The number of dial failures by error class.")
+	for _, stats := range all {
+		var classes []string
+		for class := range stats.Fails {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			out.sample(prefix+"dial_fail_total", stats.labels("class", class), float64(stats.Fails[class]))
+		}
+	}
+	out.header(prefix+"sessions_active", "gauge", "The number of active sessions.")
+	for _, stats := range all {
+		out.sample(prefix+"sessions_active", stats.labels(), float64(stats.Active))
+	}
+	out.header(prefix+"sessions_closed_total", "counter", "The number of closed sessions.")
+	for _, stats := range all {
+		out.sample(prefix+"sessions_closed_total", stats.labels(), float64(stats.Sessions))
+	}
+	out.header(prefix+"bytes_total", "counter", "The bytes transferred by direction.")
+	for _, stats := range all {
+		//the bytes of closed and active sessions
+		out.sample(prefix+"bytes_total", stats.labels("direction", "up"), float64(stats.Up+stats.LiveUp))
+		out.sample(prefix+"bytes_total", stats.labels("direction", "down"), float64(stats.Down+stats.LiveDown))
+	}
+	out.header(prefix+"dial_duration_seconds", "histogram", "The dial latency in seconds.")
+	for _, stats := range all {
+		var cumulative int64
+		for i, bucket := range dialer.LatencyBuckets {
+			cumulative += stats.Latency[i]
+			out.sample(prefix+"dial_duration_seconds_bucket", stats.labels("le", formatFloat(bucket.Seconds())), float64(cumulative))
+		}
+		out.sample(prefix+"dial_duration_seconds_bucket", stats.labels("le", "+Inf"), float64(stats.Dials))
+		out.sample(prefix+"dial_duration_seconds_sum", stats.labels(), stats.LatencySum.Seconds())
+		out.sample(prefix+"dial_duration_seconds_count", stats.labels(), float64(stats.Dials))
+	}
+}
+
+type balancedUsage struct {
+	*dialer.BalancedUsage
+	balancer string
+}
+
+func (b *balancedUsage) labels() []string {
+	if len(b.Host) > 0 {
+		return []string{"balancer", b.balancer, "dialer", b.Dialer, "host", b.Host}
+	}
+	return []string{"balancer", b.balancer, "dialer", b.Dialer}
+}
+
+func (h *Handler) writeUsages(out *writer, prefix string, usages []*balancedUsage, host bool) {
+	var matched []*balancedUsage
+	for _, usage := range usages {
+		if (len(usage.Host) > 0) == host {
+			matched = append(matched, usage)
+		}
+	}
+	out.header(prefix+"used", "gauge", "The used count in current limit window.")
+	for _, usage := range matched {
+		out.sample(prefix+"used", usage.labels(), float64(usage.Used))
+	}
+	out.header(prefix+"fail", "gauge", "The continuous fail count.")
+	for _, usage := range matched {
+		out.sample(prefix+"fail", usage.labels(), float64(usage.Fail))
+	}
+	out.header(prefix+"window_start_seconds", "gauge", "The start time of current limit window in unix seconds.")
+	for _, usage := range matched {
+		out.sample(prefix+"window_start_seconds", usage.labels(), float64(usage.Begin)/1000)
+	}
+}
+
+//writer is the prometheus text format writer which write HELP/TYPE once by metric name.
+type writer struct {
+	io.Writer
+	namespace string
+	written   map[string]bool
+}
+
+func (w *writer) name(name string) string {
+	if len(w.namespace) > 0 {
+		return w.namespace + "_" + name
+	}
+	return name
+}
+
+func (w *writer) header(name, mtype, help string) {
+	if w.written == nil {
+		w.written = map[string]bool{}
+	}
+	name = w.name(name)
+	if w.written[name] {
+		return
+	}
+	w.written[name] = true
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, mtype)
+}
+
+func (w *writer) sample(name string, labels []string, value float64) {
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%v=\"%v\"", labels[i], escapeLabel(labels[i+1])))
+	}
+	fmt.Fprintf(w, "%v{%v} %v\n", w.name(name), strings.Join(pairs, ","), formatFloat(value))
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func escapeLabel(val string) string {
+	return labelEscaper.Replace(val)
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sutils/dialer"
+)
+
+func TestHandler(t *testing.T) {
+	balanced := dialer.NewBalancedDialer()
+	balanced.ID = "b0"
+	balanced.AddDialer(dialer.NewEchoDialer())
+	pool := dialer.NewPool()
+	pool.AddDialer(dialer.NewEchoDialer(), dialer.NewTCPDialer(), balanced)
+	//
+	local, remote := net.Pipe()
+	_, err := pool.Dial(1, "tcp://echo", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(local, "abc")
+	buf := make([]byte, 1024)
+	io.ReadFull(local, buf[:3])
+	_, err = pool.Dial(2, "tcp://127.0.0.1:1", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = balanced.Dial(3, "tcp://echo?x=\"1\"", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	//
+	handler := NewHandler(pool)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	text := resp.Body.String()
+	for _, line := range []string{
+		`# TYPE dialer_dial_total counter`,
+		`dialer_dial_total{dialer="echo"} 1`,
+		`dialer_dial_success_total{dialer="echo"} 1`,
+		`dialer_dial_fail_total{dialer="tcp",class="refused"} 1`,
+		`dialer_sessions_active{dialer="echo"} 1`,
+		`dialer_bytes_total{dialer="echo",direction="up"} 3`,
+		`dialer_bytes_total{dialer="echo",direction="down"} 3`,
+		`# TYPE dialer_dial_duration_seconds histogram`,
+		`dialer_dial_duration_seconds_bucket{dialer="echo",le="+Inf"} 1`,
+		`dialer_dial_duration_seconds_count{dialer="tcp"} 1`,
+		`dialer_balanced_dial_total{balancer="b0",dialer="echo"} 1`,
+		`dialer_balanced_used{balancer="b0",dialer="echo"} 1`,
+		`dialer_balanced_host_used{balancer="b0",dialer="echo",host="echo"} 1`,
+		`dialer_balanced_fail{balancer="b0",dialer="echo"} 0`,
+		`# TYPE dialer_balanced_window_start_seconds gauge`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("%v not found", line)
+			return
+		}
+	}
+	if strings.Count(text, "# TYPE dialer_dial_total ") != 1 {
+		t.Error("error")
+		return
+	}
+	if escapeLabel("a\"b\\c\n") != `a\"b\\c\n` {
+		t.Error("error")
+		return
+	}
+	//the bytes of closed session is kept
+	local.Close()
+	time.Sleep(100 * time.Millisecond)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	text = resp.Body.String()
+	for _, line := range []string{
+		`dialer_sessions_active{dialer="echo"} 0`,
+		`dialer_bytes_total{dialer="echo",direction="up"} 3`,
+		`dialer_bytes_total{dialer="echo",direction="down"} 3`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("%v not found", line)
+			return
+		}
+	}
+}
@@ -0,0 +1,840 @@
+package dialer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//the frame type of mux protocol, the frame is:
+//	| type(1) | stream id(8) | payload length(4) | payload |
+//the integer is big endian, the stream id is allocated by the opening side, it is odd by client and even by server.
+const (
+	MuxFrameOpen   byte = 0x01 //open stream, the payload is | sid(8) | window(4) | uri |
+	MuxFrameAck    byte = 0x02 //the result of opening, the payload is | status(1) | window(4) | message |
+	MuxFrameData   byte = 0x03 //the stream data, the payload is not greater than the send window
+	MuxFrameWindow byte = 0x04 //increase the send window of stream, the payload is | increment(4) |
+	MuxFrameClose  byte = 0x05 //close the writing side of stream
+	MuxFrameReset  byte = 0x06 //abort the stream, the payload is the reason
+	MuxFramePing   byte = 0x07 //keepalive ping, the payload is echoed back by pong
+	MuxFramePong   byte = 0x08 //keepalive pong
+	MuxFrameGoAway byte = 0x09 //the sender is shutting down, no new stream is accepted
+)
+
+//MuxMaxFrame is the max payload length of data frame
+const MuxMaxFrame = 32 * 1024
+
+//MuxDefaultWindow is the default receiving window of each stream
+const MuxDefaultWindow = 256 * 1024
+
+//MuxDefaultMaxOpening is the default max number of streams which are opened by remote and handling by OnOpen
+const MuxDefaultMaxOpening = 1024
+
+const muxHeaderLen = 13
+
+//the max payload length of all frames, the open frame has the longest payload.
+const muxMaxPayload = 12 + 0xffff
+
+func writeMuxFrame(w io.Writer, typ byte, id uint64, payload []byte) (err error) {
+	buf := make([]byte, muxHeaderLen+len(payload))
+	buf[0] = typ
+	binary.BigEndian.PutUint64(buf[1:], id)
+	binary.BigEndian.PutUint32(buf[9:], uint32(len(payload)))
+	copy(buf[muxHeaderLen:], payload)
+	_, err = w.Write(buf)
+	return
+}
+
+func readMuxFrame(r io.Reader) (typ byte, id uint64, payload []byte, err error) {
+	header := make([]byte, muxHeaderLen)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return
+	}
+	typ = header[0]
+	id = binary.BigEndian.Uint64(header[1:])
+	length := binary.BigEndian.Uint32(header[9:])
+	if length > muxMaxPayload {
+		err = fmt.Errorf("the mux frame payload length(%v) is too large", length)
+		return
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	return
+}
+
+//MuxSession is the multiplexer to carry many streams over one io.ReadWriteCloser.
+//the stream is opened by Open and accepted by OnOpen on remote, each stream has flow control window.
+//the stream is keyed by stream id which is allocated by session, so many streams can be opened by the same sid.
+type MuxSession struct {
+	Server           bool             //whether the session is server side, the stream id opened by server is even and by client is odd
+	Window           uint32           //the receiving window of each stream, default is MuxDefaultWindow
+	MaxOpening       int32            //the max number of streams handling by OnOpen, the more opening is refused, default is MuxDefaultMaxOpening
+	Keepalive        time.Duration    //the interval of keepalive ping, it is disabled if not greater than zero
+	KeepaliveTimeout time.Duration    //the session is closed when nothing is received in timeout, default is 3 * Keepalive
+	OnOpen           func(*MuxStream) //the handler of stream opened by remote, it must accept or refuse the stream, the remote open is refused if nil
+	raw              io.ReadWriteCloser
+	streams          map[uint64]*MuxStream
+	nextID           uint64
+	opening          int32
+	lck              sync.Mutex
+	writeLck         sync.Mutex
+	control          chan []byte
+	lastRead         int64
+	goAway           uint32
+	remoteGoAway     uint32
+	closed           chan struct{}
+	closeOnce        sync.Once
+	err              error
+}
+
+//NewMuxSession will return new MuxSession on raw, it must be started by Start after configured.
+func NewMuxSession(raw io.ReadWriteCloser) *MuxSession {
+	return &MuxSession{
+		Window:  MuxDefaultWindow,
+		raw:     raw,
+		streams: map[uint64]*MuxStream{},
+		lck:     sync.Mutex{},
+		control: make(chan []byte, 256),
+		closed:  make(chan struct{}),
+	}
+}
+
+//Start will start the reading, writing and keepalive loop.
+func (m *MuxSession) Start() {
+	if m.Window < 1 {
+		m.Window = MuxDefaultWindow
+	}
+	if m.MaxOpening < 1 {
+		m.MaxOpening = MuxDefaultMaxOpening
+	}
+	atomic.StoreInt64(&m.lastRead, util.Now())
+	go m.readLoop()
+	go m.writeLoop()
+	if m.Keepalive > 0 {
+		go m.keepaliveLoop()
+	}
+}
+
+//Done will return the channel which is closed when session is closed.
+func (m *MuxSession) Done() <-chan struct{} {
+	return m.closed
+}
+
+//Err will return the error which closed the session, it is nil if not closed.
+func (m *MuxSession) Err() (err error) {
+	select {
+	case <-m.closed:
+		err = m.err
+	default:
+	}
+	return
+}
+
+//IsAvailable will return whether the session can open new stream.
+func (m *MuxSession) IsAvailable() bool {
+	return m.Err() == nil && atomic.LoadUint32(&m.goAway) == 0 && atomic.LoadUint32(&m.remoteGoAway) == 0
+}
+
+//NumStreams will return the number of living streams.
+func (m *MuxSession) NumStreams() (n int) {
+	m.lck.Lock()
+	n = len(m.streams)
+	m.lck.Unlock()
+	return
+}
+
+//Open will open the stream by sid and uri, it return *CodeError if the remote refused.
+//the sid is sent to remote as metadata, it is not required to be unique.
+func (m *MuxSession) Open(ctx context.Context, sid uint64, uri string) (stream *MuxStream, err error) {
+	if err = m.Err(); err != nil {
+		return
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if atomic.LoadUint32(&m.goAway) == 1 || atomic.LoadUint32(&m.remoteGoAway) == 1 {
+		err = ErrShutdown
+		return
+	}
+	if len(uri) < 1 || len(uri) > 0xffff {
+		err = fmt.Errorf("the uri length must be in 1-65535, but %v", len(uri))
+		return
+	}
+	stream = newMuxStream(m, 0, sid, uri)
+	stream.ack = make(chan error, 1)
+	m.openStream(stream)
+	payload := make([]byte, 12+len(uri))
+	binary.BigEndian.PutUint64(payload, sid)
+	binary.BigEndian.PutUint32(payload[8:], m.Window)
+	copy(payload[12:], uri)
+	err = m.writeFrame(MuxFrameOpen, stream.ID, payload)
+	if err == nil {
+		select {
+		case err = <-stream.ack:
+		case <-ctx.Done():
+			err = ctx.Err()
+			stream.Reset(err)
+		case <-m.closed:
+			err = m.err
+		}
+	}
+	if err != nil {
+		m.removeStream(stream)
+		stream = nil
+	}
+	return
+}
+
+//Shutdown will send go away to remote and wait all streams closed until ctx done, then close the session.
+//it return ctx.Err() if the streams are not closed on ctx done.
+func (m *MuxSession) Shutdown(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapUint32(&m.goAway, 0, 1) {
+		err = ErrShutdown
+		return
+	}
+	m.writeFrame(MuxFrameGoAway, 0, nil)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for m.NumStreams() > 0 && err == nil {
+		select {
+		case <-ticker.C:
+		case <-m.closed:
+			return
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	m.closeWith(ErrShutdown)
+	return
+}
+
+//Close will close the session and all streams immediately.
+func (m *MuxSession) Close() error {
+	m.closeWith(fmt.Errorf("MuxSession is closed"))
+	return nil
+}
+
+func (m *MuxSession) closeWith(err error) {
+	m.closeOnce.Do(func() {
+		m.err = err
+		close(m.closed)
+		m.raw.Close()
+		m.lck.Lock()
+		streams := m.streams
+		m.streams = map[uint64]*MuxStream{}
+		m.lck.Unlock()
+		for _, stream := range streams {
+			stream.fail(err)
+		}
+	})
+}
+
+//openStream will allocate the stream id of local opening stream and add it.
+func (m *MuxSession) openStream(stream *MuxStream) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	if m.nextID < 1 {
+		m.nextID = 1
+		if m.Server {
+			m.nextID = 2
+		}
+	}
+	stream.ID = m.nextID
+	m.nextID += 2
+	m.streams[stream.ID] = stream
+}
+
+//addStream will add the stream opened by remote, the stream id must be allocated by remote side and not opened.
+func (m *MuxSession) addStream(stream *MuxStream) (err error) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	if (stream.ID%2 == 0) == m.Server {
+		err = fmt.Errorf("the stream id(%v) is not allocated by remote", stream.ID)
+		return
+	}
+	if _, ok := m.streams[stream.ID]; ok {
+		err = fmt.Errorf("the stream id(%v) is opened", stream.ID)
+		return
+	}
+	m.streams[stream.ID] = stream
+	return
+}
+
+func (m *MuxSession) removeStream(stream *MuxStream) {
+	m.lck.Lock()
+	if m.streams[stream.ID] == stream {
+		delete(m.streams, stream.ID)
+	}
+	m.lck.Unlock()
+}
+
+func (m *MuxSession) findStream(id uint64) (stream *MuxStream) {
+	m.lck.Lock()
+	stream = m.streams[id]
+	m.lck.Unlock()
+	return
+}
+
+//writeFrame will write the frame to raw, the session is closed on error.
+func (m *MuxSession) writeFrame(typ byte, id uint64, payload []byte) (err error) {
+	if err = m.Err(); err != nil {
+		return
+	}
+	m.writeLck.Lock()
+	err = writeMuxFrame(m.raw, typ, id, payload)
+	m.writeLck.Unlock()
+	if err != nil {
+		m.closeWith(err)
+	}
+	return
+}
+
+//sendControl will send the frame by writing loop, it is used on reading loop to avoid blocking by writing.
+//it never blocks, the session is closed when the control queue is full, because the remote is not reading.
+func (m *MuxSession) sendControl(typ byte, id uint64, payload []byte) {
+	buf := bytes.NewBuffer(nil)
+	writeMuxFrame(buf, typ, id, payload)
+	select {
+	case m.control <- buf.Bytes():
+	case <-m.closed:
+	default:
+		log.D("MuxSession the control queue is full, close session")
+		m.closeWith(fmt.Errorf("MuxSession control queue is full"))
+	}
+}
+
+func (m *MuxSession) writeLoop() {
+	for {
+		select {
+		case frame := <-m.control:
+			m.writeLck.Lock()
+			_, err := m.raw.Write(frame)
+			m.writeLck.Unlock()
+			if err != nil {
+				m.closeWith(err)
+				return
+			}
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+func (m *MuxSession) keepaliveLoop() {
+	timeout := m.KeepaliveTimeout
+	if timeout <= 0 {
+		timeout = 3 * m.Keepalive
+	}
+	ticker := time.NewTicker(m.Keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.closed:
+			return
+		}
+		if time.Duration(util.Now()-atomic.LoadInt64(&m.lastRead))*time.Millisecond > timeout {
+			log.D("MuxSession keepalive timeout(%v)", timeout)
+			m.closeWith(fmt.Errorf("MuxSession keepalive timeout"))
+			return
+		}
+		payload := make([]byte, 8)
+		binary.BigEndian.PutUint64(payload, uint64(util.Now()))
+		m.sendControl(MuxFramePing, 0, payload)
+	}
+}
+
+func (m *MuxSession) readLoop() {
+	for {
+		typ, id, payload, err := readMuxFrame(m.raw)
+		if err != nil {
+			m.closeWith(err)
+			return
+		}
+		atomic.StoreInt64(&m.lastRead, util.Now())
+		err = m.handleFrame(typ, id, payload)
+		if err != nil {
+			log.D("MuxSession handle frame(%v) fail with %v", typ, err)
+			m.closeWith(err)
+			return
+		}
+	}
+}
+
+func (m *MuxSession) handleFrame(typ byte, id uint64, payload []byte) (err error) {
+	switch typ {
+	case MuxFrameOpen:
+		err = m.handleOpen(id, payload)
+	case MuxFrameAck:
+		if len(payload) < 5 {
+			err = fmt.Errorf("the mux ack frame is invalid")
+			break
+		}
+		stream := m.findStream(id)
+		if stream == nil || stream.ack == nil {
+			m.sendControl(MuxFrameReset, id, []byte("stream not found"))
+			break
+		}
+		var ackErr error
+		if payload[0] == CodeOK {
+			stream.addSendWindow(binary.BigEndian.Uint32(payload[1:]))
+		} else {
+			ackErr = &CodeError{Inner: fmt.Errorf("%s", payload[5:]), ByteCode: payload[0]}
+			m.removeStream(stream)
+		}
+		select {
+		case stream.ack <- ackErr:
+		default:
+		}
+	case MuxFrameData:
+		stream := m.findStream(id)
+		if stream == nil {
+			m.sendControl(MuxFrameReset, id, []byte("stream not found"))
+			break
+		}
+		if !stream.push(payload) {
+			//reset by writing loop, the reading loop must not be blocked by writing
+			m.removeStream(stream)
+			reason := fmt.Errorf("the receiving window is exceeded")
+			if stream.fail(reason) {
+				m.sendControl(MuxFrameReset, id, []byte(reason.Error()))
+			}
+		}
+	case MuxFrameWindow:
+		if len(payload) < 4 {
+			err = fmt.Errorf("the mux window frame is invalid")
+			break
+		}
+		stream := m.findStream(id)
+		if stream == nil {
+			m.sendControl(MuxFrameReset, id, []byte("stream not found"))
+			break
+		}
+		stream.addSendWindow(binary.BigEndian.Uint32(payload))
+	case MuxFrameClose:
+		if stream := m.findStream(id); stream != nil {
+			stream.remoteClose()
+		}
+	case MuxFrameReset:
+		if stream := m.findStream(id); stream != nil {
+			m.removeStream(stream)
+			stream.fail(fmt.Errorf("MuxStream is reset by remote: %s", payload))
+			if stream.ack != nil {
+				select {
+				case stream.ack <- stream.err:
+				default:
+				}
+			}
+		}
+	case MuxFramePing:
+		m.sendControl(MuxFramePong, id, payload)
+	case MuxFramePong:
+	case MuxFrameGoAway:
+		atomic.StoreUint32(&m.remoteGoAway, 1)
+	default:
+		err = fmt.Errorf("the mux frame type(%v) is not supported", typ)
+	}
+	return
+}
+
+func (m *MuxSession) handleOpen(id uint64, payload []byte) (err error) {
+	if len(payload) < 13 {
+		err = fmt.Errorf("the mux open frame is invalid")
+		return
+	}
+	var refused error
+	if m.OnOpen == nil {
+		refused = &CodeError{Inner: fmt.Errorf("the opening stream is not supported"), ByteCode: CodeUnsupported}
+	} else if atomic.LoadUint32(&m.goAway) == 1 {
+		refused = ErrShutdown
+	} else if atomic.AddInt32(&m.opening, 1) > m.MaxOpening {
+		atomic.AddInt32(&m.opening, -1)
+		refused = &CodeError{Inner: fmt.Errorf("too many opening streams"), ByteCode: CodeRefused}
+	}
+	stream := newMuxStream(m, id, binary.BigEndian.Uint64(payload), string(payload[12:]))
+	stream.sendWindow = binary.BigEndian.Uint32(payload[8:])
+	if refused == nil {
+		if aerr := m.addStream(stream); aerr != nil {
+			atomic.AddInt32(&m.opening, -1)
+			refused = &CodeError{Inner: aerr, ByteCode: CodeBadRequest}
+		}
+	}
+	if refused != nil {
+		m.sendControl(MuxFrameAck, id, muxAckPayload(refused, 0))
+		return
+	}
+	go func() {
+		defer atomic.AddInt32(&m.opening, -1)
+		m.OnOpen(stream)
+	}()
+	return
+}
+
+func muxAckPayload(ackErr error, window uint32) (payload []byte) {
+	var msg string
+	if ackErr != nil {
+		msg = ackErr.Error()
+		if len(msg) > muxMaxPayload-5 {
+			msg = msg[:muxMaxPayload-5]
+		}
+	}
+	payload = make([]byte, 5+len(msg))
+	payload[0] = ErrorCode(ackErr)
+	binary.BigEndian.PutUint32(payload[1:], window)
+	copy(payload[5:], msg)
+	return
+}
+
+func (m *MuxSession) String() string {
+	return fmt.Sprintf("MuxSession(%v)", m.raw)
+}
+
+//MuxStream is one logical stream of MuxSession, it is an implementation of io.ReadWriteCloser.
+type MuxStream struct {
+	ID          uint64 //the stream id which is allocated by opening side
+	SID         uint64 //the sid of opening
+	URI         string
+	session     *MuxSession
+	ack         chan error //the channel to receive the open result
+	lck         sync.Mutex
+	cond        *sync.Cond
+	buf         bytes.Buffer
+	sendWindow  uint32
+	received    uint32 //the received bytes which is not acknowledged by window frame
+	consumed    uint32 //the consumed bytes which is not acknowledged by window frame
+	readClosed  bool
+	writeClosed bool
+	err         error
+}
+
+func newMuxStream(session *MuxSession, id, sid uint64, uri string) (stream *MuxStream) {
+	stream = &MuxStream{
+		ID:      id,
+		SID:     sid,
+		URI:     uri,
+		session: session,
+		lck:     sync.Mutex{},
+	}
+	stream.cond = sync.NewCond(&stream.lck)
+	return
+}
+
+//Accept will accept the stream opened by remote, it must be called before reading or writing.
+func (m *MuxStream) Accept() (err error) {
+	err = m.session.writeFrame(MuxFrameAck, m.ID, muxAckPayload(nil, m.session.Window))
+	if err != nil {
+		m.session.removeStream(m)
+	}
+	return
+}
+
+//Refuse will refuse the stream opened by remote by dial error, the status code is ErrorCode(reason).
+func (m *MuxStream) Refuse(reason error) (err error) {
+	m.session.removeStream(m)
+	m.fail(reason)
+	err = m.session.writeFrame(MuxFrameAck, m.ID, muxAckPayload(reason, 0))
+	return
+}
+
+//Read will read the received data, it return io.EOF after remote closed writing.
+func (m *MuxStream) Read(p []byte) (n int, err error) {
+	m.lck.Lock()
+	for m.buf.Len() < 1 && !m.readClosed && m.err == nil {
+		m.cond.Wait()
+	}
+	if m.buf.Len() < 1 {
+		err = m.err
+		if err == nil {
+			err = io.EOF
+		}
+		m.lck.Unlock()
+		return
+	}
+	n, _ = m.buf.Read(p)
+	m.consumed += uint32(n)
+	var increment uint32
+	if m.consumed >= m.session.Window/2 && !m.readClosed && m.err == nil {
+		increment = m.consumed
+		m.received -= increment
+		m.consumed = 0
+	}
+	m.lck.Unlock()
+	if increment > 0 {
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, increment)
+		m.session.writeFrame(MuxFrameWindow, m.ID, payload)
+	}
+	return
+}
+
+//Write will write data to remote, it is blocked when the send window is used up.
+func (m *MuxStream) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		m.lck.Lock()
+		for m.sendWindow < 1 && !m.writeClosed && m.err == nil {
+			m.cond.Wait()
+		}
+		if m.err != nil {
+			err = m.err
+		} else if m.writeClosed {
+			err = fmt.Errorf("MuxStream write is closed")
+		}
+		if err != nil {
+			m.lck.Unlock()
+			return
+		}
+		size := uint32(len(p) - n)
+		if size > m.sendWindow {
+			size = m.sendWindow
+		}
+		if size > MuxMaxFrame {
+			size = MuxMaxFrame
+		}
+		m.sendWindow -= size
+		m.lck.Unlock()
+		err = m.session.writeFrame(MuxFrameData, m.ID, p[n:n+int(size)])
+		if err != nil {
+			return
+		}
+		n += int(size)
+	}
+	return
+}
+
+//CloseWrite will close the writing side, the remote will read io.EOF.
+func (m *MuxStream) CloseWrite() (err error) {
+	m.lck.Lock()
+	if m.writeClosed || m.err != nil {
+		m.lck.Unlock()
+		return
+	}
+	m.writeClosed = true
+	finished := m.readClosed
+	m.cond.Broadcast()
+	m.lck.Unlock()
+	err = m.session.writeFrame(MuxFrameClose, m.ID, nil)
+	if finished {
+		m.session.removeStream(m)
+	}
+	return
+}
+
+//Close will close the writing side and discard the data received after.
+func (m *MuxStream) Close() (err error) {
+	m.lck.Lock()
+	if m.err != nil {
+		m.lck.Unlock()
+		return
+	}
+	sendClose := !m.writeClosed
+	m.writeClosed = true
+	m.err = fmt.Errorf("MuxStream is closed")
+	m.cond.Broadcast()
+	m.lck.Unlock()
+	m.session.removeStream(m)
+	if sendClose {
+		err = m.session.writeFrame(MuxFrameClose, m.ID, nil)
+	}
+	return
+}
+
+//Reset will abort the stream by reason, both remote and local reading/writing will fail.
+func (m *MuxStream) Reset(reason error) (err error) {
+	m.session.removeStream(m)
+	if m.fail(reason) {
+		err = m.session.writeFrame(MuxFrameReset, m.ID, []byte(reason.Error()))
+	}
+	return
+}
+
+//fail will mark the stream failed by err, it return false if the stream is failed.
+func (m *MuxStream) fail(err error) bool {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	if m.err != nil {
+		return false
+	}
+	m.err = err
+	m.cond.Broadcast()
+	return true
+}
+
+func (m *MuxStream) push(data []byte) bool {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	if m.err != nil || m.readClosed {
+		//discard the data after closed
+		return true
+	}
+	if m.received+uint32(len(data)) > m.session.Window {
+		return false
+	}
+	m.received += uint32(len(data))
+	m.buf.Write(data)
+	m.cond.Broadcast()
+	return true
+}
+
+func (m *MuxStream) addSendWindow(increment uint32) {
+	m.lck.Lock()
+	m.sendWindow += increment
+	m.cond.Broadcast()
+	m.lck.Unlock()
+}
+
+func (m *MuxStream) remoteClose() {
+	m.lck.Lock()
+	m.readClosed = true
+	finished := m.writeClosed
+	m.cond.Broadcast()
+	m.lck.Unlock()
+	if finished {
+		m.session.removeStream(m)
+	}
+}
+
+func (m *MuxStream) String() string {
+	return fmt.Sprintf("MuxStream(%v,%v,%v)", m.ID, m.SID, m.URI)
+}
+
+//MuxServer is the server to serve Pool by mux protocol, each stream opened by client is dialed by Pool and piped.
+type MuxServer struct {
+	Pool             *Pool
+	Window           uint32        //the receiving window of each stream, default is MuxDefaultWindow
+	Keepalive        time.Duration //the interval of keepalive ping, default is 30s
+	KeepaliveTimeout time.Duration //the timeout of receiving nothing, default is 3 * Keepalive
+	Timeout          time.Duration //the timeout of dialing, default is 10s
+	lck              sync.Mutex
+	ls               map[net.Listener]bool
+	sessions         map[*MuxSession]bool
+	shutdown         uint32
+}
+
+//NewMuxServer will return new MuxServer by pool
+func NewMuxServer(pool *Pool) *MuxServer {
+	return &MuxServer{
+		Pool:      pool,
+		Window:    MuxDefaultWindow,
+		Keepalive: 30 * time.Second,
+		Timeout:   10 * time.Second,
+		lck:       sync.Mutex{},
+		ls:        map[net.Listener]bool{},
+		sessions:  map[*MuxSession]bool{},
+	}
+}
+
+//Serve will accept the connection on listener and serve it, it return when listener is closed.
+func (m *MuxServer) Serve(l net.Listener) (err error) {
+	m.lck.Lock()
+	m.ls[l] = true
+	m.lck.Unlock()
+	defer func() {
+		m.lck.Lock()
+		delete(m.ls, l)
+		m.lck.Unlock()
+	}()
+	log.D("MuxServer start serve on %v", l.Addr())
+	var conn net.Conn
+	for {
+		conn, err = l.Accept()
+		if err != nil {
+			break
+		}
+		go m.ServeConn(conn)
+	}
+	log.D("MuxServer serve on %v is stopped by %v", l.Addr(), err)
+	return
+}
+
+//ServeConn will serve the mux session on raw until the session is closed, it return the error which closed session.
+func (m *MuxServer) ServeConn(raw io.ReadWriteCloser) (err error) {
+	session := NewMuxSession(raw)
+	session.Server = true
+	session.Window = m.Window
+	session.Keepalive = m.Keepalive
+	session.KeepaliveTimeout = m.KeepaliveTimeout
+	session.OnOpen = m.serveStream
+	m.lck.Lock()
+	if atomic.LoadUint32(&m.shutdown) == 1 {
+		m.lck.Unlock()
+		raw.Close()
+		err = ErrShutdown
+		return
+	}
+	m.sessions[session] = true
+	m.lck.Unlock()
+	session.Start()
+	<-session.Done()
+	m.lck.Lock()
+	delete(m.sessions, session)
+	m.lck.Unlock()
+	err = session.Err()
+	log.D("MuxServer session on %v is closed by %v", raw, err)
+	return
+}
+
+func (m *MuxServer) serveStream(stream *MuxStream) {
+	serveMuxStream(m.Pool, m.Timeout, stream)
+}
+
+//serveMuxStream will dial the stream uri by pool and pipe to stream, the stream is refused by dial error.
+func serveMuxStream(pool *Pool, timeout time.Duration, stream *MuxStream) {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	raw, err := pool.DialContext(ctx, stream.SID, stream.URI, nil)
+	cancel()
+	if err != nil {
+		log.D("Mux dial %v by sid(%v) fail with %v", stream.URI, stream.SID, err)
+		stream.Refuse(err)
+		return
+	}
+	err = stream.Accept()
+	if err == nil {
+		err = raw.Pipe(stream)
+	}
+	if err != nil {
+		raw.Close()
+		stream.Close()
+	}
+}
+
+//Shutdown will close all listeners and shutdown all sessions gracefully until ctx done, the Pool is not shutdown.
+func (m *MuxServer) Shutdown(ctx context.Context) (err error) {
+	m.lck.Lock()
+	atomic.StoreUint32(&m.shutdown, 1)
+	for l := range m.ls {
+		l.Close()
+	}
+	sessions := []*MuxSession{}
+	for session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	m.lck.Unlock()
+	wg := sync.WaitGroup{}
+	errs := make(chan error, len(sessions))
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *MuxSession) {
+			defer wg.Done()
+			if serr := session.Shutdown(ctx); serr != nil && serr != ErrShutdown {
+				errs <- serr
+			}
+		}(session)
+	}
+	wg.Wait()
+	close(errs)
+	err = <-errs
+	return
+}
@@ -0,0 +1,561 @@
+package dialer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func newMuxPair(onOpen func(*MuxStream), window uint32) (client, server *MuxSession) {
+	a, b := net.Pipe()
+	client, server = NewMuxSession(a), NewMuxSession(b)
+	server.Server = true
+	client.Window, server.Window = window, window
+	server.OnOpen = onOpen
+	client.Start()
+	server.Start()
+	return
+}
+
+func echoMuxStream(stream *MuxStream) {
+	if stream.URI == "refuse" {
+		stream.Refuse(&DialError{URI: stream.URI})
+		return
+	}
+	stream.Accept()
+	io.Copy(stream, stream)
+	stream.CloseWrite()
+}
+
+func TestMuxFrame(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := writeMuxFrame(buf, MuxFrameData, 100, []byte("abc"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	typ, sid, payload, err := readMuxFrame(buf)
+	if err != nil || typ != MuxFrameData || sid != 100 || string(payload) != "abc" {
+		t.Errorf("%v,%v,%v,%v", typ, sid, string(payload), err)
+		return
+	}
+	for _, data := range [][]byte{{}, {1, 0, 0, 0, 0, 0, 0, 0, 1, 0xff, 0, 0, 0}, {1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 'x'}} {
+		_, _, _, err = readMuxFrame(bytes.NewBuffer(data))
+		if err == nil {
+			t.Error(data)
+			return
+		}
+	}
+}
+
+func TestMuxSession(t *testing.T) {
+	client, server := newMuxPair(echoMuxStream, 1024)
+	defer client.Close()
+	//echo with flow control
+	stream, err := client.Open(context.Background(), 1, "tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data := bytes.Repeat([]byte("0123456789"), 100*1024)
+	go func() {
+		stream.Write(data)
+		stream.CloseWrite()
+	}()
+	received, err := ioutil.ReadAll(stream)
+	if err != nil || !bytes.Equal(data, received) {
+		t.Errorf("%v,%v", len(received), err)
+		return
+	}
+	stream.Close()
+	for i := 0; i < 100 && (client.NumStreams() > 0 || server.NumStreams() > 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if client.NumStreams() != 0 || server.NumStreams() != 0 {
+		t.Errorf("%v,%v", client.NumStreams(), server.NumStreams())
+		return
+	}
+	//many streams
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(sid uint64) {
+			defer wg.Done()
+			stream, err := client.Open(context.Background(), sid, "tcp://echo")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer stream.Close()
+			msg := fmt.Sprintf("stream-%v", sid)
+			fmt.Fprintf(stream, "%v", msg)
+			buf := make([]byte, len(msg))
+			_, err = io.ReadFull(stream, buf)
+			if err == nil && string(buf) != msg {
+				err = fmt.Errorf("echo fail with %v", string(buf))
+			}
+			if err != nil {
+				errs <- err
+			}
+		}(uint64(100 + i))
+	}
+	wg.Wait()
+	close(errs)
+	if err = <-errs; err != nil {
+		t.Error(err)
+		return
+	}
+	//refuse
+	_, err = client.Open(context.Background(), 2, "refuse")
+	if cerr, ok := err.(*CodeError); !ok || cerr.Code() != CodeUnsupported {
+		t.Error(err)
+		return
+	}
+	_, err = server.Open(context.Background(), 2, "tcp://echo")
+	if cerr, ok := err.(*CodeError); !ok || cerr.Code() != CodeUnsupported {
+		t.Error(err)
+		return
+	}
+	//many streams by same sid
+	opened := make(chan *MuxStream, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			stream, err := client.Open(context.Background(), 3, "tcp://echo")
+			if err != nil {
+				t.Error(err)
+			}
+			opened <- stream
+		}()
+	}
+	stream, other := <-opened, <-opened
+	if stream == nil || other == nil || stream.SID != 3 || other.SID != 3 || stream.ID == other.ID {
+		t.Errorf("%v,%v", stream, other)
+		return
+	}
+	for i, s := range []*MuxStream{stream, other} {
+		msg := fmt.Sprintf("same-%v", i)
+		fmt.Fprintf(s, "%v", msg)
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(s, buf)
+		if err != nil || string(buf) != msg {
+			t.Errorf("%v,%v", string(buf), err)
+			return
+		}
+	}
+	other.Close()
+	//reset
+	stream.Reset(fmt.Errorf("testing"))
+	_, err = stream.Write([]byte("abc"))
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = stream.Read(make([]byte, 1))
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//invalid uri
+	_, err = client.Open(context.Background(), 4, "")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//context canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.Open(ctx, 5, "tcp://echo")
+	if err != context.Canceled {
+		t.Error(err)
+		return
+	}
+}
+
+func TestMuxSessionShutdown(t *testing.T) {
+	client, server := newMuxPair(echoMuxStream, MuxDefaultWindow)
+	stream, err := client.Open(context.Background(), 1, "tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+	for i := 0; i < 100 && client.IsAvailable(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	//new stream is refused after go away
+	_, err = client.Open(context.Background(), 2, "tcp://echo")
+	if err != ErrShutdown {
+		t.Error(err)
+		return
+	}
+	//the living stream is working
+	fmt.Fprintf(stream, "abc")
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(stream, buf)
+	if err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", string(buf), err)
+		return
+	}
+	stream.Close()
+	if err = <-shutdown; err != nil {
+		t.Error(err)
+		return
+	}
+	<-client.Done()
+	if server.Shutdown(context.Background()) != ErrShutdown {
+		t.Error("error")
+		return
+	}
+	_, err = client.Open(context.Background(), 3, "tcp://echo")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//shutdown timeout
+	client, server = newMuxPair(echoMuxStream, MuxDefaultWindow)
+	defer client.Close()
+	_, err = client.Open(context.Background(), 1, "tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+		return
+	}
+}
+
+func TestMuxSessionKeepalive(t *testing.T) {
+	//keepalive is working
+	a, b := net.Pipe()
+	client, server := NewMuxSession(a), NewMuxSession(b)
+	client.Keepalive, server.Keepalive = 10*time.Millisecond, 10*time.Millisecond
+	client.KeepaliveTimeout = 50 * time.Millisecond
+	client.Start()
+	server.Start()
+	time.Sleep(150 * time.Millisecond)
+	if err := client.Err(); err != nil {
+		t.Error(err)
+		return
+	}
+	server.Close()
+	//keepalive timeout
+	a, b = net.Pipe()
+	go io.Copy(ioutil.Discard, b)
+	client = NewMuxSession(a)
+	client.Keepalive = 10 * time.Millisecond
+	client.Start()
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Error("not timeout")
+		return
+	}
+	b.Close()
+	//invalid frame
+	for _, frame := range []struct {
+		Type    byte
+		Payload []byte
+	}{
+		{MuxFrameOpen, nil},
+		{MuxFrameOpen, []byte{0, 0, 0, 0}},
+		{MuxFrameAck, nil},
+		{MuxFrameWindow, nil},
+		{0xff, nil},
+	} {
+		a, b = net.Pipe()
+		client = NewMuxSession(a)
+		client.Start()
+		go io.Copy(ioutil.Discard, b)
+		writeMuxFrame(b, frame.Type, 1, frame.Payload)
+		select {
+		case <-client.Done():
+		case <-time.After(time.Second):
+			t.Error("not closed")
+			return
+		}
+		b.Close()
+	}
+}
+
+func TestMuxServer(t *testing.T) {
+	//the echo server on localhost
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	pool := NewPool()
+	err = pool.Bootstrap(util.Map{
+		"dialers": []util.Map{
+			{"type": "echo"},
+			{"type": "cmd"},
+			{"type": "tcp"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer pool.Shutdown(context.Background())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	server := NewMuxServer(pool)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+	//the client pool
+	client := NewPool()
+	err = client.Bootstrap(util.Map{
+		"dialers": []util.Map{
+			{"type": "mux", "id": "mux", "address": l.Addr().String()},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//hundreds of sessions over one connection
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 300)
+	for i := 0; i < 300; i++ {
+		wg.Add(1)
+		go func(sid uint64) {
+			defer wg.Done()
+			uri := "tcp://echo"
+			switch sid % 3 {
+			case 1:
+				uri = "tcp://" + echo.Addr().String()
+			case 2:
+				uri = "tcp://cmd?exec=cat"
+			}
+			conn, err := client.Dial(sid, uri, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			msg := fmt.Sprintf("session-%v\n", sid)
+			fmt.Fprintf(conn, "%v", msg)
+			buf := make([]byte, len(msg))
+			_, err = io.ReadFull(conn, buf)
+			//the terminal of cmd is translating \n to \r\n
+			if err == nil && strings.TrimSpace(string(buf)) != strings.TrimSpace(msg) {
+				err = fmt.Errorf("echo fail with %v", string(buf))
+			}
+			if err != nil {
+				errs <- fmt.Errorf("%v:%v", uri, err)
+			}
+		}(uint64(i + 1))
+	}
+	wg.Wait()
+	close(errs)
+	if err = <-errs; err != nil {
+		t.Error(err)
+		return
+	}
+	mux := client.FindDialer("mux").(*MuxDialer)
+	session := mux.session
+	//piped
+	pipe := NewEchoDialer()
+	piped, err := pipe.Dial(0, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = client.Dial(1000, "tcp://echo", piped)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//dial fail
+	_, err = client.Dial(1001, "xx://none", nil)
+	if _, ok := err.(*CodeError); !ok {
+		t.Error(err)
+		return
+	}
+	//reconnect after session closed
+	session.Close()
+	conn, err := client.Dial(1002, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	if mux.session == session {
+		t.Error("not reconnected")
+		return
+	}
+	//shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = client.Shutdown(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = server.Shutdown(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-served; err == nil {
+		t.Error(err)
+		return
+	}
+	a, _ := net.Pipe()
+	if server.ServeConn(a) != ErrShutdown {
+		t.Error("error")
+		return
+	}
+}
+
+func TestMuxWindowExceeded(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	client := NewMuxSession(a)
+	client.Window = 16
+	client.Start()
+	defer client.Close()
+	opened := make(chan *MuxStream, 1)
+	go func() {
+		stream, _ := client.Open(context.Background(), 1, "tcp://echo")
+		opened <- stream
+	}()
+	typ, sid, _, err := readMuxFrame(b)
+	if err != nil || typ != MuxFrameOpen || sid != 1 {
+		t.Errorf("%v,%v,%v", typ, sid, err)
+		return
+	}
+	err = writeMuxFrame(b, MuxFrameAck, 1, muxAckPayload(nil, MuxDefaultWindow))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	stream := <-opened
+	if stream == nil {
+		t.Error("not opened")
+		return
+	}
+	//the reading loop is not blocked by writing reset when remote is not reading
+	err = writeMuxFrame(b, MuxFrameData, 1, make([]byte, 32))
+	if err == nil {
+		err = writeMuxFrame(b, MuxFramePing, 0, make([]byte, 8))
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = stream.Read(make([]byte, 1))
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	typ, sid, _, err = readMuxFrame(b)
+	if err != nil || typ != MuxFrameReset || sid != 1 {
+		t.Errorf("%v,%v,%v", typ, sid, err)
+		return
+	}
+}
+
+func TestMuxStreamID(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	client := NewMuxSession(a)
+	client.OnOpen = echoMuxStream
+	client.Start()
+	defer client.Close()
+	//the odd stream id is allocated by client, so it is refused when opened by remote
+	payload := make([]byte, 12+len("tcp://echo"))
+	copy(payload[12:], "tcp://echo")
+	err := writeMuxFrame(b, MuxFrameOpen, 1, payload)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	typ, id, payload, err := readMuxFrame(b)
+	if err != nil || typ != MuxFrameAck || id != 1 || payload[0] != CodeBadRequest {
+		t.Errorf("%v,%v,%v,%v", typ, id, payload, err)
+		return
+	}
+}
+
+func TestMuxSessionFlood(t *testing.T) {
+	//the opening stream is refused when too many streams are handling
+	release := make(chan int)
+	client, server := newMuxPair(func(stream *MuxStream) {
+		<-release
+		echoMuxStream(stream)
+	}, MuxDefaultWindow)
+	defer client.Close()
+	server.MaxOpening = 1
+	opened := make(chan error, 1)
+	go func() {
+		_, err := client.Open(context.Background(), 1, "tcp://echo")
+		opened <- err
+	}()
+	for i := 0; i < 100 && server.NumStreams() < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err := client.Open(context.Background(), 1, "tcp://echo")
+	if cerr, ok := err.(*CodeError); !ok || cerr.Code() != CodeRefused {
+		t.Error(err)
+		return
+	}
+	close(release)
+	if err = <-opened; err != nil {
+		t.Error(err)
+		return
+	}
+	//the reading loop is not blocked when remote is not reading
+	a, b := net.Pipe()
+	defer b.Close()
+	flooded := NewMuxSession(a)
+	flooded.Start()
+	go func() {
+		for i := 0; i < 1000; i++ {
+			if writeMuxFrame(b, MuxFramePing, 0, make([]byte, 8)) != nil {
+				break
+			}
+		}
+	}()
+	select {
+	case <-flooded.Done():
+	case <-time.After(3 * time.Second):
+		t.Error("not closed")
+		return
+	}
+}
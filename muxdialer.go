@@ -0,0 +1,199 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//MuxDialer is an implementation of the Dialer interface for dial by MuxServer,
+//all dialed connections are carried as streams over one shared connection to server.
+type MuxDialer struct {
+	ID               string
+	Address          string        //the server address like host:port or unix:/tmp/mux.sock
+	Window           uint32        //the receiving window of each stream
+	Keepalive        time.Duration //the interval of keepalive ping, 0 is disabled
+	KeepaliveTimeout time.Duration //the timeout of receiving nothing
+	//the func to connect to server, default is dialing tcp or unix socket by Address
+	Connect    func(ctx context.Context) (io.ReadWriteCloser, error)
+	matcher    *regexp.Regexp
+	conf       util.Map
+	session    *MuxSession
+	sessionLck sync.Mutex
+	shutdown   uint32
+}
+
+//MuxDialerSchema is the config schema of MuxDialer
+var MuxDialerSchema = NewSchema(
+	&SchemaField{Name: "id", Type: FieldString, Required: true, Description: "the dialer name"},
+	&SchemaField{Name: "address", Type: FieldString, Required: true, Description: "the mux server address like host:port or unix:/tmp/mux.sock"},
+	&SchemaField{Name: "matcher", Type: FieldString, Default: "^.*$", Description: "the regexp to match uri host"},
+	&SchemaField{Name: "window", Type: FieldInt, Default: MuxDefaultWindow, Description: "the receiving window of each stream in bytes"},
+	&SchemaField{Name: "keepalive", Type: FieldInt, Default: 30000, Description: "the interval of keepalive ping in millisecond, 0 is disabled"},
+	&SchemaField{Name: "keepalive_timeout", Type: FieldInt, Default: 90000, Description: "the timeout of receiving nothing from server in millisecond"},
+).Merge(PipeSchema)
+
+//NewMuxDialer will return new MuxDialer
+func NewMuxDialer() *MuxDialer {
+	return &MuxDialer{
+		Window:     MuxDefaultWindow,
+		Keepalive:  30 * time.Second,
+		matcher:    regexp.MustCompile("^.*$"),
+		conf:       util.Map{},
+		sessionLck: sync.Mutex{},
+	}
+}
+
+//Name will return dialer name
+func (m *MuxDialer) Name() string {
+	return m.ID
+}
+
+//Bootstrap the dialer.
+func (m *MuxDialer) Bootstrap(options util.Map) (err error) {
+	m.ID = options.StrVal("id")
+	if len(m.ID) < 1 {
+		return fmt.Errorf("the dialer id is required")
+	}
+	err = MuxDialerSchema.Validate("", options, false)
+	if err != nil {
+		return
+	}
+	m.Address = options.StrVal("address")
+	if len(m.Address) < 1 {
+		return fmt.Errorf("the mux server address is required")
+	}
+	matcher := options.StrVal("matcher")
+	if len(matcher) > 0 {
+		m.matcher, err = regexp.Compile(matcher)
+		if err != nil {
+			err = fmt.Errorf("compile matcher(%v) fail with %v", matcher, err)
+			return
+		}
+	}
+	m.Window = uint32(options.IntValV("window", MuxDefaultWindow))
+	m.Keepalive = time.Duration(options.IntValV("keepalive", 30000)) * time.Millisecond
+	m.KeepaliveTimeout = time.Duration(options.IntValV("keepalive_timeout", 90000)) * time.Millisecond
+	m.conf = options
+	return
+}
+
+func (m *MuxDialer) Options() util.Map {
+	return m.conf
+}
+
+//Matched will return whether the uri host is matched.
+func (m *MuxDialer) Matched(uri string) bool {
+	remote, err := url.Parse(uri)
+	return err == nil && m.matcher.MatchString(remote.Host)
+}
+
+//Dial one connection by uri
+func (m *MuxDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	raw, err = m.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext will open one stream by sid and uri on the shared session, the session is connected if not available.
+func (m *MuxDialer) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	if atomic.LoadUint32(&m.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	config, err := NewPipeConfig(m.conf, remote.Query())
+	if err != nil {
+		return
+	}
+	session, err := m.connect(ctx)
+	if err != nil {
+		return
+	}
+	stream, err := session.Open(ctx, sid, uri)
+	if err != nil {
+		return
+	}
+	pipable := NewCopyPipable(stream)
+	pipable.Config = config
+	raw = pipable
+	if pipe != nil {
+		err = raw.Pipe(pipe)
+	}
+	if err != nil {
+		stream.Close()
+	}
+	return
+}
+
+//connect will return the available session, the new session is connected if not available.
+func (m *MuxDialer) connect(ctx context.Context) (session *MuxSession, err error) {
+	m.sessionLck.Lock()
+	defer m.sessionLck.Unlock()
+	if m.session != nil && m.session.IsAvailable() {
+		session = m.session
+		return
+	}
+	if atomic.LoadUint32(&m.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
+	connect := m.Connect
+	if connect == nil {
+		connect = m.dialServer
+	}
+	raw, err := connect(ctx)
+	if err != nil {
+		err = &CodeError{Inner: err, ByteCode: CodeProxy}
+		return
+	}
+	log.D("MuxDialer(%v) connected to %v", m.ID, m.Address)
+	session = NewMuxSession(raw)
+	session.Window = m.Window
+	session.Keepalive = m.Keepalive
+	session.KeepaliveTimeout = m.KeepaliveTimeout
+	session.Start()
+	if m.session != nil {
+		//the old session is closed after all streams closed.
+		go m.session.Shutdown(context.Background())
+	}
+	m.session = session
+	return
+}
+
+func (m *MuxDialer) dialServer(ctx context.Context) (raw io.ReadWriteCloser, err error) {
+	raw, err = dialAddress(ctx, m.Address)
+	return
+}
+
+//Shutdown will refuse the new dial and shutdown the session gracefully until ctx done.
+func (m *MuxDialer) Shutdown(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapUint32(&m.shutdown, 0, 1) {
+		return
+	}
+	m.sessionLck.Lock()
+	session := m.session
+	m.session = nil
+	m.sessionLck.Unlock()
+	if session != nil {
+		err = session.Shutdown(ctx)
+		if err == ErrShutdown {
+			err = nil
+		}
+	}
+	return
+}
+
+func (m *MuxDialer) String() string {
+	return "MuxDialer(" + m.ID + ")"
+}
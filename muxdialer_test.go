@@ -0,0 +1,91 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestMuxDialer(t *testing.T) {
+	mux := NewMuxDialer()
+	for _, options := range []util.Map{
+		{},
+		{"id": "mux"},
+		{"id": "mux", "address": "127.0.0.1:1", "matcher": "(xx"},
+		{"id": "mux", "address": "127.0.0.1:1", "window": "x"},
+	} {
+		if err := mux.Bootstrap(options); err == nil {
+			t.Error(options)
+			return
+		}
+	}
+	err := mux.Bootstrap(util.Map{"id": "mux", "address": "127.0.0.1:1", "matcher": "^x.*$"})
+	if err != nil || mux.Name() != "mux" || mux.Options() == nil || mux.String() != "MuxDialer(mux)" {
+		t.Error(err)
+		return
+	}
+	if !mux.Matched("tcp://xx:10") || mux.Matched("tcp://echo") || mux.Matched("%x://") {
+		t.Error("error")
+		return
+	}
+	//connect fail
+	_, err = mux.Dial(1, "tcp://xx:10", nil)
+	if ErrorCode(err) != CodeProxy {
+		t.Error(err)
+		return
+	}
+	_, err = mux.Dial(1, "%x://", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = mux.Dial(1, "tcp://xx:10?idle_timeout=x", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//unix socket and custom connect
+	mux.Address = "unix:/tmp/none-mux.sock"
+	_, err = mux.Dial(1, "tcp://xx:10", nil)
+	if ErrorCode(err) != CodeProxy {
+		t.Error(err)
+		return
+	}
+	mux.Connect = func(ctx context.Context) (io.ReadWriteCloser, error) {
+		a, b := net.Pipe()
+		server := NewMuxSession(b)
+		server.Server = true
+		server.OnOpen = echoMuxStream
+		server.Start()
+		return a, nil
+	}
+	conn, err := mux.Dial(1, "tcp://xx:10", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(conn, "abc")
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", string(buf), err)
+		return
+	}
+	conn.Close()
+	//shutdown
+	err = mux.Shutdown(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	mux.Shutdown(context.Background())
+	_, err = mux.Dial(1, "tcp://xx:10", nil)
+	if err != ErrShutdown {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,229 @@
+package dialer
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+//PipeConfig is the config of piping two connection
+type PipeConfig struct {
+	//whether propagate close write to other side when one direction is finished,
+	//the connections are fully closed after both direction finished.
+	//if it is false or close write is not supported, the connections are fully closed when one direction is finished.
+	HalfClose bool
+	//the connections are closed when not data transferred in idle timeout, zero is not timeout.
+	IdleTimeout time.Duration
+	//the connections are closed when living over max lifetime, zero is not timeout.
+	MaxLifetime time.Duration
+	//the rate limit of this connection, it can be changed on runtime by SetRate.
+	Limit *RateLimit
+}
+
+//NewPipeConfig will return the pipe config by dialer options and uri query, the query is having higher priority.
+//the idle_timeout/max_lifetime is in millisecond, the rate_up/rate_down is in bytes per second.
+func NewPipeConfig(options util.Map, query url.Values) (config *PipeConfig, err error) {
+	config = &PipeConfig{
+		HalfClose: options.IntValV("half_close", 1) > 0,
+	}
+	values := map[string]int64{}
+	for _, key := range []string{"idle_timeout", "max_lifetime", "rate_up", "rate_down"} {
+		values[key] = options.IntValV(key, 0)
+		val := query.Get(key)
+		if len(val) < 1 {
+			continue
+		}
+		values[key], err = strconv.ParseInt(val, 10, 64)
+		if err != nil || values[key] < 0 {
+			err = fmt.Errorf("parse %v(%v) fail with %v", key, val, err)
+			return
+		}
+	}
+	if half := query.Get("half_close"); len(half) > 0 {
+		config.HalfClose = half != "0"
+	}
+	config.IdleTimeout = time.Duration(values["idle_timeout"]) * time.Millisecond
+	config.MaxLifetime = time.Duration(values["max_lifetime"]) * time.Millisecond
+	config.Limit = NewRateLimit(values["rate_up"], values["rate_down"])
+	return
+}
+
+//PipeConfigConn is the interface to get the PipeConfig of Conn before piping.
+type PipeConfigConn interface {
+	PipeConfig() *PipeConfig
+}
+
+func connPipeConfig(conn interface{}) (config *PipeConfig) {
+	if c, ok := conn.(PipeConfigConn); ok {
+		config = c.PipeConfig()
+	}
+	return
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+//ErrCloseWriteNotSupported is the error of shutting down the writing side of connection which is not supported.
+var ErrCloseWriteNotSupported = fmt.Errorf("close write is not supported")
+
+//closeWrite will shut down the writing side of connection, it return ErrCloseWriteNotSupported if it is not supported.
+func closeWrite(w io.Writer) error {
+	if cw, ok := w.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return ErrCloseWriteNotSupported
+}
+
+//countWriter will count the written bytes and limit the writing rate by limiters.
+type countWriter struct {
+	io.Writer
+	count    func(n int64)
+	last     *int64
+	limiters rateLimiters
+}
+
+func (c *countWriter) Write(p []byte) (n int, err error) {
+	n, err = c.limiters.write(c.Writer, p, c.done)
+	return
+}
+
+//done will count the written bytes and update the last active time
+func (c *countWriter) done(n int) {
+	c.count(int64(n))
+	atomic.StoreInt64(c.last, util.Now())
+}
+
+//readOnly will hide the WriterTo of reader, so io.CopyBuffer will copy by the given buffer.
+type readOnly struct {
+	io.Reader
+}
+
+//spliceDisabled is whether splice is disabled on runtime, it is accessed by atomic.
+var spliceDisabled uint32
+
+//enableSplice will enable or disable copying by splice when it is supported.
+func enableSplice(enabled bool) {
+	if enabled {
+		atomic.StoreUint32(&spliceDisabled, 0)
+	} else {
+		atomic.StoreUint32(&spliceDisabled, 1)
+	}
+}
+
+//pipeBuffers is the shared buffers for copying data when splice is not supported.
+var pipeBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 32*1024)
+		return &buf
+	},
+}
+
+//piper will copy data between remote and local connection
+type piper struct {
+	remote io.ReadWriteCloser
+	local  io.ReadWriteCloser
+	stats  *ConnStats
+	config *PipeConfig
+	remain int32
+	closed sync.Once
+	last   int64
+	done   chan int
+}
+
+//pipeCopy will copy data between remote and local connection until both direction is finished, then close both.
+//the stats is done after both direction is finished.
+func pipeCopy(remote, local io.ReadWriteCloser, stats *ConnStats, config *PipeConfig) {
+	if config == nil {
+		config = &PipeConfig{}
+	}
+	p := &piper{
+		remote: remote,
+		local:  local,
+		stats:  stats,
+		config: config,
+		remain: 2,
+		last:   util.Now(),
+		done:   make(chan int),
+	}
+	var up, down rateLimiters
+	if config.Limit != nil {
+		up, down = up.append(config.Limit.Up), down.append(config.Limit.Down)
+	}
+	go p.copyAndClose(remote, local, &countWriter{Writer: remote, count: stats.AddUp, last: &p.last, limiters: up}, "local")
+	go p.copyAndClose(local, remote, &countWriter{Writer: local, count: stats.AddDown, last: &p.last, limiters: down}, "remote")
+	if config.IdleTimeout > 0 || config.MaxLifetime > 0 {
+		go p.loopTimeout()
+	}
+}
+
+func (p *piper) copyAndClose(dst, src io.ReadWriteCloser, writer *countWriter, side string) {
+	handled, err := spliceCopy(dst, src, writer, p.config.IdleTimeout > 0)
+	if !handled {
+		buf := pipeBuffers.Get().(*[]byte)
+		_, err = io.CopyBuffer(writer, readOnly{Reader: src}, *buf)
+		pipeBuffers.Put(buf)
+	}
+	reason := side + " closed"
+	if err != nil {
+		reason = side + " error: " + err.Error()
+	}
+	p.stats.SetReason(reason)
+	if atomic.AddInt32(&p.remain, -1) == 0 {
+		p.close()
+		close(p.done)
+		p.stats.Done(reason)
+		return
+	}
+	if err == nil && p.config.HalfClose && closeWrite(dst) == nil {
+		//wait other direction finished
+		return
+	}
+	p.close()
+}
+
+func (p *piper) close() {
+	p.closed.Do(func() {
+		p.remote.Close()
+		p.local.Close()
+	})
+}
+
+//loopTimeout will close the connections when idle timeout or max lifetime timeout.
+func (p *piper) loopTimeout() {
+	begin := util.Now()
+	idle, lifetime := int64(p.config.IdleTimeout/time.Millisecond), int64(p.config.MaxLifetime/time.Millisecond)
+	for {
+		now := util.Now()
+		var wait int64 = -1
+		if lifetime > 0 {
+			wait = begin + lifetime - now
+			if wait <= 0 {
+				p.stats.SetReason("max lifetime timeout")
+				break
+			}
+		}
+		if idle > 0 {
+			idleWait := atomic.LoadInt64(&p.last) + idle - now
+			if idleWait <= 0 {
+				p.stats.SetReason("idle timeout")
+				break
+			}
+			if wait < 0 || idleWait < wait {
+				wait = idleWait
+			}
+		}
+		select {
+		case <-p.done:
+			return
+		case <-time.After(time.Duration(wait) * time.Millisecond):
+		}
+	}
+	p.close()
+}
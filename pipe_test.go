@@ -0,0 +1,293 @@
+package dialer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+//tcpPair will return two connected tcp connection
+func tcpPair() (a, b *net.TCPConn, err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return
+	}
+	a, b = conn.(*net.TCPConn), (<-accepted).(*net.TCPConn)
+	return
+}
+
+//runReplyServer will run the server which read all data and reply the data length.
+func runReplyServer() (l net.Listener, err error) {
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				n, _ := io.Copy(ioutil.Discard, conn)
+				fmt.Fprintf(conn, "got:%v", n)
+				conn.Close()
+			}()
+		}
+	}()
+	return
+}
+
+func TestPipeHalfClose(t *testing.T) {
+	l, err := runReplyServer()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+	dialer := NewTCPDialer()
+	dialer.Bootstrap(util.Map{})
+	//half close
+	local, remote, err := tcpPair()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := dialer.Dial(10, "tcp://"+l.Addr().String(), remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(local, "hello")
+	local.CloseWrite()
+	data, err := ioutil.ReadAll(local)
+	if err != nil || string(data) != "got:5" {
+		t.Errorf("%v,%v", string(data), err)
+		return
+	}
+	if stats := conn.(StatsConn).Stats().Snapshot(); stats.Up != 5 || stats.Down != 5 {
+		t.Error(stats)
+		return
+	}
+	//full close
+	local, remote, err = tcpPair()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.Dial(10, "tcp://"+l.Addr().String()+"?half_close=0", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(local, "hello")
+	local.CloseWrite()
+	data, _ = ioutil.ReadAll(local)
+	if len(data) > 0 {
+		t.Error(string(data))
+		return
+	}
+}
+
+func TestPipedConnCloseWrite(t *testing.T) {
+	a, b, err := CreatePipedConn()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(a, "abc")
+	a.CloseWrite()
+	data, err := ioutil.ReadAll(b)
+	if err != nil || string(data) != "abc" {
+		t.Errorf("%v,%v", string(data), err)
+		return
+	}
+	fmt.Fprintf(b, "123")
+	b.CloseWrite()
+	data, err = ioutil.ReadAll(a)
+	if err != nil || string(data) != "123" {
+		t.Errorf("%v,%v", string(data), err)
+		return
+	}
+	a.Close()
+}
+
+func TestPipeConfig(t *testing.T) {
+	config, err := NewPipeConfig(nil, nil)
+	if err != nil || !config.HalfClose || config.IdleTimeout != 0 || config.MaxLifetime != 0 {
+		t.Error(err)
+		return
+	}
+	config, _ = NewPipeConfig(util.Map{"half_close": 0, "idle_timeout": 100}, url.Values{})
+	if config.HalfClose || config.IdleTimeout != 100*time.Millisecond {
+		t.Error("error")
+		return
+	}
+	config, _ = NewPipeConfig(util.Map{"half_close": 0, "idle_timeout": 100}, url.Values{
+		"half_close":   {"1"},
+		"idle_timeout": {"200"},
+		"max_lifetime": {"300"},
+	})
+	if !config.HalfClose || config.IdleTimeout != 200*time.Millisecond || config.MaxLifetime != 300*time.Millisecond {
+		t.Error("error")
+		return
+	}
+	config, _ = NewPipeConfig(util.Map{"rate_up": 100, "rate_down": 200}, url.Values{"rate_down": {"300"}})
+	if config.Limit.Up.Rate() != 100 || config.Limit.Down.Rate() != 300 {
+		t.Error(config.Limit)
+		return
+	}
+	//
+	//test error
+	_, err = NewPipeConfig(nil, url.Values{"idle_timeout": {"x"}})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = NewPipeConfig(nil, url.Values{"max_lifetime": {"-1"}})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	if closeWrite(&OnceDialer{}) != ErrCloseWriteNotSupported {
+		t.Error("error")
+		return
+	}
+}
+
+func TestPipeTimeout(t *testing.T) {
+	dialer := NewEchoDialer()
+	dialer.Bootstrap(util.Map{"idle_timeout": 100})
+	//idle timeout by dialer options
+	local, remote := net.Pipe()
+	conn, err := dialer.Dial(10, "tcp://echo", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	begin := time.Now()
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(local, "abc")
+		local.Read(buf)
+		time.Sleep(50 * time.Millisecond)
+	}
+	_, err = local.Read(buf)
+	used := time.Since(begin)
+	if err == nil || used < 200*time.Millisecond || used > time.Second {
+		t.Errorf("%v,%v", err, used)
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	if reason := conn.(StatsConn).Stats().Snapshot().Reason; reason != "idle timeout" {
+		t.Error(reason)
+		return
+	}
+	//max lifetime by uri
+	local, remote = net.Pipe()
+	conn, err = dialer.Dial(10, "tcp://echo?max_lifetime=150&idle_timeout=0", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	begin = time.Now()
+	for {
+		fmt.Fprintf(local, "abc")
+		_, err = local.Read(buf)
+		if err != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	used = time.Since(begin)
+	if used < 150*time.Millisecond || used > time.Second {
+		t.Errorf("%v,%v", err, used)
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	if reason := conn.(StatsConn).Stats().Snapshot().Reason; reason != "max lifetime timeout" {
+		t.Error(reason)
+		return
+	}
+	//reusable
+	local, remote = net.Pipe()
+	reusable := NewReusableRWC(NewEchoReadWriteCloser())
+	reusable.Config = &PipeConfig{IdleTimeout: 50 * time.Millisecond}
+	reusable.Pipe(remote)
+	_, err = local.Read(buf)
+	if err == nil || reusable.Stats().Snapshot().Reason != "idle timeout" {
+		t.Error(err)
+		return
+	}
+	//
+	//test error
+	_, err = dialer.Dial(10, "tcp://echo?idle_timeout=x", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPipeSplice(t *testing.T) {
+	l, err := runReplyServer()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+	tcp := NewTCPDialer()
+	tcp.Bootstrap(nil)
+	for _, query := range []string{"", "?rate_up=20000000", "?idle_timeout=1000"} {
+		for _, splice := range []bool{true, false} {
+			enableSplice(splice)
+			local, raw, err := tcpPair()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn, err := tcp.Dial(10, "tcp://"+l.Addr().String()+query, raw)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			//wait piping done
+			done := make(chan int)
+			conn.(StatsConn).Stats().OnDone(func(*ConnStats) {
+				close(done)
+			})
+			go func() {
+				local.Write(make([]byte, 1024*1024))
+				local.CloseWrite()
+			}()
+			data, err := ioutil.ReadAll(local)
+			if err != nil || string(data) != "got:1048576" {
+				t.Errorf("%v,%v", err, string(data))
+				return
+			}
+			local.Close()
+			<-done
+			stats := conn.(StatsConn).Stats().Snapshot()
+			if stats.Up != 1024*1024 || stats.Down != 11 || stats.End.IsZero() {
+				t.Error(stats)
+				return
+			}
+		}
+	}
+	enableSplice(true)
+}
@@ -0,0 +1,211 @@
+package dialer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+//RateLimiter is the token bucket to limit the bytes per second, it can be shared by multi connections.
+//the bucket is having 100ms bytes of burst, and the rate can be changed on runtime.
+type RateLimiter struct {
+	rate   int64 //the bytes per second, zero is not limited.
+	tokens float64
+	last   time.Time
+	lck    sync.Mutex
+}
+
+//NewRateLimiter will return new RateLimiter by bytes per second, zero is not limited.
+func NewRateLimiter(rate int64) *RateLimiter {
+	limiter := &RateLimiter{lck: sync.Mutex{}}
+	limiter.SetRate(rate)
+	return limiter
+}
+
+//SetRate will change the bytes per second, zero or negative is not limited.
+func (r *RateLimiter) SetRate(rate int64) {
+	if rate < 0 {
+		rate = 0
+	}
+	r.lck.Lock()
+	r.rate = rate
+	r.last = time.Now()
+	if burst := float64(r.burst()); r.tokens > burst {
+		r.tokens = burst
+	}
+	r.lck.Unlock()
+}
+
+//Rate will return the bytes per second, zero is not limited.
+func (r *RateLimiter) Rate() (rate int64) {
+	if r == nil {
+		return
+	}
+	r.lck.Lock()
+	rate = r.rate
+	r.lck.Unlock()
+	return
+}
+
+//Burst will return the max bytes can be taken without waiting, it return zero if not limited.
+func (r *RateLimiter) Burst() (burst int) {
+	if r == nil {
+		return
+	}
+	r.lck.Lock()
+	burst = r.burst()
+	r.lck.Unlock()
+	return
+}
+
+func (r *RateLimiter) burst() int {
+	if r.rate < 1 {
+		return 0
+	}
+	burst := r.rate / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return int(burst)
+}
+
+//Reserve will take n bytes from bucket and return the duration to wait before using them.
+func (r *RateLimiter) Reserve(n int) (wait time.Duration) {
+	if r == nil {
+		return
+	}
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if r.rate < 1 {
+		return
+	}
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * float64(r.rate)
+	r.last = now
+	if burst := float64(r.burst()); r.tokens > burst {
+		r.tokens = burst
+	}
+	r.tokens -= float64(n)
+	if r.tokens < 0 {
+		wait = time.Duration(-r.tokens / float64(r.rate) * float64(time.Second))
+	}
+	return
+}
+
+//Wait will take n bytes from bucket and wait until they are available.
+func (r *RateLimiter) Wait(n int) {
+	if wait := r.Reserve(n); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func (r *RateLimiter) String() string {
+	return fmt.Sprintf("RateLimiter(%v)", r.Rate())
+}
+
+//RateLimit is the up and down rate limiter of connections.
+type RateLimit struct {
+	Up   *RateLimiter //the limiter from local to remote
+	Down *RateLimiter //the limiter from remote to local
+}
+
+//NewRateLimit will return new RateLimit by up and down bytes per second, zero is not limited.
+func NewRateLimit(up, down int64) *RateLimit {
+	return &RateLimit{
+		Up:   NewRateLimiter(up),
+		Down: NewRateLimiter(down),
+	}
+}
+
+//SetRate will change the up and down bytes per second on runtime, zero is not limited.
+func (r *RateLimit) SetRate(up, down int64) {
+	r.Up.SetRate(up)
+	r.Down.SetRate(down)
+}
+
+func (r *RateLimit) String() string {
+	return fmt.Sprintf("RateLimit(%v,%v)", r.Up.Rate(), r.Down.Rate())
+}
+
+//rateLimiters is the limiters which must all be passed before reading or writing.
+type rateLimiters []*RateLimiter
+
+func (r rateLimiters) append(limiters ...*RateLimiter) rateLimiters {
+	for _, limiter := range limiters {
+		if limiter != nil {
+			r = append(r, limiter)
+		}
+	}
+	return r
+}
+
+//active will return true if any limiter is limited.
+func (r rateLimiters) active() bool {
+	for _, limiter := range r {
+		if limiter.Rate() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//chunk will return the size which is not over the burst of all limiters.
+func (r rateLimiters) chunk(size int) int {
+	for _, limiter := range r {
+		if burst := limiter.Burst(); burst > 0 && burst < size {
+			size = burst
+		}
+	}
+	return size
+}
+
+//wait will take n bytes from all limiters and wait until they are available.
+func (r rateLimiters) wait(n int) {
+	var wait time.Duration
+	for _, limiter := range r {
+		if w := limiter.Reserve(n); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+//write will write p to w by chunk and wait limiters before writing each chunk, the done is called after each chunk written.
+func (r rateLimiters) write(w io.Writer, p []byte, done func(n int)) (n int, err error) {
+	if len(r) < 1 {
+		n, err = w.Write(p)
+		done(n)
+		return
+	}
+	for len(p) > 0 {
+		size := r.chunk(len(p))
+		r.wait(size)
+		var written int
+		written, err = w.Write(p[:size])
+		n += written
+		done(written)
+		if err != nil {
+			return
+		}
+		p = p[size:]
+	}
+	return
+}
+
+//DialerLimit will return the total rate limit of all sessions dialed by dialer name, it is created if not exists.
+func (p *Pool) DialerLimit(name string) (limit *RateLimit) {
+	p.limitsLck.Lock()
+	defer p.limitsLck.Unlock()
+	if p.limits == nil {
+		p.limits = map[string]*RateLimit{}
+	}
+	limit = p.limits[name]
+	if limit == nil {
+		limit = NewRateLimit(0, 0)
+		p.limits[name] = limit
+	}
+	return
+}
@@ -0,0 +1,151 @@
+package dialer
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1000)
+	if limiter.Rate() != 1000 || limiter.Burst() != 100 {
+		t.Error(limiter)
+		return
+	}
+	begin := time.Now()
+	buf := bytes.NewBuffer(nil)
+	n, err := rateLimiters{limiter}.write(buf, make([]byte, 300), func(int) {})
+	used := time.Since(begin)
+	if err != nil || n != 300 || buf.Len() != 300 || used < 250*time.Millisecond || used > time.Second {
+		t.Errorf("%v,%v,%v", n, err, used)
+		return
+	}
+	//not limited
+	limiter.SetRate(0)
+	if limiter.Burst() != 0 || limiter.Reserve(1000000) != 0 {
+		t.Error(limiter)
+		return
+	}
+	limiter.SetRate(-1)
+	if limiter.Rate() != 0 {
+		t.Error(limiter)
+		return
+	}
+	limiter.SetRate(5)
+	if limiter.Burst() != 1 {
+		t.Error(limiter)
+		return
+	}
+	//nil limiter
+	var none *RateLimiter
+	if none.Rate() != 0 || none.Burst() != 0 || none.Reserve(100) != 0 {
+		t.Error("error")
+		return
+	}
+	limit := NewRateLimit(100, 200)
+	limit.SetRate(300, 400)
+	if limit.Up.Rate() != 300 || limit.Down.Rate() != 400 {
+		t.Error(limit)
+		return
+	}
+	t.Log(limit)
+}
+
+func echoUsed(local io.ReadWriter, size int) (used time.Duration, err error) {
+	begin := time.Now()
+	go local.Write(make([]byte, size))
+	_, err = io.ReadFull(local, make([]byte, size))
+	used = time.Since(begin)
+	return
+}
+
+func TestPoolRateLimit(t *testing.T) {
+	pool := NewPool()
+	err := pool.Bootstrap(util.Map{
+		"dialers": []util.Map{
+			{
+				"type":          "echo",
+				"total_rate_up": 1000,
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//limit by dialer total
+	local, remote := net.Pipe()
+	_, err = pool.Dial(1, "tcp://echo", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	used, err := echoUsed(local, 300)
+	if err != nil || used < 250*time.Millisecond {
+		t.Errorf("%v,%v", err, used)
+		return
+	}
+	pool.DialerLimit("echo").SetRate(0, 0)
+	used, err = echoUsed(local, 300)
+	if err != nil || used > 200*time.Millisecond {
+		t.Errorf("%v,%v", err, used)
+		return
+	}
+	//limit by session and change on runtime
+	conn, err := pool.Dial(2, "tcp://echo?rate_down=1000", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	local, remote = net.Pipe()
+	conn.Pipe(remote)
+	used, err = echoUsed(local, 300)
+	if err != nil || used < 250*time.Millisecond {
+		t.Errorf("%v,%v", err, used)
+		return
+	}
+	err = pool.SetRate(2, 0, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	used, err = echoUsed(local, 300)
+	if err != nil || used > 200*time.Millisecond {
+		t.Errorf("%v,%v", err, used)
+		return
+	}
+	//limit by pool total
+	pool.Limit = NewRateLimit(0, 1000)
+	local, remote = net.Pipe()
+	_, err = pool.Dial(3, "tcp://echo", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	used, err = echoUsed(local, 300)
+	if err != nil || used < 250*time.Millisecond {
+		t.Errorf("%v,%v", err, used)
+		return
+	}
+	//
+	//test error
+	err = pool.SetRate(100, 0, 0)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	session := &Session{SID: 100}
+	if session.SetRate(0, 0) == nil {
+		t.Error("error")
+		return
+	}
+	_, err = pool.Dial(4, "tcp://echo?rate_up=x", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
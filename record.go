@@ -0,0 +1,399 @@
+package dialer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+//the type of record entry
+const (
+	RecordHeader = "header" //the first entry of record, having sid/uri/begin
+	RecordUp     = "up"     //the data from local to remote
+	RecordDown   = "down"   //the data from remote to local
+	RecordClose  = "close"  //the last entry of record, having close reason
+)
+
+//RecordVersion is the version of record format
+const RecordVersion = 1
+
+//RecordEntry is one line of record in JSONL format, the record format is:
+//	{"type":"header","version":1,"sid":1,"uri":"tcp://host:port","begin":1500000000000}
+//	{"type":"up","time":10,"data":"<base64>"}
+//	{"type":"down","time":25,"data":"<base64>"}
+//	{"type":"close","time":30,"reason":"remote closed"}
+//the begin is unix time in millisecond, the time is millisecond from begin.
+type RecordEntry struct {
+	Type    string `json:"type"`
+	Version int    `json:"version,omitempty"`
+	SID     uint64 `json:"sid,omitempty"`
+	URI     string `json:"uri,omitempty"`
+	Begin   int64  `json:"begin,omitempty"`
+	Time    int64  `json:"time"`
+	Data    []byte `json:"data,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+//Recorder will write the timestamped up and down chunks of one session in JSONL format.
+type Recorder struct {
+	Begin  int64
+	writer io.Writer
+	lck    sync.Mutex
+	closed bool
+}
+
+//NewRecorder will return new Recorder which writes to writer, the header is written directly.
+func NewRecorder(writer io.Writer, sid uint64, uri string) (recorder *Recorder, err error) {
+	recorder = &Recorder{
+		Begin:  util.Now(),
+		writer: writer,
+		lck:    sync.Mutex{},
+	}
+	err = recorder.write(&RecordEntry{
+		Type:    RecordHeader,
+		Version: RecordVersion,
+		SID:     sid,
+		URI:     uri,
+		Begin:   recorder.Begin,
+	})
+	return
+}
+
+//NewFileRecorder will return new Recorder which writes to file, the file is closed when recorder closed.
+func NewFileRecorder(filename string, sid uint64, uri string) (recorder *Recorder, err error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	recorder, err = NewRecorder(file, sid, uri)
+	if err != nil {
+		file.Close()
+	}
+	return
+}
+
+func (r *Recorder) write(entry *RecordEntry) (err error) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if r.closed {
+		err = fmt.Errorf("recorder is closed")
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = r.writer.Write(append(data, '\n'))
+	}
+	return
+}
+
+//Up will record the data from local to remote
+func (r *Recorder) Up(p []byte) error {
+	return r.write(&RecordEntry{Type: RecordUp, Time: util.Now() - r.Begin, Data: p})
+}
+
+//Down will record the data from remote to local
+func (r *Recorder) Down(p []byte) error {
+	return r.write(&RecordEntry{Type: RecordDown, Time: util.Now() - r.Begin, Data: p})
+}
+
+//Close will record the close reason and close the writer if it is io.Closer, it return error if it is closed.
+func (r *Recorder) Close(reason string) (err error) {
+	err = r.write(&RecordEntry{Type: RecordClose, Time: util.Now() - r.Begin, Reason: reason})
+	if err != nil {
+		return
+	}
+	r.lck.Lock()
+	r.closed = true
+	r.lck.Unlock()
+	if closer, ok := r.writer.(io.Closer); ok {
+		err = closer.Close()
+	}
+	return
+}
+
+//ReadRecord will read all record entries from reader, the first entry must be header.
+func ReadRecord(reader io.Reader) (entries []*RecordEntry, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) < 1 {
+			continue
+		}
+		entry := &RecordEntry{}
+		err = json.Unmarshal(data, entry)
+		if err != nil {
+			err = fmt.Errorf("parse record on line %v fail with %v", line, err)
+			return
+		}
+		if len(entries) < 1 && entry.Type != RecordHeader {
+			err = fmt.Errorf("parse record on line %v fail with header is required", line)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	err = scanner.Err()
+	if err == nil && len(entries) < 1 {
+		err = fmt.Errorf("record is empty")
+	}
+	return
+}
+
+//ReadRecordFile will read all record entries from file.
+func ReadRecordFile(filename string) (entries []*RecordEntry, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	entries, err = ReadRecord(file)
+	return
+}
+
+//RecordConn is an implementation of the Conn interface to record all data of connection.
+//it must be created before piping, the data piped before is not recorded.
+type RecordConn struct {
+	Conn
+	Recorder *Recorder
+}
+
+//NewRecordConn will return new RecordConn which records conn by recorder.
+func NewRecordConn(conn Conn, recorder *Recorder) *RecordConn {
+	return &RecordConn{Conn: conn, Recorder: recorder}
+}
+
+func (r *RecordConn) Read(p []byte) (n int, err error) {
+	n, err = r.Conn.Read(p)
+	if n > 0 {
+		r.Recorder.Down(p[:n])
+	}
+	return
+}
+
+func (r *RecordConn) Write(p []byte) (n int, err error) {
+	n, err = r.Conn.Write(p)
+	if n > 0 {
+		r.Recorder.Up(p[:n])
+	}
+	return
+}
+
+//Pipe the raw connection and record all data, the recorder is closed with reason when piping is done,
+//or it is closed when the raw connection is closed if the connection has no stats.
+func (r *RecordConn) Pipe(raw io.ReadWriteCloser) (err error) {
+	rwc := &recordRWC{ReadWriteCloser: raw, recorder: r.Recorder}
+	if stats := connStats(r.Conn); stats != nil {
+		stats.OnDone(func(stats *ConnStats) {
+			r.Recorder.Close(stats.Reason)
+		})
+	} else {
+		rwc.closing = true
+	}
+	err = r.Conn.Pipe(rwc)
+	return
+}
+
+//Stats will return the accounting of connection if supported.
+func (r *RecordConn) Stats() *ConnStats {
+	return connStats(r.Conn)
+}
+
+//PipeConfig will return the config of piping if supported.
+func (r *RecordConn) PipeConfig() *PipeConfig {
+	return connPipeConfig(r.Conn)
+}
+
+//Close the connection and recorder
+func (r *RecordConn) Close() (err error) {
+	err = r.Conn.Close()
+	r.Recorder.Close("closed")
+	return
+}
+
+type recordRWC struct {
+	io.ReadWriteCloser
+	recorder *Recorder
+	closing  bool //whether close recorder when closed
+}
+
+func (r *recordRWC) Read(p []byte) (n int, err error) {
+	n, err = r.ReadWriteCloser.Read(p)
+	if n > 0 {
+		r.recorder.Up(p[:n])
+	}
+	return
+}
+
+func (r *recordRWC) Write(p []byte) (n int, err error) {
+	n, err = r.ReadWriteCloser.Write(p)
+	if n > 0 {
+		r.recorder.Down(p[:n])
+	}
+	return
+}
+
+func (r *recordRWC) Close() (err error) {
+	err = r.ReadWriteCloser.Close()
+	if r.closing {
+		r.recorder.Close("closed")
+	}
+	return
+}
+
+func (r *recordRWC) CloseWrite() error {
+	return closeWrite(r.ReadWriteCloser)
+}
+
+//ReplayDialer is an implementation of the Dialer interface to replay the recorded session as fake remote by tcp://replay?file=xx.
+//the down data is sent to local and the up data is read from local by record order,
+//when strict is set, the connection is closed if the up data is not matched with record.
+//when timing is set, the data is sent by recorded time.
+type ReplayDialer struct {
+	conf     util.Map
+	shutdown uint32
+}
+
+//ReplayDialerSchema is the config schema of ReplayDialer
+var ReplayDialerSchema = NewSchema(
+	&SchemaField{Name: "file", Type: FieldString, Description: "the record file to replay"},
+	&SchemaField{Name: "strict", Type: FieldInt, Default: 0, Description: "whether close connection when up data is not matched"},
+	&SchemaField{Name: "timing", Type: FieldInt, Default: 0, Description: "whether send data by recorded time"},
+).Merge(PipeSchema)
+
+//NewReplayDialer will return new ReplayDialer
+func NewReplayDialer() *ReplayDialer {
+	return &ReplayDialer{
+		conf: util.Map{},
+	}
+}
+
+//Name will return dialer name
+func (r *ReplayDialer) Name() string {
+	return "replay"
+}
+
+//Bootstrap the dialer
+func (r *ReplayDialer) Bootstrap(options util.Map) (err error) {
+	err = ReplayDialerSchema.Validate("", options, false)
+	if err == nil {
+		r.conf = options
+	}
+	return
+}
+
+func (r *ReplayDialer) Options() util.Map {
+	return r.conf
+}
+
+//Shutdown will refuse the new dial, the replaying goroutine is stopped when the connection closed.
+func (r *ReplayDialer) Shutdown(ctx context.Context) (err error) {
+	atomic.StoreUint32(&r.shutdown, 1)
+	return
+}
+
+//Matched will return whether uri is invalid
+func (r *ReplayDialer) Matched(uri string) bool {
+	target, err := url.Parse(uri)
+	return err == nil && target.Scheme == "tcp" && target.Host == "replay"
+}
+
+//Dial one replay connection
+func (r *ReplayDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	raw, err = r.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext one replay connection with context
+func (r *ReplayDialer) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	if atomic.LoadUint32(&r.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
+	err = ctx.Err()
+	if err != nil {
+		return
+	}
+	target, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	query := target.Query()
+	config, err := NewPipeConfig(r.conf, query)
+	if err != nil {
+		return
+	}
+	file := query.Get("file")
+	if len(file) < 1 {
+		file = r.conf.StrVal("file")
+	}
+	if len(file) < 1 {
+		err = fmt.Errorf("the replay file is required by %v", uri)
+		return
+	}
+	entries, err := ReadRecordFile(file)
+	if err != nil {
+		return
+	}
+	strict := r.conf.IntValV("strict", 0) > 0 || query.Get("strict") == "1"
+	timing := r.conf.IntValV("timing", 0) > 0 || query.Get("timing") == "1"
+	local, remote := net.Pipe()
+	go replayRecord(remote, entries, strict, timing)
+	pipable := NewCopyPipable(local)
+	pipable.Config = config
+	raw = pipable
+	if pipe != nil {
+		err = raw.Pipe(pipe)
+	}
+	return
+}
+
+//Local will return true, the record is replayed on local host.
+func (r *ReplayDialer) Local() bool {
+	return true
+}
+
+func (r *ReplayDialer) String() string {
+	return "ReplayDialer"
+}
+
+//replayRecord will replay the record entries on remote connection.
+func replayRecord(remote io.ReadWriteCloser, entries []*RecordEntry, strict, timing bool) {
+	defer remote.Close()
+	begin := util.Now()
+	for _, entry := range entries {
+		if timing {
+			if wait := begin + entry.Time - util.Now(); wait > 0 {
+				time.Sleep(time.Duration(wait) * time.Millisecond)
+			}
+		}
+		switch entry.Type {
+		case RecordDown:
+			if _, err := remote.Write(entry.Data); err != nil {
+				return
+			}
+		case RecordUp:
+			buf := make([]byte, len(entry.Data))
+			if _, err := io.ReadFull(remote, buf); err != nil {
+				return
+			}
+			if strict && !bytes.Equal(buf, entry.Data) {
+				return
+			}
+		case RecordClose:
+			return
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package dialer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestRecordConn(t *testing.T) {
+	pool := NewPool()
+	pool.AddDialer(NewEchoDialer())
+	conn, err := pool.Dial(1, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf := bytes.NewBuffer(nil)
+	recorder, err := NewRecorder(buf, 1, "tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	record := NewRecordConn(conn, recorder)
+	if record.Stats() == nil || record.PipeConfig() == nil {
+		t.Error("error")
+		return
+	}
+	local, remote := net.Pipe()
+	record.Pipe(remote)
+	//wait piping done, the recorder is closed by the watcher added before
+	done := make(chan int)
+	record.Stats().OnDone(func(*ConnStats) {
+		close(done)
+	})
+	fmt.Fprintf(local, "abc")
+	data := make([]byte, 1024)
+	n, err := local.Read(data)
+	if err != nil || string(data[:n]) != "abc" {
+		t.Error(err)
+		return
+	}
+	local.Close()
+	<-done
+	entries, err := ReadRecord(bytes.NewBuffer(buf.Bytes()))
+	if err != nil || len(entries) != 4 {
+		t.Errorf("%v,%v", err, buf.String())
+		return
+	}
+	if entries[0].Type != RecordHeader || entries[0].SID != 1 || entries[0].URI != "tcp://echo" || entries[0].Version != RecordVersion ||
+		entries[1].Type != RecordUp || string(entries[1].Data) != "abc" ||
+		entries[2].Type != RecordDown || string(entries[2].Data) != "abc" ||
+		entries[3].Type != RecordClose || len(entries[3].Reason) < 1 {
+		t.Error(buf.String())
+		return
+	}
+	if recorder.Up([]byte("abc")) == nil || recorder.Close("closed") == nil {
+		t.Error("error")
+		return
+	}
+	//record read/write directly
+	conn, err = pool.Dial(2, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf = bytes.NewBuffer(nil)
+	recorder, _ = NewRecorder(buf, 2, "tcp://echo")
+	record = NewRecordConn(conn, recorder)
+	fmt.Fprintf(record, "abc")
+	n, err = record.Read(data)
+	if err != nil || string(data[:n]) != "abc" {
+		t.Error(err)
+		return
+	}
+	record.Close()
+	entries, err = ReadRecord(bytes.NewBuffer(buf.Bytes()))
+	if err != nil || len(entries) != 4 || entries[3].Reason != "closed" {
+		t.Errorf("%v,%v", err, buf.String())
+		return
+	}
+}
+
+type recordCloser struct {
+	bytes.Buffer
+	closed chan int
+}
+
+func (r *recordCloser) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func TestRecordConnNoStats(t *testing.T) {
+	pool := NewPool()
+	pool.AddDialer(NewEchoDialer())
+	conn, err := pool.Dial(1, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	writer := &recordCloser{closed: make(chan int)}
+	recorder, _ := NewRecorder(writer, 1, "tcp://echo")
+	//hide the stats of connection
+	record := NewRecordConn(struct {
+		Pipable
+		io.ReadWriteCloser
+	}{conn, conn}, recorder)
+	if record.Stats() != nil {
+		t.Error("error")
+		return
+	}
+	local, remote := net.Pipe()
+	record.Pipe(remote)
+	fmt.Fprintf(local, "abc")
+	data := make([]byte, 1024)
+	n, err := local.Read(data)
+	if err != nil || string(data[:n]) != "abc" {
+		t.Error(err)
+		return
+	}
+	local.Close()
+	select {
+	case <-writer.closed:
+	case <-time.After(time.Second):
+		t.Error("recorder is not closed")
+		return
+	}
+	entries, err := ReadRecord(bytes.NewBuffer(writer.Bytes()))
+	//the down data may be not recorded when raw is closed before recording
+	last := len(entries) - 1
+	if err != nil || len(entries) < 3 || entries[1].Type != RecordUp || entries[last].Type != RecordClose || entries[last].Reason != "closed" {
+		t.Errorf("%v,%v", err, writer.String())
+		return
+	}
+}
+
+func TestReplayDialer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "session.jsonl")
+	recorder, err := NewFileRecorder(filename, 1, "tcp://test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	recorder.Up([]byte("hello"))
+	//more than checked, the recorded time is in millisecond
+	time.Sleep(110 * time.Millisecond)
+	recorder.Down([]byte("world"))
+	recorder.Close("remote closed")
+	//
+	dialer := NewReplayDialer()
+	dialer.Bootstrap(util.Map{"timing": 1})
+	if dialer.Name() != "replay" || dialer.Options() == nil || !dialer.Matched("tcp://replay?file=x") || dialer.Matched("tcp://echo") {
+		t.Error("error")
+		return
+	}
+	fmt.Println(dialer)
+	local, remote := net.Pipe()
+	begin := time.Now()
+	_, err = dialer.Dial(10, "tcp://replay?file="+filename, remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(local, "hello")
+	data, err := ioutil.ReadAll(local)
+	if err != nil || string(data) != "world" || time.Since(begin) < 100*time.Millisecond {
+		t.Errorf("%v,%v", err, string(data))
+		return
+	}
+	//strict
+	local, remote = net.Pipe()
+	_, err = dialer.Dial(10, "tcp://replay?strict=1&file="+filename, remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(local, "xxxxx")
+	data, _ = ioutil.ReadAll(local)
+	if len(data) > 0 {
+		t.Error(string(data))
+		return
+	}
+	//
+	//test error
+	_, err = dialer.Dial(10, "tcp://replay", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.Dial(10, "tcp://replay?file=/none", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.Dial(10, "tcp://replay?half_close=1&idle_timeout=x", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = NewFileRecorder(filepath.Join(dir, "none", "x"), 1, "tcp://test")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	for _, record := range []string{
+		"",
+		"xxx",
+		`{"type":"up","time":0}`,
+	} {
+		_, err = ReadRecord(strings.NewReader(record))
+		if err == nil {
+			t.Error(record)
+			return
+		}
+	}
+	_, err = NewRecorder(errWriter{}, 1, "tcp://test")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (n int, err error) {
+	err = io.ErrClosedPipe
+	return
+}
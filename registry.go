@@ -0,0 +1,119 @@
+package dialer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//DialerFactory is the func to create new Dialer by type
+type DialerFactory func() Dialer
+
+//DialerType is the registered dialer type which can be created by name in config.
+type DialerType struct {
+	Name        string
+	Description string
+	Factory     DialerFactory
+	Schema      *Schema //the config schema, the config is not validated if nil
+}
+
+var dialerTypes = map[string]*DialerType{}
+var dialerTypesLck = sync.RWMutex{}
+
+//RegisterDialerType will register dialer type by name, it return error when name is empty or registered.
+func RegisterDialerType(name string, factory DialerFactory, description string) (err error) {
+	if len(name) < 1 || factory == nil {
+		err = fmt.Errorf("the dialer type name and factory is required")
+		return
+	}
+	dialerTypesLck.Lock()
+	defer dialerTypesLck.Unlock()
+	if having, ok := dialerTypes[name]; ok {
+		err = fmt.Errorf("the dialer type(%v) is registered by %v", name, having.Description)
+		return
+	}
+	dialerTypes[name] = &DialerType{
+		Name:        name,
+		Description: description,
+		Factory:     factory,
+	}
+	return
+}
+
+//MustRegisterDialerType will register dialer type and panic when error.
+func MustRegisterDialerType(name string, factory DialerFactory, description string) {
+	err := RegisterDialerType(name, factory, description)
+	if err != nil {
+		panic(err)
+	}
+}
+
+//RegisterDialerSchema will set the config schema of registered dialer type, it return error when type is not registered.
+func RegisterDialerSchema(name string, schema *Schema) (err error) {
+	dialerTypesLck.Lock()
+	defer dialerTypesLck.Unlock()
+	dtype, ok := dialerTypes[name]
+	if !ok {
+		err = fmt.Errorf("the dialer type(%v) is not registered", name)
+		return
+	}
+	dtype.Schema = schema
+	return
+}
+
+//UnregisterDialerType will remove the dialer type by name
+func UnregisterDialerType(name string) {
+	dialerTypesLck.Lock()
+	delete(dialerTypes, name)
+	dialerTypesLck.Unlock()
+}
+
+//LookupDialerType will return the registered dialer type by name, it return nil if not found.
+func LookupDialerType(name string) (dtype *DialerType) {
+	dialerTypesLck.RLock()
+	dtype = dialerTypes[name]
+	dialerTypesLck.RUnlock()
+	return
+}
+
+//ListDialerTypes will return all registered dialer type sorted by name.
+func ListDialerTypes() (dtypes []*DialerType) {
+	dialerTypesLck.RLock()
+	defer dialerTypesLck.RUnlock()
+	var names []string
+	for name := range dialerTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dtypes = append(dtypes, dialerTypes[name])
+	}
+	return
+}
+
+func init() {
+	MustRegisterDialerType("balance", func() Dialer { return NewBalancedDialer() }, "dial by balanced dialers with limit and policy")
+	MustRegisterDialerType("cmd", func() Dialer { return NewCmdDialer() }, "start command and pipe to stdin/stdout by tcp://cmd?exec=xx")
+	MustRegisterDialerType("echo", func() Dialer { return NewEchoDialer() }, "echo back all received data by tcp://echo")
+	MustRegisterDialerType("mux", func() Dialer { return NewMuxDialer() }, "dial by mux server, all connections are carried over one shared connection")
+	MustRegisterDialerType("replay", func() Dialer { return NewReplayDialer() }, "replay the recorded session as fake remote by tcp://replay?file=xx")
+	MustRegisterDialerType("reverse", func() Dialer { return NewReverseDialer() }, "dial by the agents connected to it by tcp://agent/uri")
+	MustRegisterDialerType("socks", func() Dialer { return NewSocksProxyDialer() }, "dial tcp connection by socks5 proxy server")
+	MustRegisterDialerType("tcp", func() Dialer { return NewTCPDialer() }, "dial tcp connection directly")
+	MustRegisterDialerType("web", func() Dialer { return NewWebDialer() }, "serve webdav/file server on dir by http://web?dir=xx")
+	for name, schema := range map[string]*Schema{
+		"balance": BalancedDialerSchema,
+		"cmd":     CmdDialerSchema,
+		"echo":    EchoDialerSchema,
+		"mux":     MuxDialerSchema,
+		"replay":  ReplayDialerSchema,
+		"reverse": ReverseDialerSchema,
+		"socks":   SocksProxyDialerSchema,
+		"tcp":     TCPDialerSchema,
+		"web":     WebDialerSchema,
+	} {
+		if err := RegisterDialerSchema(name, schema); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package dialer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestRegistry(t *testing.T) {
+	err := RegisterDialerType("testing", func() Dialer { return &OnceDialer{} }, "testing dialer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer UnregisterDialerType("testing")
+	dtypes := ListDialerTypes()
+	names := []string{}
+	for _, dtype := range dtypes {
+		names = append(names, dtype.Name)
+	}
+	if fmt.Sprintf("%v", names) != "[balance cmd echo mux replay reverse socks tcp testing web]" {
+		t.Error(names)
+		return
+	}
+	if LookupDialerType("testing").Description != "testing dialer" {
+		t.Error("error")
+		return
+	}
+	//
+	pool := NewPool()
+	err = pool.Bootstrap(util.Map{
+		"dialers": []util.Map{
+			{
+				"id":   "t0",
+				"type": "testing",
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := pool.Dialers[0].(*OnceDialer); !ok {
+		t.Error("error")
+		return
+	}
+	//
+	//test error
+	err = RegisterDialerType("testing", func() Dialer { return &OnceDialer{} }, "testing dialer")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	err = RegisterDialerType("", nil, "")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("not panic")
+			}
+		}()
+		MustRegisterDialerType("tcp", func() Dialer { return NewTCPDialer() }, "")
+	}()
+	if DefaultDialerCreator("none") != nil {
+		t.Error("error")
+		return
+	}
+}
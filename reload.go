@@ -0,0 +1,398 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//Reload will reload the pool by config without dropping the live sessions.
+//the dialers created by config are diffed by name and config:
+//	unchanged: the dialer is kept
+//	new or changed: the new dialer is bootstrapped
+//	removed or changed: the old dialer is draining, it is not used by new dial and it is shutdown when all sessions closed
+//the listener of changed dialer is handed over to the new dialer if both are ListenHandover and listening on same address.
+//the dialers added by AddDialer are kept, the fallback/rate/acl/authorizer/routes are replaced by config,
+//but the acl/authorizer/routes which is not set by config are kept if the key is not exists.
+//the pool is not changed if reload fail.
+func (p *Pool) Reload(options util.Map) (err error) {
+	config, err := p.parseConfig(options)
+	if err != nil {
+		return
+	}
+	p.reloadLck.Lock()
+	defer p.reloadLck.Unlock()
+	p.configLck.RLock()
+	current, managed, last, shutdown := append([]Dialer{}, p.Dialers...), p.managed, p.config, p.shutdown
+	p.configLck.RUnlock()
+	if shutdown {
+		err = ErrShutdown
+		return
+	}
+	var dialers []Dialer
+	for _, dialer := range current {
+		if findManaged(managed, dialer) == nil {
+			dialers = append(dialers, dialer)
+		}
+	}
+	var keeps, created []*managedDialer
+	var handovers []*listenHandover
+	for _, spec := range config.dialers {
+		var having *managedDialer
+		for _, m := range managed {
+			if m.Name() == spec.name() && m.key == spec.key && findManaged(keeps, m.Dialer) == nil {
+				having = m
+				break
+			}
+		}
+		if having == nil {
+			if handover := handoverListener(managed, keeps, spec); handover != nil {
+				handovers = append(handovers, handover)
+			}
+			var dialer Dialer
+			dialer, err = spec.bootstrap()
+			if err != nil {
+				rollbackHandovers(handovers)
+				shutdownManaged(created)
+				return
+			}
+			having = &managedDialer{Dialer: dialer, key: spec.key}
+			created = append(created, having)
+		}
+		dialers = append(dialers, having.Dialer)
+		keeps = append(keeps, having)
+	}
+	err = config.checkRoutes(dialers)
+	if err != nil {
+		rollbackHandovers(handovers)
+		shutdownManaged(created)
+		return
+	}
+	var removed []Dialer
+	for _, m := range managed {
+		if findManaged(keeps, m.Dialer) == nil {
+			removed = append(removed, m.Dialer)
+		}
+	}
+	p.configLck.Lock()
+	p.Dialers, p.managed, p.config = dialers, keeps, config
+	p.draining = append(p.draining, removed...)
+	p.Fallback = config.fallback
+	if config.rate || (last != nil && last.rate) {
+		if p.Limit == nil {
+			p.Limit = NewRateLimit(config.rateUp, config.rateDown)
+		} else {
+			//the live sessions is sharing the limit
+			p.Limit.SetRate(config.rateUp, config.rateDown)
+		}
+	}
+	if config.acl != nil || (last != nil && p.ACL == last.acl) {
+		p.ACL = config.acl
+	}
+	if config.authorizer != nil || (last != nil && p.Authorizer == last.authorizer) {
+		p.Authorizer = config.authorizer
+	}
+	if config.routes != nil || (last != nil && p.Routes == last.routes) {
+		p.Routes = config.routes
+	}
+	p.configLck.Unlock()
+	p.applyDialerRates(config)
+	for _, dialer := range removed {
+		p.checkDrained(dialer)
+	}
+	return
+}
+
+//Draining will return the name of dialers which is removed by Reload and waiting all sessions closed.
+func (p *Pool) Draining() (names []string) {
+	p.configLck.RLock()
+	for _, dialer := range p.draining {
+		names = append(names, dialer.Name())
+	}
+	p.configLck.RUnlock()
+	return
+}
+
+//beginDial will mark the session is dialing by dialer, it return error if the dialer is removed from pool or pool is shutdown.
+func (p *Pool) beginDial(session *Session, dialer Dialer) (err error) {
+	p.configLck.RLock()
+	defer p.configLck.RUnlock()
+	if p.shutdown {
+		err = ErrShutdown
+		return
+	}
+	found := false
+	for _, having := range p.Dialers {
+		if having == dialer {
+			found = true
+			break
+		}
+	}
+	if !found {
+		err = fmt.Errorf("the dialer(%v) is removed from pool", dialer.Name())
+		return
+	}
+	p.sessionsLck.Lock()
+	session.dialer = dialer
+	if p.dialing == nil {
+		p.dialing = map[*Session]bool{}
+	}
+	p.dialing[session] = true
+	p.sessionsLck.Unlock()
+	return
+}
+
+//endDial will remove the dialing mark of session.
+func (p *Pool) endDial(session *Session) {
+	p.sessionsLck.Lock()
+	delete(p.dialing, session)
+	p.sessionsLck.Unlock()
+	p.checkDrained(session.dialer)
+}
+
+//checkDrained will shutdown the draining dialer if it is not having dialing or live session.
+func (p *Pool) checkDrained(dialer Dialer) {
+	if dialer == nil {
+		return
+	}
+	p.configLck.Lock()
+	index := -1
+	for i, draining := range p.draining {
+		if draining == dialer {
+			index = i
+			break
+		}
+	}
+	if index < 0 || p.dialerUsed(dialer) {
+		p.configLck.Unlock()
+		return
+	}
+	p.draining = append(p.draining[:index:index], p.draining[index+1:]...)
+	p.configLck.Unlock()
+	log.D("Pool the dialer(%v) is drained, it will be shutdown", dialer.Name())
+	shutdownDialer(dialer)
+}
+
+//used will return whether the pool is having dialing or live session.
+func (p *Pool) used() bool {
+	p.sessionsLck.RLock()
+	defer p.sessionsLck.RUnlock()
+	return len(p.dialing) > 0 || len(p.sessions) > 0
+}
+
+func (p *Pool) dialerUsed(dialer Dialer) bool {
+	p.sessionsLck.RLock()
+	defer p.sessionsLck.RUnlock()
+	for session := range p.dialing {
+		if session.dialer == dialer {
+			return true
+		}
+	}
+	for _, having := range p.sessions {
+		for _, session := range having {
+			if session.dialer == dialer {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func findManaged(managed []*managedDialer, dialer Dialer) *managedDialer {
+	for _, m := range managed {
+		if m.Dialer == dialer {
+			return m
+		}
+	}
+	return nil
+}
+
+//listenHandover is the listener handed over from the changed dialer to the new dialer on Reload.
+type listenHandover struct {
+	from, to ListenHandover
+	address  string
+}
+
+//handoverListener will hand over the listener from the changed dialer having same name to the new dialer of spec.
+func handoverListener(managed, keeps []*managedDialer, spec *dialerSpec) *listenHandover {
+	to, ok := spec.Dialer.(ListenHandover)
+	address := spec.option.StrVal("listen")
+	if !ok || len(address) < 1 {
+		return nil
+	}
+	for _, m := range managed {
+		from, ok := m.Dialer.(ListenHandover)
+		if !ok || m.Name() != spec.name() || findManaged(keeps, m.Dialer) != nil {
+			continue
+		}
+		if l := from.Handover(address); l != nil {
+			log.D("Pool the listener on %v is handed over from dialer(%v)", address, m.Name())
+			to.Takeover(address, l)
+			return &listenHandover{from: from, to: to, address: address}
+		}
+	}
+	return nil
+}
+
+//rollbackHandovers will give back the listeners to the old dialers when reload fail.
+func rollbackHandovers(handovers []*listenHandover) {
+	for _, handover := range handovers {
+		if l := handover.to.Handover(handover.address); l != nil {
+			handover.from.Takeover(handover.address, l)
+		}
+	}
+}
+
+func shutdownManaged(managed []*managedDialer) {
+	for _, m := range managed {
+		shutdownDialer(m.Dialer)
+	}
+}
+
+func shutdownDialer(dialer Dialer) {
+	if shutdowner, ok := dialer.(Shutdowner); ok {
+		err := shutdowner.Shutdown(context.Background())
+		if err != nil {
+			log.W("Pool shutdown dialer(%v) fail with %v", dialer.Name(), err)
+		}
+	}
+}
+
+//ConfigWatcher will reload the pool when the config file or included files is changed,
+//the file is checked by modify time and size on interval.
+type ConfigWatcher struct {
+	Filename string
+	Interval time.Duration
+	//the callback after reloading, the err is the loading or reloading error.
+	OnReload func(config *Config, err error)
+	pool     *Pool
+	states   map[string]string
+	stop     chan int
+	done     chan int
+	lck      sync.Mutex
+}
+
+//NewConfigWatcher will return new ConfigWatcher to reload pool by config file.
+func NewConfigWatcher(pool *Pool, filename string, interval time.Duration) *ConfigWatcher {
+	return &ConfigWatcher{
+		Filename: filename,
+		Interval: interval,
+		pool:     pool,
+		states:   map[string]string{},
+		lck:      sync.Mutex{},
+	}
+}
+
+//WatchConfig will start watching the config file to reload pool, the returned watcher should be stopped by Stop.
+func WatchConfig(pool *Pool, filename string, interval time.Duration) (watcher *ConfigWatcher, err error) {
+	watcher = NewConfigWatcher(pool, filename, interval)
+	err = watcher.Start()
+	return
+}
+
+//Start will record the current state of config files and start the checking loop.
+func (c *ConfigWatcher) Start() (err error) {
+	config, err := LoadConfig(c.Filename)
+	if err != nil {
+		return
+	}
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	if c.stop != nil {
+		err = fmt.Errorf("the config watcher is started")
+		return
+	}
+	c.states = fileStates(config.Files)
+	c.stop, c.done = make(chan int), make(chan int)
+	go c.loopCheck(c.stop, c.done)
+	return
+}
+
+//Stop the checking loop and wait it done.
+func (c *ConfigWatcher) Stop() {
+	c.lck.Lock()
+	stop, done := c.stop, c.done
+	c.stop, c.done = nil, nil
+	c.lck.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (c *ConfigWatcher) loopCheck(stop, done chan int) {
+	defer close(done)
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.Check()
+		}
+	}
+}
+
+//Check will reload the pool if any config file is changed, it return whether reloaded.
+func (c *ConfigWatcher) Check() (reloaded bool, err error) {
+	c.lck.Lock()
+	states := c.states
+	c.lck.Unlock()
+	changed := false
+	for filename, state := range states {
+		if fileState(filename) != state {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return
+	}
+	config, err := LoadConfig(c.Filename)
+	if err == nil {
+		err = config.Reload(c.pool)
+		states = fileStates(config.Files)
+	} else {
+		//not reload again until the files is changed
+		filenames := []string{}
+		for filename := range states {
+			filenames = append(filenames, filename)
+		}
+		states = fileStates(filenames)
+	}
+	c.lck.Lock()
+	c.states = states
+	c.lck.Unlock()
+	reloaded = err == nil
+	if err != nil {
+		log.W("ConfigWatcher reload pool by %v fail with %v", c.Filename, err)
+	} else {
+		log.D("ConfigWatcher reload pool by %v success", c.Filename)
+	}
+	if c.OnReload != nil {
+		c.OnReload(config, err)
+	}
+	return
+}
+
+func fileStates(filenames []string) (states map[string]string) {
+	states = map[string]string{}
+	for _, filename := range filenames {
+		states[filename] = fileState(filename)
+	}
+	return
+}
+
+func fileState(filename string) string {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%v/%v", info.ModTime().UnixNano(), info.Size())
+}
@@ -0,0 +1,281 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+type reloadDialer struct {
+	*EchoDialer
+	ID       string
+	shutdown int32
+}
+
+func newReloadDialer() Dialer {
+	return &reloadDialer{EchoDialer: NewEchoDialer()}
+}
+
+func (r *reloadDialer) Name() string {
+	return r.ID
+}
+
+func (r *reloadDialer) Bootstrap(options util.Map) (err error) {
+	r.ID = options.StrVal("id")
+	if len(r.ID) < 1 {
+		err = fmt.Errorf("id is required")
+		return
+	}
+	err = r.EchoDialer.Bootstrap(options)
+	return
+}
+
+func (r *reloadDialer) Matched(uri string) bool {
+	return uri == "reload://"+r.ID
+}
+
+func (r *reloadDialer) Shutdown(ctx context.Context) error {
+	atomic.AddInt32(&r.shutdown, 1)
+	return nil
+}
+
+func TestPoolReload(t *testing.T) {
+	RegisterDialerType("reload", newReloadDialer, "reload testing dialer")
+	defer UnregisterDialerType("reload")
+	pool := NewPool()
+	custom := NewCmdDialer()
+	pool.AddDialer(custom)
+	err := pool.Bootstrap(util.Map{
+		"echo": 1,
+		"dialers": []util.Map{
+			{"type": "reload", "id": "a"},
+			{"type": "reload", "id": "b"},
+			{"type": "reload", "id": "c", "x": 1},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	a, b, c, echo := pool.FindDialer("a").(*reloadDialer), pool.FindDialer("b"), pool.FindDialer("c").(*reloadDialer), pool.FindDialer("echo")
+	conna, err := pool.Dial(1, "reload://a", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	connc, err := pool.Dial(2, "reload://c", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = pool.Reload(util.Map{
+		"echo": 1,
+		"dialers": []util.Map{
+			{"type": "reload", "id": "b", "total_rate_up": 100},
+			{"type": "reload", "id": "c", "x": 2},
+			{"type": "reload", "id": "d"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pool.FindDialer("b") != b || pool.FindDialer("echo") != echo || pool.FindDialer("c") == c || pool.FindDialer("a") != nil {
+		t.Error("error")
+		return
+	}
+	if names := fmt.Sprintf("%v", dialerNames(pool.ListDialers())); names != "[cmd b c d echo]" {
+		t.Error(names)
+		return
+	}
+	if pool.DialerLimit("b").Up.Rate() != 100 {
+		t.Error("error")
+		return
+	}
+	if fmt.Sprintf("%v", pool.Draining()) != "[a c]" || atomic.LoadInt32(&a.shutdown) != 0 {
+		t.Error(pool.Draining())
+		return
+	}
+	//not new dial on removed dialer
+	_, err = pool.Dial(3, "reload://a", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	connd, err := pool.Dial(4, "reload://d", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	connd.Close()
+	//the session is kept
+	if pool.Session(1) == nil || pool.Session(2) == nil {
+		t.Error("error")
+		return
+	}
+	conna.Close()
+	if fmt.Sprintf("%v", pool.Draining()) != "[c]" || atomic.LoadInt32(&a.shutdown) != 1 || atomic.LoadInt32(&c.shutdown) != 0 {
+		t.Error(pool.Draining())
+		return
+	}
+	connc.Close()
+	if len(pool.Draining()) != 0 || atomic.LoadInt32(&c.shutdown) != 1 {
+		t.Error(pool.Draining())
+		return
+	}
+	//rate is reset
+	err = pool.Reload(util.Map{
+		"dialers": []util.Map{
+			{"type": "reload", "id": "b"},
+		},
+		"routes": []util.Map{
+			{"dialer": "b", "scheme": "reload"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pool.DialerLimit("b").Up.Rate() != 0 || pool.FindDialer("b") != b || pool.Routes == nil {
+		t.Error("error")
+		return
+	}
+	if names := fmt.Sprintf("%v", dialerNames(pool.ListDialers())); names != "[cmd b]" {
+		t.Error(names)
+		return
+	}
+	//not session, shutdown directly
+	if len(pool.Draining()) != 0 {
+		t.Error(pool.Draining())
+		return
+	}
+	//routes is removed
+	err = pool.Reload(util.Map{
+		"dialers": []util.Map{
+			{"type": "reload", "id": "b"},
+		},
+	})
+	if err != nil || pool.Routes != nil {
+		t.Error(err)
+		return
+	}
+	//reload fail
+	for _, options := range []util.Map{
+		{"dialers": []util.Map{{"type": "none"}}},
+		{"dialers": []util.Map{{"type": "reload", "id": "e"}, {"type": "reload"}}},
+		{"dialers": []util.Map{{"type": "reload", "id": "e"}}, "routes": []util.Map{{"dialer": "x"}}},
+		{"acl": util.Map{"default": "xx"}},
+	} {
+		err = pool.Reload(options)
+		if err == nil {
+			t.Error(options)
+			return
+		}
+		if names := fmt.Sprintf("%v", dialerNames(pool.ListDialers())); names != "[cmd b]" {
+			t.Error(names)
+			return
+		}
+	}
+	if pool.FindDialer("cmd") != custom {
+		t.Error("error")
+		return
+	}
+}
+
+func dialerNames(dialers []Dialer) (names []string) {
+	for _, dialer := range dialers {
+		names = append(names, dialer.Name())
+	}
+	return
+}
+
+func TestConfigWatcher(t *testing.T) {
+	dir, err := writeConfigFiles(map[string]string{
+		"main.yml":    "echo: 1\ninclude: dialers.yml\n",
+		"dialers.yml": "dialers:\n  - type: tcp\n",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	config, err := LoadConfig(filepath.Join(dir, "main.yml"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(config.Files) != 2 {
+		t.Error(config.Files)
+		return
+	}
+	pool := NewPool()
+	err = config.Bootstrap(pool)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reloaded := make(chan error, 10)
+	watcher := NewConfigWatcher(pool, filepath.Join(dir, "main.yml"), 10*time.Millisecond)
+	watcher.OnReload = func(config *Config, err error) {
+		reloaded <- err
+	}
+	err = watcher.Start()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer watcher.Stop()
+	if err = watcher.Start(); err == nil {
+		t.Error(err)
+		return
+	}
+	//change included file
+	err = ioutil.WriteFile(filepath.Join(dir, "dialers.yml"), []byte("dialers:\n  - type: web\n"), os.ModePerm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-reloaded; err != nil {
+		t.Error(err)
+		return
+	}
+	if names := fmt.Sprintf("%v", dialerNames(pool.ListDialers())); names != "[web echo]" {
+		t.Error(names)
+		return
+	}
+	//reload fail
+	err = ioutil.WriteFile(filepath.Join(dir, "main.yml"), []byte("echo: 1\ninclude: dialers.yml\nxx: [\n"), os.ModePerm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-reloaded; err == nil {
+		t.Error(err)
+		return
+	}
+	if names := fmt.Sprintf("%v", dialerNames(pool.ListDialers())); names != "[web echo]" {
+		t.Error(names)
+		return
+	}
+	//not changed
+	reloadedNow, err := watcher.Check()
+	if reloadedNow || err != nil {
+		t.Error(err)
+		return
+	}
+	watcher.Stop()
+	watcher.Stop()
+	//file not found
+	_, err = WatchConfig(pool, filepath.Join(dir, "none.yml"), time.Second)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,634 @@
+package dialer
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Centny/gwf/log"
+	"github.com/Centny/gwf/util"
+)
+
+//ReverseVersion is the version of reverse agent auth protocol
+const ReverseVersion = 1
+
+//WriteReverseAuth will write the auth request of agent, the frame is:
+//	| version(1) | name length(2) | name | token length(2) | token |
+//the integer is big endian.
+func WriteReverseAuth(w io.Writer, name, token string) (err error) {
+	if len(name) < 1 || len(name) > 0xffff || len(token) > 0xffff {
+		err = fmt.Errorf("the agent name length must be in 1-65535 and token length must be in 0-65535")
+		return
+	}
+	buf := make([]byte, 5+len(name)+len(token))
+	buf[0] = ReverseVersion
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(name)))
+	copy(buf[3:], name)
+	binary.BigEndian.PutUint16(buf[3+len(name):], uint16(len(token)))
+	copy(buf[5+len(name):], token)
+	_, err = w.Write(buf)
+	return
+}
+
+//ReadReverseAuth will read the auth request which is written by WriteReverseAuth.
+func ReadReverseAuth(r io.Reader) (name, token string, err error) {
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return
+	}
+	if buf[0] != ReverseVersion {
+		err = fmt.Errorf("the reverse version(%v) is not supported", buf[0])
+		return
+	}
+	data := make([]byte, binary.BigEndian.Uint16(buf[1:]))
+	if len(data) < 1 {
+		err = fmt.Errorf("the agent name is empty")
+		return
+	}
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return
+	}
+	name = string(data)
+	_, err = io.ReadFull(r, buf[1:])
+	if err != nil {
+		return
+	}
+	data = make([]byte, binary.BigEndian.Uint16(buf[1:]))
+	_, err = io.ReadFull(r, data)
+	token = string(data)
+	return
+}
+
+//ReverseAgentInfo is the connected agent of ReverseDialer
+type ReverseAgentInfo struct {
+	Name      string
+	Remote    string
+	Connected time.Time
+	Streams   int
+}
+
+type reverseAgent struct {
+	name      string
+	remote    string
+	connected time.Time
+	session   *MuxSession
+}
+
+//ReverseDialer is an implementation of the Dialer interface for dial by the agents which are connected to it.
+//the agent is authenticated by token and registered by name, the uri like tcp://name/tcp://host:port
+//is forwarded as tcp://host:port to the agent, and the uri like tcp://name?x=1 is forwarded as it is.
+//the token is sent in cleartext and the server is not authenticated by agent, so the listen address should be
+//only reachable from trusted network or be tunneled by tls/ssh, see ReverseAgent.Connect.
+type ReverseDialer struct {
+	ID               string
+	Token            string            //the token shared by all agents
+	Tokens           map[string]string //the token of each agent name, it is preferred to Token
+	Window           uint32            //the receiving window of each stream
+	Keepalive        time.Duration     //the interval of keepalive ping, 0 is disabled
+	KeepaliveTimeout time.Duration     //the timeout of receiving nothing from agent
+	Timeout          time.Duration     //the timeout of agent auth
+	conf             util.Map
+	agents           map[string]*reverseAgent
+	ls               map[net.Listener]chan struct{} //the serving listener to done
+	addresses        map[string]net.Listener        //the listen address to listener which is listened or taken over
+	bootstrapped     bool
+	lck              sync.RWMutex
+	shutdown         uint32
+}
+
+//ReverseDialerSchema is the config schema of ReverseDialer
+var ReverseDialerSchema = NewSchema(
+	&SchemaField{Name: "id", Type: FieldString, Required: true, Description: "the dialer name"},
+	&SchemaField{Name: "listen", Type: FieldString, Description: "the address to accept agents like :9090 or unix:/tmp/reverse.sock"},
+	&SchemaField{Name: "token", Type: FieldString, Description: "the token shared by all agents, it is sent in cleartext and the server is not authenticated, so listen on trusted network only"},
+	&SchemaField{Name: "tokens", Type: FieldMap, Schema: &Schema{Open: true}, Description: "the agent name to token"},
+	&SchemaField{Name: "window", Type: FieldInt, Default: MuxDefaultWindow, Description: "the receiving window of each stream in bytes"},
+	&SchemaField{Name: "keepalive", Type: FieldInt, Default: 30000, Description: "the interval of keepalive ping in millisecond, 0 is disabled"},
+	&SchemaField{Name: "keepalive_timeout", Type: FieldInt, Default: 90000, Description: "the timeout of receiving nothing from agent in millisecond"},
+	&SchemaField{Name: "timeout", Type: FieldInt, Default: 10000, Description: "the timeout of agent auth in millisecond"},
+).Merge(PipeSchema)
+
+//NewReverseDialer will return new ReverseDialer
+func NewReverseDialer() *ReverseDialer {
+	return &ReverseDialer{
+		Tokens:    map[string]string{},
+		Window:    MuxDefaultWindow,
+		Keepalive: 30 * time.Second,
+		Timeout:   10 * time.Second,
+		conf:      util.Map{},
+		agents:    map[string]*reverseAgent{},
+		ls:        map[net.Listener]chan struct{}{},
+		addresses: map[string]net.Listener{},
+		lck:       sync.RWMutex{},
+	}
+}
+
+//Name will return dialer name
+func (r *ReverseDialer) Name() string {
+	return r.ID
+}
+
+//Bootstrap the dialer, it will start accepting agents if listen is set.
+func (r *ReverseDialer) Bootstrap(options util.Map) (err error) {
+	r.ID = options.StrVal("id")
+	if len(r.ID) < 1 {
+		return fmt.Errorf("the dialer id is required")
+	}
+	err = ReverseDialerSchema.Validate("", options, false)
+	if err != nil {
+		return
+	}
+	r.Token = options.StrVal("token")
+	tokens := options.MapVal("tokens")
+	for name := range tokens {
+		r.Tokens[name] = tokens.StrVal(name)
+	}
+	r.Window = uint32(options.IntValV("window", MuxDefaultWindow))
+	r.Keepalive = time.Duration(options.IntValV("keepalive", 30000)) * time.Millisecond
+	r.KeepaliveTimeout = time.Duration(options.IntValV("keepalive_timeout", 90000)) * time.Millisecond
+	r.Timeout = time.Duration(options.IntValV("timeout", 10000)) * time.Millisecond
+	r.conf = options
+	listen := options.StrVal("listen")
+	r.lck.Lock()
+	l := r.addresses[listen]
+	r.lck.Unlock()
+	if len(listen) > 0 && l == nil {
+		l, err = ListenAddress(listen)
+		if err != nil {
+			return
+		}
+		r.lck.Lock()
+		r.addresses[listen] = l
+		r.lck.Unlock()
+	}
+	r.lck.Lock()
+	r.bootstrapped = true
+	taken := []net.Listener{}
+	for _, having := range r.addresses {
+		taken = append(taken, having)
+	}
+	r.lck.Unlock()
+	for _, having := range taken {
+		r.serve(having)
+	}
+	return
+}
+
+//Handover will stop accepting on the listener of address and return it, the listener is not closed by Shutdown after that.
+func (r *ReverseDialer) Handover(address string) net.Listener {
+	r.lck.Lock()
+	l := r.addresses[address]
+	deadline, ok := l.(interface{ SetDeadline(time.Time) error })
+	if !ok {
+		r.lck.Unlock()
+		return nil
+	}
+	delete(r.addresses, address)
+	done, serving := r.ls[l]
+	delete(r.ls, l)
+	r.lck.Unlock()
+	if serving {
+		//break the accepting without closing listener
+		deadline.SetDeadline(time.Now())
+		<-done
+		deadline.SetDeadline(time.Time{})
+	}
+	return l
+}
+
+//Takeover will accept agents on the listener of address, it is used instead of listening if it is called before Bootstrap.
+func (r *ReverseDialer) Takeover(address string, l net.Listener) {
+	r.lck.Lock()
+	r.addresses[address] = l
+	bootstrapped := r.bootstrapped
+	r.lck.Unlock()
+	if bootstrapped {
+		r.serve(l)
+	}
+}
+
+func (r *ReverseDialer) Options() util.Map {
+	return r.conf
+}
+
+//Matched will return whether the uri host is the connected agent or the agent having token.
+func (r *ReverseDialer) Matched(uri string) bool {
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	name := remote.Hostname()
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+	_, connected := r.agents[name]
+	_, having := r.Tokens[name]
+	return connected || having
+}
+
+//Dial one connection by uri
+func (r *ReverseDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	raw, err = r.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext will open one stream by sid on the agent of uri host, the agent dials the target uri by its Pool.
+func (r *ReverseDialer) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	if atomic.LoadUint32(&r.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
+	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	config, err := NewPipeConfig(r.conf, remote.Query())
+	if err != nil {
+		return
+	}
+	name := remote.Hostname()
+	r.lck.RLock()
+	agent := r.agents[name]
+	r.lck.RUnlock()
+	if agent == nil {
+		err = &CodeError{Inner: fmt.Errorf("the agent(%v) is not connected", name), ByteCode: CodeUnreachable}
+		return
+	}
+	stream, err := agent.session.Open(ctx, sid, reverseTarget(uri, remote))
+	if err != nil {
+		return
+	}
+	pipable := NewCopyPipable(stream)
+	pipable.Config = config
+	raw = pipable
+	if pipe != nil {
+		err = raw.Pipe(pipe)
+	}
+	if err != nil {
+		stream.Close()
+	}
+	return
+}
+
+//reverseTarget will return the uri which is dialed by agent, like tcp://name/tcp://host:port to tcp://host:port
+func reverseTarget(uri string, remote *url.URL) string {
+	prefix := remote.Scheme + "://" + remote.Host + "/"
+	if strings.HasPrefix(uri, prefix) {
+		target := strings.TrimPrefix(uri, prefix)
+		if strings.Contains(target, "://") {
+			return target
+		}
+	}
+	return uri
+}
+
+//Serve will accept the agent connection on listener and serve it, it return when listener is closed.
+func (r *ReverseDialer) Serve(l net.Listener) (err error) {
+	done, err := r.register(l)
+	if err == nil {
+		err = r.accept(l, done)
+	}
+	return
+}
+
+//serve will register the listener and accept on it by goroutine, the listener is serving when it returns.
+func (r *ReverseDialer) serve(l net.Listener) {
+	done, err := r.register(l)
+	if err == nil {
+		go r.accept(l, done)
+	}
+}
+
+//register will add the listener to serving, the done is closed after accepting is stopped.
+func (r *ReverseDialer) register(l net.Listener) (done chan struct{}, err error) {
+	r.lck.Lock()
+	if atomic.LoadUint32(&r.shutdown) == 1 {
+		r.lck.Unlock()
+		l.Close()
+		err = ErrShutdown
+		return
+	}
+	if _, serving := r.ls[l]; serving {
+		r.lck.Unlock()
+		err = fmt.Errorf("the listener(%v) is serving", l.Addr())
+		return
+	}
+	done = make(chan struct{})
+	r.ls[l] = done
+	r.lck.Unlock()
+	return
+}
+
+func (r *ReverseDialer) accept(l net.Listener, done chan struct{}) (err error) {
+	defer func() {
+		r.lck.Lock()
+		if r.ls[l] == done {
+			delete(r.ls, l)
+		}
+		r.lck.Unlock()
+		close(done)
+	}()
+	log.D("ReverseDialer(%v) start serve on %v", r.ID, l.Addr())
+	var conn net.Conn
+	for {
+		conn, err = l.Accept()
+		if err != nil {
+			break
+		}
+		go r.ServeConn(conn)
+	}
+	log.D("ReverseDialer(%v) serve on %v is stopped by %v", r.ID, l.Addr(), err)
+	return
+}
+
+//ServeConn will authenticate the agent and register it by name until the connection is closed,
+//the old agent having same name is replaced and shutdown after its streams closed.
+func (r *ReverseDialer) ServeConn(conn net.Conn) (err error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	name, token, err := ReadReverseAuth(conn)
+	if err != nil {
+		WriteHandshakeResponse(conn, &CodeError{Inner: err, ByteCode: CodeBadRequest})
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if atomic.LoadUint32(&r.shutdown) == 1 {
+		err = ErrShutdown
+	} else if !r.authenticate(name, token) {
+		err = &CodeError{Inner: fmt.Errorf("the agent(%v) is not authenticated", name), ByteCode: CodeDenied}
+	}
+	if err != nil {
+		log.W("ReverseDialer(%v) agent %v from %v is refused by %v", r.ID, name, conn.RemoteAddr(), err)
+		WriteHandshakeResponse(conn, err)
+		conn.Close()
+		return
+	}
+	err = WriteHandshakeResponse(conn, nil)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	agent := &reverseAgent{
+		name:      name,
+		remote:    conn.RemoteAddr().String(),
+		connected: time.Now(),
+		session:   NewMuxSession(conn),
+	}
+	agent.session.Server = true
+	agent.session.Window = r.Window
+	agent.session.Keepalive = r.Keepalive
+	agent.session.KeepaliveTimeout = r.KeepaliveTimeout
+	r.lck.Lock()
+	old := r.agents[name]
+	r.agents[name] = agent
+	r.lck.Unlock()
+	if old != nil {
+		go old.session.Shutdown(context.Background())
+	}
+	log.I("ReverseDialer(%v) agent %v is connected from %v", r.ID, name, agent.remote)
+	agent.session.Start()
+	<-agent.session.Done()
+	r.lck.Lock()
+	if r.agents[name] == agent {
+		delete(r.agents, name)
+	}
+	r.lck.Unlock()
+	err = agent.session.Err()
+	log.I("ReverseDialer(%v) agent %v from %v is disconnected by %v", r.ID, name, agent.remote, err)
+	return
+}
+
+func (r *ReverseDialer) authenticate(name, token string) bool {
+	r.lck.RLock()
+	expected, ok := r.Tokens[name]
+	r.lck.RUnlock()
+	if !ok {
+		expected = r.Token
+	}
+	return len(expected) > 0 && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+//Agents will return all connected agents sorted by name.
+func (r *ReverseDialer) Agents() (agents []*ReverseAgentInfo) {
+	r.lck.RLock()
+	defer r.lck.RUnlock()
+	for _, agent := range r.agents {
+		agents = append(agents, &ReverseAgentInfo{
+			Name:      agent.name,
+			Remote:    agent.remote,
+			Connected: agent.connected,
+			Streams:   agent.session.NumStreams(),
+		})
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Name < agents[j].Name
+	})
+	return
+}
+
+//Shutdown will close all listeners and shutdown all agent sessions gracefully until ctx done.
+func (r *ReverseDialer) Shutdown(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapUint32(&r.shutdown, 0, 1) {
+		return
+	}
+	r.lck.Lock()
+	for l := range r.ls {
+		l.Close()
+	}
+	for _, l := range r.addresses {
+		l.Close()
+	}
+	agents := []*reverseAgent{}
+	for _, agent := range r.agents {
+		agents = append(agents, agent)
+	}
+	r.lck.Unlock()
+	for _, agent := range agents {
+		if serr := agent.session.Shutdown(ctx); serr != nil && serr != ErrShutdown && err == nil {
+			err = serr
+		}
+	}
+	return
+}
+
+//Local will return true, the uri host is agent name, the target is dialed by agent.
+func (r *ReverseDialer) Local() bool {
+	return true
+}
+
+func (r *ReverseDialer) String() string {
+	return "ReverseDialer(" + r.ID + ")"
+}
+
+//ReverseAgent is the agent to connect to ReverseDialer, the stream opened by ReverseDialer is dialed by Pool and piped.
+//the agent is reconnected when disconnected until stopped.
+type ReverseAgent struct {
+	Name             string
+	Token            string
+	Address          string        //the ReverseDialer address like host:port or unix:/tmp/reverse.sock
+	Pool             *Pool         //the pool to dial the stream uri
+	Window           uint32        //the receiving window of each stream
+	Keepalive        time.Duration //the interval of keepalive ping, 0 is disabled
+	KeepaliveTimeout time.Duration //the timeout of receiving nothing from server
+	Timeout          time.Duration //the timeout of connecting and dialing
+	Delay            time.Duration //the delay of reconnecting
+	//the func to connect to server, default is dialing tcp or unix socket by Address
+	Connect func(ctx context.Context) (io.ReadWriteCloser, error)
+	//the func is called after connected or connect fail
+	OnConnected func(agent *ReverseAgent, err error)
+	session     *MuxSession
+	lck         sync.Mutex
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+//NewReverseAgent will return new ReverseAgent
+func NewReverseAgent(pool *Pool, address, name, token string) *ReverseAgent {
+	return &ReverseAgent{
+		Name:      name,
+		Token:     token,
+		Address:   address,
+		Pool:      pool,
+		Window:    MuxDefaultWindow,
+		Keepalive: 30 * time.Second,
+		Timeout:   10 * time.Second,
+		Delay:     3 * time.Second,
+		lck:       sync.Mutex{},
+	}
+}
+
+//Start will start the loop of connecting to server.
+func (r *ReverseAgent) Start() {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.loopConnect()
+}
+
+//Session will return the current session, it is nil if not connected.
+func (r *ReverseAgent) Session() (session *MuxSession) {
+	r.lck.Lock()
+	session = r.session
+	r.lck.Unlock()
+	return
+}
+
+func (r *ReverseAgent) loopConnect() {
+	defer close(r.done)
+	for {
+		session, err := r.connect()
+		if r.OnConnected != nil {
+			r.OnConnected(r, err)
+		}
+		if err == nil {
+			log.I("ReverseAgent(%v) is connected to %v", r.Name, r.Address)
+			r.lck.Lock()
+			r.session = session
+			r.lck.Unlock()
+			select {
+			case <-session.Done():
+				log.W("ReverseAgent(%v) is disconnected from %v by %v", r.Name, r.Address, session.Err())
+			case <-r.stop:
+				return
+			}
+			r.lck.Lock()
+			r.session = nil
+			r.lck.Unlock()
+		} else {
+			log.W("ReverseAgent(%v) connect to %v fail with %v", r.Name, r.Address, err)
+		}
+		select {
+		case <-time.After(r.Delay):
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *ReverseAgent) connect() (session *MuxSession, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	defer cancel()
+	connect := r.Connect
+	if connect == nil {
+		connect = r.dialServer
+	}
+	raw, err := connect(ctx)
+	if err != nil {
+		return
+	}
+	stop := watchContext(ctx, raw)
+	err = WriteReverseAuth(raw, r.Name, r.Token)
+	if err == nil {
+		err = ReadHandshakeResponse(raw)
+	}
+	if stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		raw.Close()
+		return
+	}
+	session = NewMuxSession(raw)
+	session.Window = r.Window
+	session.Keepalive = r.Keepalive
+	session.KeepaliveTimeout = r.KeepaliveTimeout
+	session.OnOpen = r.serveStream
+	session.Start()
+	return
+}
+
+func (r *ReverseAgent) serveStream(stream *MuxStream) {
+	serveMuxStream(r.Pool, r.Timeout, stream)
+}
+
+func (r *ReverseAgent) dialServer(ctx context.Context) (raw io.ReadWriteCloser, err error) {
+	raw, err = dialAddress(ctx, r.Address)
+	return
+}
+
+//Stop will stop reconnecting and shutdown the session gracefully until ctx done, the Pool is not shutdown.
+func (r *ReverseAgent) Stop(ctx context.Context) (err error) {
+	r.lck.Lock()
+	if r.stop == nil {
+		r.lck.Unlock()
+		return
+	}
+	select {
+	case <-r.stop:
+		r.lck.Unlock()
+		return
+	default:
+	}
+	close(r.stop)
+	r.lck.Unlock()
+	<-r.done
+	r.lck.Lock()
+	session := r.session
+	r.session = nil
+	r.lck.Unlock()
+	if session != nil {
+		err = session.Shutdown(ctx)
+		if err == ErrShutdown {
+			err = nil
+		}
+	}
+	return
+}
@@ -0,0 +1,449 @@
+package dialer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestReverseAuth(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := WriteReverseAuth(buf, "a1", "t1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	name, token, err := ReadReverseAuth(buf)
+	if err != nil || name != "a1" || token != "t1" {
+		t.Errorf("%v,%v,%v", name, token, err)
+		return
+	}
+	if err = WriteReverseAuth(buf, "", "t1"); err == nil {
+		t.Error(err)
+		return
+	}
+	if err = WriteReverseAuth(buf, "a1", strings.Repeat("x", 0x10000)); err == nil {
+		t.Error(err)
+		return
+	}
+	for _, data := range [][]byte{
+		{},
+		{2, 0, 1, 'x', 0, 0},
+		{1, 0, 0},
+		{1, 0, 2, 'x'},
+		{1, 0, 1, 'x'},
+	} {
+		_, _, err = ReadReverseAuth(bytes.NewBuffer(data))
+		if err == nil {
+			t.Error(data)
+			return
+		}
+	}
+	for uri, target := range map[string]string{
+		"tcp://a1/tcp://host:22":       "tcp://host:22",
+		"tcp://a1/tcp://cmd?exec=bash": "tcp://cmd?exec=bash",
+		"tcp://a1:80/http://web?dir=.": "http://web?dir=.",
+		"tcp://a1/path":                "tcp://a1/path",
+		"tcp://a1?x=1":                 "tcp://a1?x=1",
+	} {
+		remote, _ := url.Parse(uri)
+		if having := reverseTarget(uri, remote); having != target {
+			t.Errorf("%v->%v", uri, having)
+			return
+		}
+	}
+}
+
+func newReverseAgent(address, name, token string) (agent *ReverseAgent, connected chan error, err error) {
+	pool := NewPool()
+	err = pool.Bootstrap(util.Map{"echo": 1})
+	if err != nil {
+		return
+	}
+	connected = make(chan error, 100)
+	agent = NewReverseAgent(pool, address, name, token)
+	agent.Delay = 10 * time.Millisecond
+	agent.OnConnected = func(agent *ReverseAgent, err error) {
+		connected <- err
+	}
+	agent.Start()
+	return
+}
+
+func echoByPool(pool *Pool, sid uint64, uri string) (err error) {
+	conn, err := pool.Dial(sid, uri, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "abc")
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(conn, buf)
+	if err == nil && string(buf) != "abc" {
+		err = fmt.Errorf("echo fail with %v", string(buf))
+	}
+	return
+}
+
+func TestReverseDialer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reverse")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	address := "unix:" + filepath.Join(dir, "reverse.sock")
+	pool := NewPool()
+	err = pool.Bootstrap(util.Map{
+		"dialers": []util.Map{
+			{
+				"type":   "reverse",
+				"id":     "reverse",
+				"listen": address,
+				"token":  "shared",
+				"tokens": util.Map{"a1": "t1"},
+			},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reverse := pool.FindDialer("reverse").(*ReverseDialer)
+	if reverse.String() != "ReverseDialer(reverse)" || reverse.Options() == nil {
+		t.Error("error")
+		return
+	}
+	//not connected
+	if !reverse.Matched("tcp://a1/tcp://echo") || reverse.Matched("tcp://a2/tcp://echo") || reverse.Matched("%x://") {
+		t.Error("error")
+		return
+	}
+	_, err = pool.Dial(1, "tcp://a1/tcp://echo", nil)
+	if ErrorCode(err) != CodeUnreachable {
+		t.Error(err)
+		return
+	}
+	//connect by agent token
+	a1, connected1, err := newReverseAgent(address, "a1", "t1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-connected1; err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 100 && len(reverse.Agents()) < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = echoByPool(pool, 1, "tcp://a1/tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//connect by shared token
+	a2, connected2, err := newReverseAgent(address, "a2", "shared")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-connected2; err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 100 && len(reverse.Agents()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	agents := reverse.Agents()
+	if len(agents) != 2 || agents[0].Name != "a1" || agents[1].Name != "a2" {
+		t.Error(agents)
+		return
+	}
+	err = echoByPool(pool, 2, "tcp://a2/tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//dial fail on agent
+	_, err = pool.Dial(3, "tcp://a2/xx://none", nil)
+	if ErrorCode(err) != CodeUnsupported {
+		t.Error(err)
+		return
+	}
+	//reconnect after disconnected
+	old := a2.Session()
+	reverse.lck.RLock()
+	reverse.agents["a2"].session.Close()
+	reverse.lck.RUnlock()
+	if err = <-connected2; err != nil {
+		t.Error(err)
+		return
+	}
+	if a2.Session() == old {
+		t.Error("not reconnected")
+		return
+	}
+	for i := 0; i < 100 && len(reverse.Agents()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = echoByPool(pool, 4, "tcp://a2/tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//replaced by same name
+	a3, connected3, err := newReverseAgent(address, "a2", "shared")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-connected3; err != nil {
+		t.Error(err)
+		return
+	}
+	a2.Stop(context.Background())
+	a2.Stop(context.Background())
+	//the stopped agent may replace the new one before stopped, wait the new one reconnected.
+	for i := 0; i < 100; i++ {
+		if err = echoByPool(pool, 5, "tcp://a2/tcp://echo"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	a3.Stop(context.Background())
+	//auth fail
+	a4, connected4, err := newReverseAgent(address, "a1", "shared")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-connected4; ErrorCode(err) != CodeDenied {
+		t.Error(err)
+		return
+	}
+	a4.Stop(context.Background())
+	a4, connected4, err = newReverseAgent(address, "a4", "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-connected4; ErrorCode(err) != CodeDenied {
+		t.Error(err)
+		return
+	}
+	a4.Stop(context.Background())
+	//bad request
+	network, addr := splitAddress(address)
+	raw, err := net.Dial(network, addr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	raw.Write([]byte{9, 0, 1, 'x', 0, 0})
+	err = ReadHandshakeResponse(raw)
+	if ErrorCode(err) != CodeBadRequest {
+		t.Error(err)
+		return
+	}
+	raw.Close()
+	//shutdown
+	err = pool.Shutdown(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = <-connected1; err == nil {
+		t.Error(err)
+		return
+	}
+	a1.Stop(context.Background())
+	_, err = reverse.Dial(6, "tcp://a1/tcp://echo", nil)
+	if err != ErrShutdown {
+		t.Error(err)
+		return
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if reverse.Serve(l) != ErrShutdown {
+		t.Error("error")
+		return
+	}
+}
+
+func TestReverseDialerError(t *testing.T) {
+	reverse := NewReverseDialer()
+	for _, options := range []util.Map{
+		{},
+		{"id": "reverse", "tokens": "x"},
+		{"id": "reverse", "listen": "127.0.0.1:x"},
+	} {
+		if err := reverse.Bootstrap(options); err == nil {
+			t.Error(options)
+			return
+		}
+	}
+	err := reverse.Bootstrap(util.Map{"id": "reverse"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = reverse.Dial(1, "%x://", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = reverse.Dial(1, "tcp://a1?idle_timeout=x", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	//custom connect and agent stop before start
+	agent := NewReverseAgent(NewPool(), "127.0.0.1:1", "a1", "t1")
+	agent.Stop(context.Background())
+	agent.Delay = 10 * time.Millisecond
+	connected := make(chan error, 100)
+	agent.OnConnected = func(agent *ReverseAgent, err error) {
+		connected <- err
+	}
+	agent.Connect = func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return nil, fmt.Errorf("testing")
+	}
+	agent.Start()
+	agent.Start()
+	if err = <-connected; err == nil {
+		t.Error(err)
+		return
+	}
+	agent.Stop(context.Background())
+	if agent.Session() != nil {
+		t.Error("error")
+		return
+	}
+}
+
+func TestReverseDialerReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reverse")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	address := "unix:" + filepath.Join(dir, "reverse.sock")
+	options := func(token string) util.Map {
+		return util.Map{
+			"dialers": []util.Map{
+				{"type": "reverse", "id": "reverse", "listen": address, "token": token},
+			},
+		}
+	}
+	connectAgent := func(name, token string) (agent *ReverseAgent, err error) {
+		agent, connected, err := newReverseAgent(address, name, token)
+		if err == nil {
+			err = <-connected
+		}
+		return
+	}
+	pool := NewPool()
+	err = pool.Bootstrap(options("t1"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer pool.Shutdown(context.Background())
+	a1, err := connectAgent("a1", "t1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer a1.Stop(context.Background())
+	old := pool.FindDialer("reverse").(*ReverseDialer)
+	for i := 0; i < 100 && len(old.Agents()) < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn, err := pool.Dial(1, "tcp://a1/tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//the listener is handed over to new dialer
+	err = pool.Reload(options("t2"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if draining := pool.Draining(); len(draining) != 1 || draining[0] != "reverse" {
+		t.Error(draining)
+		return
+	}
+	reverse := pool.FindDialer("reverse").(*ReverseDialer)
+	if reverse == old {
+		t.Error("not reloaded")
+		return
+	}
+	a2, err := connectAgent("a2", "t2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer a2.Stop(context.Background())
+	for i := 0; i < 100 && len(reverse.Agents()) < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = echoByPool(pool, 2, "tcp://a2/tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//the listener is not closed by old dialer after drained
+	conn.Close()
+	for i := 0; i < 100 && len(pool.Draining()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(pool.Draining()) > 0 {
+		t.Error("not drained")
+		return
+	}
+	a3, err := connectAgent("a3", "t2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer a3.Stop(context.Background())
+	//the listener is given back when reload fail
+	failed := options("t3")
+	failed["routes"] = []util.Map{{"name": "r1", "dialer": "none", "host": "*"}}
+	err = pool.Reload(failed)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	if pool.FindDialer("reverse") != reverse {
+		t.Error("reloaded")
+		return
+	}
+	a4, err := connectAgent("a4", "t2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer a4.Stop(context.Background())
+}
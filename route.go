@@ -0,0 +1,210 @@
+package dialer
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Centny/gwf/util"
+)
+
+//Route is the rule to route uri to dialer by name.
+type Route struct {
+	Name     string   //the route name
+	Dialer   string   //the dialer name
+	Scheme   string   //the scheme glob, empty is matched all
+	Host     string   //the host glob without port, empty is matched all
+	Port     string   //the port or port range like 1000-2000, empty is matched all
+	Query    []string //the query keys which must be exists
+	Priority int      //the route having bigger priority is matched first
+	portMin  int64
+	portMax  int64
+}
+
+//RouteSchema is the config schema of Route
+var RouteSchema = NewSchema(
+	&SchemaField{Name: "name", Type: FieldString, Description: "the route name"},
+	&SchemaField{Name: "dialer", Type: FieldString, Required: true, Description: "the dialer name"},
+	&SchemaField{Name: "scheme", Type: FieldString, Description: "the scheme glob"},
+	&SchemaField{Name: "host", Type: FieldString, Description: "the host glob without port"},
+	&SchemaField{Name: "port", Type: FieldString, Description: "the port or port range like 1000-2000"},
+	&SchemaField{Name: "query", Type: FieldStringArray, Description: "the query keys which must be exists"},
+	&SchemaField{Name: "priority", Type: FieldInt, Default: 0, Description: "the route having bigger priority is matched first"},
+)
+
+//NewRoute will return new Route by config.
+func NewRoute(option util.Map) (route *Route, err error) {
+	route = &Route{
+		Name:     option.StrVal("name"),
+		Dialer:   option.StrVal("dialer"),
+		Scheme:   option.StrVal("scheme"),
+		Host:     option.StrVal("host"),
+		Port:     option.StrVal("port"),
+		Query:    option.AryStrVal("query"),
+		Priority: int(option.IntValV("priority", 0)),
+	}
+	err = route.parse()
+	return
+}
+
+func (r *Route) parse() (err error) {
+	if len(r.Dialer) < 1 {
+		err = fmt.Errorf("the route dialer is required")
+		return
+	}
+	r.portMin, r.portMax, err = parsePortRange(r.Port)
+	if err != nil {
+		err = fmt.Errorf("the route port(%v) is invalid", r.Port)
+	}
+	return
+}
+
+//parsePortRange will parse the port or port range like 1000-2000, empty is all port.
+func parsePortRange(port string) (min, max int64, err error) {
+	min, max = 0, 65535
+	if len(port) < 1 {
+		return
+	}
+	parts := strings.SplitN(port, "-", 2)
+	min, err = strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+	if err == nil && len(parts) > 1 {
+		max, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 32)
+	} else {
+		max = min
+	}
+	if err == nil && min > max {
+		err = fmt.Errorf("the port min(%v) is greater than max(%v)", min, max)
+	}
+	return
+}
+
+//Match will return whether the target uri is matched by route.
+func (r *Route) Match(target *url.URL) bool {
+	if len(r.Scheme) > 0 && !matchGlob(r.Scheme, target.Scheme) {
+		return false
+	}
+	if len(r.Host) > 0 && !matchGlob(strings.ToLower(r.Host), uriHostname(target)) {
+		return false
+	}
+	if len(r.Port) > 0 {
+		port := uriPort(target)
+		if port < r.portMin || port > r.portMax {
+			return false
+		}
+	}
+	if len(r.Query) > 0 {
+		query := target.Query()
+		for _, key := range r.Query {
+			if _, ok := query[key]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *Route) String() string {
+	return fmt.Sprintf("%v->%v", r.Name, r.Dialer)
+}
+
+//uriPort will return the uri port or the default port by scheme, it return -1 when port not found.
+func uriPort(target *url.URL) int64 {
+	port := target.Port()
+	if len(port) > 0 {
+		val, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			return -1
+		}
+		return val
+	}
+	switch target.Scheme {
+	case "http":
+		return 80
+	case "https":
+		return 443
+	}
+	return -1
+}
+
+//RouteError is the error of uri is not matched by any route
+type RouteError struct {
+	URI    string
+	Routes []*Route
+}
+
+func (r *RouteError) Error() string {
+	names := []string{}
+	for _, route := range r.Routes {
+		names = append(names, route.String())
+	}
+	return fmt.Sprintf("uri(%v) is not matched by routes(%v) and default route is not set", r.URI, strings.Join(names, ","))
+}
+
+//RouteTable is the route list sorted by priority to route uri to dialer name.
+type RouteTable struct {
+	Routes  []*Route
+	Default string
+}
+
+//NewRouteTable will return new RouteTable
+func NewRouteTable() *RouteTable {
+	return &RouteTable{}
+}
+
+//AddRoute will add the route to table and sort by priority
+func (r *RouteTable) AddRoute(routes ...*Route) (err error) {
+	for _, route := range routes {
+		err = route.parse()
+		if err != nil {
+			return
+		}
+		if len(route.Name) < 1 {
+			route.Name = fmt.Sprintf("route%v", len(r.Routes))
+		}
+		r.Routes = append(r.Routes, route)
+	}
+	sort.Stable(r)
+	return
+}
+
+//Match will return the dialer names of matched route by priority order,
+//it will return default dialer if not route matched.
+func (r *RouteTable) Match(uri string) (names []string, err error) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	having := map[string]bool{}
+	for _, route := range r.Routes {
+		if route.Match(target) && !having[route.Dialer] {
+			names = append(names, route.Dialer)
+			having[route.Dialer] = true
+		}
+	}
+	if len(names) > 0 {
+		return
+	}
+	if len(r.Default) > 0 {
+		names = append(names, r.Default)
+		return
+	}
+	err = &RouteError{URI: uri, Routes: r.Routes}
+	return
+}
+
+//Len is sort.Interface
+func (r *RouteTable) Len() int {
+	return len(r.Routes)
+}
+
+//Less is sort.Interface
+func (r *RouteTable) Less(i, j int) bool {
+	return r.Routes[i].Priority > r.Routes[j].Priority
+}
+
+//Swap is sort.Interface
+func (r *RouteTable) Swap(i, j int) {
+	r.Routes[i], r.Routes[j] = r.Routes[j], r.Routes[i]
+}
@@ -0,0 +1,159 @@
+package dialer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestRouteTable(t *testing.T) {
+	routes := NewRouteTable()
+	for _, option := range []util.Map{
+		{"dialer": "tcp", "scheme": "tcp", "port": "1-1024"},
+		{"dialer": "socks", "host": "*.Example.com", "priority": 10},
+		{"dialer": "cmd", "scheme": "tcp", "host": "cmd", "query": []string{"exec"}, "priority": 20},
+		{"name": "ssh", "dialer": "ssh", "port": "22", "priority": 10},
+	} {
+		route, err := NewRoute(option)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		routes.AddRoute(route)
+	}
+	cases := map[string]string{
+		"tcp://cmd?exec=bash":     "[cmd]",
+		"tcp://a.example.com:22":  "[socks ssh tcp]",
+		"http://a.example.com":    "[socks]",
+		"tcp://127.0.0.1:80":      "[tcp]",
+		"tcp://127.0.0.1:22":      "[ssh tcp]",
+		"https://www.example.com": "[socks]",
+		"http://A.Example.COM.":   "[socks]",
+	}
+	for uri, expect := range cases {
+		names, err := routes.Match(uri)
+		if err != nil || fmt.Sprintf("%v", names) != expect {
+			t.Errorf("%v->%v,%v", uri, names, err)
+			return
+		}
+	}
+	//not matched
+	_, err := routes.Match("tcp://cmd")
+	if rerr, ok := err.(*RouteError); !ok || !strings.Contains(rerr.Error(), "ssh->ssh") {
+		t.Error(err)
+		return
+	}
+	fmt.Println(err)
+	//default
+	routes.Default = "echo"
+	names, err := routes.Match("tcp://cmd")
+	if err != nil || fmt.Sprintf("%v", names) != "[echo]" {
+		t.Error(err)
+		return
+	}
+	//
+	//test error
+	_, err = routes.Match("%X")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	for _, option := range []util.Map{
+		{},
+		{"dialer": "tcp", "port": "x"},
+		{"dialer": "tcp", "port": "100-x"},
+		{"dialer": "tcp", "port": "100-10"},
+	} {
+		_, err = NewRoute(option)
+		if err == nil {
+			t.Error(option)
+			return
+		}
+	}
+	//glob
+	for pattern, value := range map[string]string{
+		"":           "",
+		"*":          "",
+		"a*b?c":      "axxbyc",
+		"*.a.com":    "x.y.a.com",
+		"a**":        "a",
+		"?":          "a",
+		"*a*b":       "xxaxxb",
+		"a*":         "a*",
+		"x*y*z*abc*": "xyzzzzabcd",
+	} {
+		if !matchGlob(pattern, value) {
+			t.Errorf("%v not matched %v", pattern, value)
+			return
+		}
+	}
+	for pattern, value := range map[string]string{
+		"":                             "a",
+		"a":                            "",
+		"?":                            "",
+		"a*b":                          "axxbc",
+		"*.com":                        "a.co",
+		strings.Repeat("*a", 30) + "b": strings.Repeat("a", 100),
+	} {
+		if matchGlob(pattern, value) {
+			t.Errorf("%v matched %v", pattern, value)
+			return
+		}
+	}
+	err = routes.AddRoute(&Route{})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPoolRoutes(t *testing.T) {
+	pool := NewPool()
+	err := pool.Bootstrap(util.Map{
+		"standard": 1,
+		"routes": []util.Map{
+			{"dialer": "echo", "scheme": "tcp", "host": "cmd", "priority": 10},
+			{"dialer": "cmd", "scheme": "tcp", "host": "cmd", "query": []string{"exec"}, "priority": 20},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := pool.Dial(10, "tcp://cmd", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := conn.(*SessionConn).Conn.(*EchoReadWriteCloser); !ok {
+		t.Error("error")
+		return
+	}
+	conn.Close()
+	_, err = pool.Dial(10, "tcp://echo", nil)
+	if _, ok := err.(*RouteError); !ok {
+		t.Error(err)
+		return
+	}
+	//
+	//test error
+	for _, option := range []util.Map{
+		{"routes": []util.Map{{"dialer": "none"}}},
+		{"routes": []util.Map{{"dialer": "echo", "port": "x"}}},
+		{"default_route": "none"},
+	} {
+		err = NewPool().Bootstrap(option)
+		if err == nil {
+			t.Error(option)
+			return
+		}
+	}
+	pool.Routes.Default = "none"
+	_, err = pool.Dial(10, "tcp://echo", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,309 @@
+package dialer
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Centny/gwf/util"
+)
+
+//the field type of schema
+const (
+	FieldString      = "string"   //the string, the number is also accepted
+	FieldInt         = "int"      //the integer, the integer string is also accepted
+	FieldStringArray = "[]string" //the string array
+	FieldIntArray    = "[]int"    //the integer array
+	FieldMap         = "map"      //the map, it is validated by field schema if set
+	FieldMapArray    = "[]map"    //the map array, each one is validated by field schema if set
+	FieldDialers     = "[]dialer" //the dialer config array, each one is validated by schema of dialer type
+	FieldAny         = "any"      //any value
+)
+
+//SchemaField is the declaration of one config key.
+type SchemaField struct {
+	Name        string
+	Type        string
+	Required    bool
+	Default     interface{} //the default value when key is not exists, it is only for document
+	Description string
+	Schema      *Schema //the schema of map/[]map, or the extra fields of each dialer on []dialer
+}
+
+//Schema is the declaration of config keys.
+type Schema struct {
+	Fields []*SchemaField
+	Open   bool //whether unknown keys is allowed even if in strict mode, like the environment map
+}
+
+//NewSchema will return new Schema by fields
+func NewSchema(fields ...*SchemaField) *Schema {
+	return &Schema{Fields: fields}
+}
+
+//Field will return the field by name, it return nil if not found.
+func (s *Schema) Field(name string) *SchemaField {
+	for _, field := range s.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+//Merge will return new schema having fields of all schemas, the field in latter schema is ignored when it is exists.
+func (s *Schema) Merge(others ...*Schema) *Schema {
+	merged := &Schema{Open: s.Open}
+	merged.Fields = append(merged.Fields, s.Fields...)
+	for _, other := range others {
+		if other == nil {
+			continue
+		}
+		merged.Open = merged.Open || other.Open
+		for _, field := range other.Fields {
+			if merged.Field(field.Name) == nil {
+				merged.Fields = append(merged.Fields, field)
+			}
+		}
+	}
+	return merged
+}
+
+//Validate will validate the options by schema, the unknown keys is rejected in strict mode,
+//it return *SchemaError having all fail fields.
+func (s *Schema) Validate(path string, options util.Map, strict bool) error {
+	serr := &SchemaError{}
+	s.validate(serr, path, options, strict)
+	return serr.Result()
+}
+
+func (s *Schema) validate(serr *SchemaError, path string, options util.Map, strict bool) {
+	for _, field := range s.Fields {
+		val, ok := options[field.Name]
+		if !ok || val == nil {
+			if field.Required {
+				serr.Add(joinPath(path, field.Name), "is required")
+			}
+			continue
+		}
+		field.validate(serr, joinPath(path, field.Name), val, strict)
+	}
+	if !strict || s.Open {
+		return
+	}
+	keys := []string{}
+	for key := range options {
+		if s.Field(key) == nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		serr.Add(joinPath(path, key), "is unknown key")
+	}
+}
+
+func (s *SchemaField) validate(serr *SchemaError, path string, val interface{}, strict bool) {
+	switch s.Type {
+	case FieldString:
+		if !isString(val) && !isNumber(val) {
+			serr.Add(path, fmt.Sprintf("must be %v, but %v", s.Type, typeName(val)))
+		}
+	case FieldInt:
+		if !isInt(val) {
+			serr.Add(path, fmt.Sprintf("must be %v, but %v", s.Type, typeName(val)))
+		}
+	case FieldStringArray, FieldIntArray:
+		items, ok := toArray(val)
+		if !ok {
+			serr.Add(path, fmt.Sprintf("must be %v, but %v", s.Type, typeName(val)))
+			return
+		}
+		for index, item := range items {
+			if (s.Type == FieldStringArray && !isString(item)) || (s.Type == FieldIntArray && !isInt(item)) {
+				serr.Add(fmt.Sprintf("%v[%v]", path, index), fmt.Sprintf("must be %v, but %v", s.Type[2:], typeName(item)))
+			}
+		}
+	case FieldMap:
+		option, ok := toMap(val)
+		if !ok {
+			serr.Add(path, fmt.Sprintf("must be %v, but %v", s.Type, typeName(val)))
+			return
+		}
+		if s.Schema != nil {
+			s.Schema.validate(serr, path, option, strict)
+		}
+	case FieldMapArray, FieldDialers:
+		items, ok := toArray(val)
+		if !ok {
+			serr.Add(path, fmt.Sprintf("must be %v, but %v", s.Type, typeName(val)))
+			return
+		}
+		for index, item := range items {
+			ipath := fmt.Sprintf("%v[%v]", path, index)
+			option, ok := toMap(item)
+			if !ok {
+				serr.Add(ipath, fmt.Sprintf("must be map, but %v", typeName(item)))
+				continue
+			}
+			if s.Type == FieldDialers {
+				validateDialerOptions(serr, ipath, option, s.Schema, strict)
+			} else if s.Schema != nil {
+				s.Schema.validate(serr, ipath, option, strict)
+			}
+		}
+	}
+}
+
+//DialerCommonSchema is the schema of keys which is used by Pool for all dialers.
+var DialerCommonSchema = NewSchema(
+	&SchemaField{Name: "type", Type: FieldString, Required: true, Description: "the registered dialer type"},
+	&SchemaField{Name: "total_rate_up", Type: FieldInt, Default: 0, Description: "the total bytes per second from local to remote of all sessions"},
+	&SchemaField{Name: "total_rate_down", Type: FieldInt, Default: 0, Description: "the total bytes per second from remote to local of all sessions"},
+)
+
+//PipeSchema is the schema of keys which is used by NewPipeConfig.
+var PipeSchema = NewSchema(
+	&SchemaField{Name: "half_close", Type: FieldInt, Default: 1, Description: "whether propagate close write"},
+	&SchemaField{Name: "idle_timeout", Type: FieldInt, Default: 0, Description: "the idle timeout in millisecond"},
+	&SchemaField{Name: "max_lifetime", Type: FieldInt, Default: 0, Description: "the max lifetime in millisecond"},
+	&SchemaField{Name: "rate_up", Type: FieldInt, Default: 0, Description: "the bytes per second from local to remote"},
+	&SchemaField{Name: "rate_down", Type: FieldInt, Default: 0, Description: "the bytes per second from remote to local"},
+)
+
+//ValidateDialerOptions will validate the dialer options by schema of dialer type, the unknown keys is rejected in strict mode,
+//the unknown keys is not checked if the dialer type is not having schema.
+func ValidateDialerOptions(path string, options util.Map, strict bool) error {
+	serr := &SchemaError{}
+	validateDialerOptions(serr, path, options, nil, strict)
+	return serr.Result()
+}
+
+func validateDialerOptions(serr *SchemaError, path string, options util.Map, extra *Schema, strict bool) {
+	schema := DialerCommonSchema.Merge(extra)
+	dtype := options.StrVal("type")
+	having := LookupDialerType(dtype)
+	if len(dtype) > 0 && having == nil {
+		serr.Add(joinPath(path, "type"), fmt.Sprintf("type(%v) is not registered", dtype))
+	}
+	if having != nil && having.Schema != nil {
+		schema = schema.Merge(having.Schema)
+	} else {
+		//the keys of dialer type without schema is not checked
+		schema.Open = true
+	}
+	schema.validate(serr, path, options, strict)
+}
+
+//FieldError is the validate fail info of one config path.
+type FieldError struct {
+	Path    string
+	Message string
+	Source  string //the source file and line of config path, it is set by Config.Annotate
+}
+
+func (f *FieldError) Error() string {
+	msg := f.Message
+	if len(f.Path) > 0 {
+		msg = f.Path + " " + msg
+	}
+	if len(f.Source) > 0 {
+		msg = f.Source + ": " + msg
+	}
+	return msg
+}
+
+//SchemaError is the aggregated error of all fail fields.
+type SchemaError struct {
+	Errors []*FieldError
+}
+
+//Add will append the fail field.
+func (s *SchemaError) Add(path, message string) {
+	s.Errors = append(s.Errors, &FieldError{Path: path, Message: message})
+}
+
+//Result will return nil if not fail field, or return itself.
+func (s *SchemaError) Result() error {
+	if len(s.Errors) < 1 {
+		return nil
+	}
+	return s
+}
+
+func (s *SchemaError) Error() string {
+	msgs := []string{}
+	for _, err := range s.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("config is invalid: %v", strings.Join(msgs, "; "))
+}
+
+func joinPath(path, key string) string {
+	if len(path) < 1 {
+		return key
+	}
+	return path + "." + key
+}
+
+func typeName(val interface{}) string {
+	if val == nil {
+		return "null"
+	}
+	return reflect.TypeOf(val).String()
+}
+
+func isString(val interface{}) bool {
+	_, ok := val.(string)
+	return ok
+}
+
+func isNumber(val interface{}) bool {
+	switch reflect.ValueOf(val).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isInt(val interface{}) bool {
+	value := reflect.ValueOf(val)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == math.Trunc(value.Float())
+	case reflect.String:
+		_, err := strconv.ParseInt(value.String(), 10, 64)
+		return err == nil
+	}
+	return false
+}
+
+func toArray(val interface{}) (items []interface{}, ok bool) {
+	value := reflect.ValueOf(val)
+	if value.Kind() != reflect.Slice {
+		return
+	}
+	for i := 0; i < value.Len(); i++ {
+		items = append(items, value.Index(i).Interface())
+	}
+	ok = true
+	return
+}
+
+func toMap(val interface{}) (option util.Map, ok bool) {
+	switch v := val.(type) {
+	case util.Map:
+		option, ok = v, true
+	case map[string]interface{}:
+		option, ok = util.Map(v), true
+	}
+	return
+}
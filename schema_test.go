@@ -0,0 +1,203 @@
+package dialer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestSchema(t *testing.T) {
+	schema := NewSchema(
+		&SchemaField{Name: "s", Type: FieldString, Required: true},
+		&SchemaField{Name: "i", Type: FieldInt},
+		&SchemaField{Name: "as", Type: FieldStringArray},
+		&SchemaField{Name: "ai", Type: FieldIntArray},
+		&SchemaField{Name: "m", Type: FieldMap, Schema: NewSchema(
+			&SchemaField{Name: "x", Type: FieldInt},
+		)},
+		&SchemaField{Name: "am", Type: FieldMapArray, Schema: NewSchema(
+			&SchemaField{Name: "x", Type: FieldInt, Required: true},
+		)},
+		&SchemaField{Name: "any", Type: FieldAny},
+	)
+	err := schema.Validate("", util.Map{
+		"s":   "abc",
+		"i":   float64(10),
+		"as":  []interface{}{"a", "b"},
+		"ai":  []interface{}{float64(1), 2, "3"},
+		"m":   map[string]interface{}{"x": 1},
+		"am":  []util.Map{{"x": 1}},
+		"any": []int{},
+	}, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = schema.Validate("", util.Map{"s": 1}, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = schema.Validate("root", util.Map{
+		"i":   1.5,
+		"as":  []interface{}{"a", 1},
+		"ai":  10,
+		"m":   util.Map{"x": "y", "z": 1},
+		"am":  []interface{}{util.Map{}, 1},
+		"xxx": 1,
+	}, true)
+	serr, ok := err.(*SchemaError)
+	if !ok {
+		t.Error(err)
+		return
+	}
+	paths := []string{}
+	for _, ferr := range serr.Errors {
+		paths = append(paths, ferr.Path)
+	}
+	if strings.Join(paths, ",") != "root.s,root.i,root.as[1],root.ai,root.m.x,root.m.z,root.am[0].x,root.am[1],root.xxx" {
+		t.Error(err)
+		return
+	}
+	fmt.Println(err)
+	//not strict
+	err = schema.Validate("", util.Map{"s": "a", "xxx": 1}, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if schema.Field("s") == nil || schema.Field("none") != nil {
+		t.Error("error")
+		return
+	}
+	if (&FieldError{Message: "xx"}).Error() != "xx" {
+		t.Error("error")
+		return
+	}
+}
+
+func TestDialerSchema(t *testing.T) {
+	for _, dtype := range ListDialerTypes() {
+		if dtype.Schema == nil && dtype.Name != "testing" {
+			t.Error(dtype.Name)
+			return
+		}
+	}
+	for _, c := range []struct {
+		Options util.Map
+		Paths   string
+	}{
+		{util.Map{"type": "cmd", "reuse_dealy": 100}, "reuse_dealy"},
+		{util.Map{"type": "tcp", "matcher": ".*"}, "matcher"},
+		{util.Map{"type": "xx"}, "type"},
+		{util.Map{}, "type"},
+		{util.Map{"type": "echo", "idle_timeout": "x"}, "idle_timeout"},
+		{util.Map{"type": "balance", "id": "b0", "dialers": []util.Map{{"type": "echo", "limit": 10}}}, "dialers[0].limit"},
+		{util.Map{"type": "balance", "id": "b0", "policy": []util.Map{{"matcher": ".*", "limit": 10}}}, "policy[0].limit"},
+	} {
+		err := ValidateDialerOptions("", c.Options, true)
+		serr, ok := err.(*SchemaError)
+		if !ok || len(serr.Errors) != 1 || serr.Errors[0].Path != c.Paths {
+			t.Errorf("%v->%v", c.Options, err)
+			return
+		}
+	}
+	err := ValidateDialerOptions("", util.Map{"type": "balance", "id": "b0", "dialers": []util.Map{{"type": "echo", "limit": []int{1, 2}, "fail_remove": 1}}}, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//bootstrap
+	for _, dialer := range []Dialer{NewCmdDialer(), NewEchoDialer(), NewTCPDialer(), NewWebDialer(), NewReplayDialer()} {
+		if dialer.Bootstrap(util.Map{"idle_timeout": "x"}) == nil {
+			t.Error(dialer)
+			return
+		}
+	}
+	if NewSocksProxyDialer().Bootstrap(util.Map{"id": "s0", "matcher": "["}) == nil {
+		t.Error("error")
+		return
+	}
+	if NewSocksProxyDialer().Bootstrap(util.Map{"id": "s0", "address": []string{}}) == nil {
+		t.Error("error")
+		return
+	}
+	if NewBalancedDialer().Bootstrap(util.Map{"id": "b0", "matcher": "["}) == nil {
+		t.Error("error")
+		return
+	}
+	if RegisterDialerSchema("none", nil) == nil {
+		t.Error("error")
+		return
+	}
+}
+
+func TestPoolStrict(t *testing.T) {
+	options := util.Map{
+		"echo":  1,
+		"echoo": 1,
+		"dialers": []util.Map{
+			{
+				"type":        "cmd",
+				"reuse_dealy": 100,
+			},
+			{
+				"id":   "b0",
+				"type": "balance",
+				"dialers": []util.Map{
+					{
+						"type":    "tcp",
+						"matcher": ".*",
+						"limit":   10,
+					},
+				},
+			},
+		},
+		"routes": []util.Map{
+			{
+				"dialer": "echo",
+				"port":   80,
+			},
+		},
+		"acl": util.Map{
+			"rules": []util.Map{
+				{
+					"action": "deny",
+					"cidr":   "loopback",
+				},
+			},
+		},
+	}
+	err := NewPool().Bootstrap(options)
+	serr, ok := err.(*SchemaError)
+	if !ok {
+		t.Error(err)
+		return
+	}
+	if len(serr.Errors) != 2 || serr.Errors[0].Path != "dialers[1].dialers[0].limit" || serr.Errors[1].Path != "acl.rules[0].cidr" {
+		t.Error(err)
+		return
+	}
+	options["strict"] = 1
+	err = NewPool().Bootstrap(options)
+	serr, ok = err.(*SchemaError)
+	if !ok || len(serr.Errors) != 5 {
+		t.Error(err)
+		return
+	}
+	fmt.Println(err)
+	pool := NewPool()
+	pool.Strict = true
+	err = pool.Bootstrap(util.Map{"echoo": 1})
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	err = pool.Bootstrap(util.Map{"echo": 1, "dialers": []util.Map{{"type": "tcp", "bind": "127.0.0.1:0"}}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,296 @@
+package dialer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Session is the live session which is dialed by Pool
+type Session struct {
+	SID      uint64
+	URI      string
+	Dialer   string
+	Begin    time.Time
+	Stats    *ConnStats
+	Limit    *RateLimit //the rate limit of session, it is nil if the connection is not supported rate limit.
+	ownStats bool
+	shared   []*RateLimit //the rate limit shared with other sessions, like the total limit of dialer or pool.
+	limitLck sync.RWMutex
+	conn     Conn
+	dialer   Dialer
+	pipe     io.ReadWriteCloser
+	pipeLck  sync.RWMutex
+	pool     *Pool
+	closed   uint32
+}
+
+//Kill will close the session connection and the piped connection.
+func (s *Session) Kill() (err error) {
+	if s.Stats != nil {
+		s.Stats.SetReason("killed")
+	}
+	if s.conn != nil {
+		err = s.conn.Close()
+	}
+	if pipe := s.piped(); pipe != nil {
+		pipe.Close()
+	}
+	s.done()
+	return
+}
+
+//SetRate will change the up and down bytes per second of session on runtime, zero is not limited.
+func (s *Session) SetRate(up, down int64) (err error) {
+	if s.Limit == nil {
+		err = fmt.Errorf("%v is not supported rate limit", s)
+		return
+	}
+	s.Limit.SetRate(up, down)
+	return
+}
+
+func (s *Session) share(limits ...*RateLimit) {
+	s.limitLck.Lock()
+	for _, limit := range limits {
+		if limit != nil {
+			s.shared = append(s.shared, limit)
+		}
+	}
+	s.limitLck.Unlock()
+}
+
+func (s *Session) limiters() (up, down rateLimiters) {
+	s.limitLck.RLock()
+	for _, limit := range s.shared {
+		up, down = up.append(limit.Up), down.append(limit.Down)
+	}
+	s.limitLck.RUnlock()
+	return
+}
+
+//piped will return the connection which is piped to session connection, it is nil if not piped.
+func (s *Session) piped() (pipe io.ReadWriteCloser) {
+	s.pipeLck.RLock()
+	pipe = s.pipe
+	s.pipeLck.RUnlock()
+	return
+}
+
+func (s *Session) setPipe(pipe io.ReadWriteCloser) {
+	s.pipeLck.Lock()
+	s.pipe = pipe
+	s.pipeLck.Unlock()
+}
+
+func (s *Session) done() {
+	if atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
+		if s.Stats != nil && (s.ownStats || s.piped() == nil) {
+			//the stats of piped connection is done by pipe
+			s.Stats.Done("closed")
+		}
+		s.pool.removeSession(s)
+	}
+}
+
+func (s *Session) String() string {
+	return fmt.Sprintf("Session(%v,%v,%v)", s.SID, s.Dialer, s.URI)
+}
+
+//SessionConn is an implementation of the Conn interface to remove session from Pool when closed.
+type SessionConn struct {
+	Conn
+	Session *Session
+}
+
+//Pipe the raw connection and remove session when raw connection closed
+func (s *SessionConn) Pipe(raw io.ReadWriteCloser) (err error) {
+	//the session is published before piping, so the pipe is guarded by lock
+	s.Session.setPipe(raw)
+	err = s.Conn.Pipe(&sessionRWC{ReadWriteCloser: raw, session: s.Session})
+	return
+}
+
+//Stats will return the accounting of session
+func (s *SessionConn) Stats() *ConnStats {
+	return s.Session.Stats
+}
+
+//PipeConfig will return the config of piping if the connection is supported.
+func (s *SessionConn) PipeConfig() *PipeConfig {
+	return connPipeConfig(s.Conn)
+}
+
+//Resize will change the window size of session connection if it is ResizeConn
+func (s *SessionConn) Resize(rows, cols int) (err error) {
+	resizer, ok := s.Conn.(ResizeConn)
+	if !ok {
+		err = fmt.Errorf("%v is not supported resize", s.Session)
+		return
+	}
+	err = resizer.Resize(rows, cols)
+	return
+}
+
+//Close the connection and remove session
+func (s *SessionConn) Close() (err error) {
+	err = s.Conn.Close()
+	s.Session.done()
+	return
+}
+
+type sessionRWC struct {
+	io.ReadWriteCloser
+	session *Session
+}
+
+//Read from raw connection and limit the rate by shared up limiters of session.
+func (s *sessionRWC) Read(p []byte) (n int, err error) {
+	up, _ := s.session.limiters()
+	if len(up) < 1 {
+		n, err = s.ReadWriteCloser.Read(p)
+		return
+	}
+	n, err = s.ReadWriteCloser.Read(p[:up.chunk(len(p))])
+	up.wait(n)
+	return
+}
+
+//Write to raw connection and limit the rate by shared down limiters of session.
+func (s *sessionRWC) Write(p []byte) (n int, err error) {
+	_, down := s.session.limiters()
+	n, err = down.write(s.ReadWriteCloser, p, func(int) {})
+	return
+}
+
+func (s *sessionRWC) CloseWrite() error {
+	return closeWrite(s.ReadWriteCloser)
+}
+
+func (s *sessionRWC) Close() (err error) {
+	err = s.ReadWriteCloser.Close()
+	s.session.done()
+	return
+}
+
+type sessionList []*Session
+
+func (s sessionList) Len() int {
+	return len(s)
+}
+
+func (s sessionList) Less(i, j int) bool {
+	return s[i].Begin.Before(s[j].Begin)
+}
+
+func (s sessionList) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (p *Pool) newSession(sid uint64, uri string, pipe io.ReadWriteCloser, limit *RateLimit) (session *Session, spipe io.ReadWriteCloser) {
+	session = &Session{
+		SID:      sid,
+		URI:      uri,
+		Begin:    time.Now(),
+		pipe:     pipe,
+		pool:     p,
+		limitLck: sync.RWMutex{},
+		pipeLck:  sync.RWMutex{},
+	}
+	session.share(limit)
+	if pipe != nil {
+		spipe = &sessionRWC{ReadWriteCloser: pipe, session: session}
+	}
+	return
+}
+
+func (p *Pool) addSession(session *Session) {
+	p.sessionsLck.Lock()
+	defer p.sessionsLck.Unlock()
+	if atomic.LoadUint32(&session.closed) == 1 {
+		return
+	}
+	if p.sessions == nil {
+		p.sessions = map[uint64][]*Session{}
+	}
+	p.sessions[session.SID] = append(p.sessions[session.SID], session)
+}
+
+func (p *Pool) removeSession(session *Session) {
+	p.sessionsLck.Lock()
+	var having []*Session
+	for _, s := range p.sessions[session.SID] {
+		if s != session {
+			having = append(having, s)
+		}
+	}
+	if len(having) > 0 {
+		p.sessions[session.SID] = having
+	} else {
+		delete(p.sessions, session.SID)
+	}
+	p.sessionsLck.Unlock()
+	p.checkDrained(session.dialer)
+}
+
+//Sessions will return all live sessions sorted by begin time.
+func (p *Pool) Sessions() (sessions []*Session) {
+	p.sessionsLck.RLock()
+	for _, having := range p.sessions {
+		sessions = append(sessions, having...)
+	}
+	p.sessionsLck.RUnlock()
+	sort.Sort(sessionList(sessions))
+	return
+}
+
+//Session will return the live session by sid, it return the last dialed one if the sid is used by many sessions, or nil if not found.
+func (p *Pool) Session(sid uint64) (session *Session) {
+	p.sessionsLck.RLock()
+	if having := p.sessions[sid]; len(having) > 0 {
+		session = having[len(having)-1]
+	}
+	p.sessionsLck.RUnlock()
+	return
+}
+
+//SessionsBySID will return all live sessions by sid sorted by dialed order.
+func (p *Pool) SessionsBySID(sid uint64) (sessions []*Session) {
+	p.sessionsLck.RLock()
+	sessions = append(sessions, p.sessions[sid]...)
+	p.sessionsLck.RUnlock()
+	return
+}
+
+//SetRate will change the up and down bytes per second of all live sessions by sid, zero is not limited.
+func (p *Pool) SetRate(sid uint64, up, down int64) (err error) {
+	sessions := p.SessionsBySID(sid)
+	if len(sessions) < 1 {
+		err = fmt.Errorf("session(%v) is not found", sid)
+		return
+	}
+	for _, session := range sessions {
+		if serr := session.SetRate(up, down); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return
+}
+
+//Kill will close all live sessions by sid.
+func (p *Pool) Kill(sid uint64) (err error) {
+	sessions := p.SessionsBySID(sid)
+	if len(sessions) < 1 {
+		err = fmt.Errorf("session(%v) is not found", sid)
+		return
+	}
+	for _, session := range sessions {
+		if serr := session.Kill(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package dialer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	pool := NewPool()
+	pool.AddDialer(NewEchoDialer())
+	//test piped session
+	local, remote := net.Pipe()
+	_, err := pool.Dial(1, "tcp://echo", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	session := pool.Session(1)
+	if session == nil || session.Dialer != "echo" || session.URI != "tcp://echo" {
+		t.Error(session)
+		return
+	}
+	fmt.Fprintf(local, "abc")
+	buf := make([]byte, 1024)
+	n, err := local.Read(buf)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Error(err)
+		return
+	}
+	err = pool.Kill(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = local.Read(buf)
+	if err != io.EOF && err != io.ErrClosedPipe {
+		t.Error(err)
+		return
+	}
+	if pool.Session(1) != nil {
+		t.Error("error")
+		return
+	}
+	//test closed by local
+	local, remote = net.Pipe()
+	_, err = pool.Dial(2, "tcp://echo", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := pool.Dial(3, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sessions := pool.Sessions()
+	if len(sessions) != 2 || sessions[0].SID != 2 || sessions[1].SID != 3 {
+		t.Error(sessions)
+		return
+	}
+	fmt.Println(sessions)
+	local.Close()
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	if len(pool.Sessions()) != 0 {
+		t.Error(pool.Sessions())
+		return
+	}
+	//test pipe after dial
+	local, remote = net.Pipe()
+	conn, err = pool.Dial(4, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Pipe(remote)
+	local.Close()
+	time.Sleep(100 * time.Millisecond)
+	if pool.Session(4) != nil {
+		t.Error("error")
+		return
+	}
+	//test many sessions by same sid
+	conn5, err := pool.Dial(5, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err = pool.Dial(5, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(pool.Sessions()) != 2 || len(pool.SessionsBySID(5)) != 2 || pool.Session(5) != pool.SessionsBySID(5)[1] {
+		t.Error(pool.Sessions())
+		return
+	}
+	conn5.Close()
+	time.Sleep(100 * time.Millisecond)
+	if sessions := pool.SessionsBySID(5); len(sessions) != 1 || pool.Session(5) != sessions[0] {
+		t.Error(sessions)
+		return
+	}
+	_, err = pool.Dial(5, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = pool.Kill(5)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(pool.Sessions()) != 0 {
+		t.Error(pool.Sessions())
+		return
+	}
+	//
+	//test error
+	err = pool.Kill(100)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
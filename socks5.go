@@ -1,6 +1,7 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -8,6 +9,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/Centny/gwf/log"
 	"github.com/Centny/gwf/util"
@@ -50,12 +52,20 @@ func (s StringAddressPooler) Done(address, uri string, err error) {
 
 //SocksProxyDialer is an implementation of the Dialer interface for dial by socks proxy.
 type SocksProxyDialer struct {
-	ID      string
-	Pooler  SocksProxyAddressPooler
-	matcher *regexp.Regexp
-	conf    util.Map
+	ID       string
+	Pooler   SocksProxyAddressPooler
+	matcher  *regexp.Regexp
+	conf     util.Map
+	shutdown uint32
 }
 
+//SocksProxyDialerSchema is the config schema of SocksProxyDialer
+var SocksProxyDialerSchema = NewSchema(
+	&SchemaField{Name: "id", Type: FieldString, Required: true, Description: "the dialer name"},
+	&SchemaField{Name: "address", Type: FieldString, Description: "the socks5 server address list split by comma"},
+	&SchemaField{Name: "matcher", Type: FieldString, Default: "^.*:[0-9]+$", Description: "the regexp to match uri host"},
+).Merge(PipeSchema)
+
 //NewSocksProxyDialer will return new SocksProxyDialer
 func NewSocksProxyDialer() *SocksProxyDialer {
 	return &SocksProxyDialer{
@@ -75,21 +85,33 @@ func (s *SocksProxyDialer) Bootstrap(options util.Map) (err error) {
 	if len(s.ID) < 1 {
 		return fmt.Errorf("the dialer id is required")
 	}
-	if options != nil {
-		s.Pooler = StringAddressPooler(options.StrVal("address"))
+	err = SocksProxyDialerSchema.Validate("", options, false)
+	if err != nil {
+		return
 	}
-	s.conf = options
 	matcher := options.StrVal("matcher")
 	if len(matcher) > 0 {
 		s.matcher, err = regexp.Compile(matcher)
+		if err != nil {
+			err = fmt.Errorf("compile matcher(%v) fail with %v", matcher, err)
+			return
+		}
 	}
-	return nil
+	s.Pooler = StringAddressPooler(options.StrVal("address"))
+	s.conf = options
+	return
 }
 
 func (s *SocksProxyDialer) Options() util.Map {
 	return s.conf
 }
 
+//Shutdown will refuse the new dial, the dialed connections are closed by their owner.
+func (s *SocksProxyDialer) Shutdown(ctx context.Context) (err error) {
+	atomic.StoreUint32(&s.shutdown, 1)
+	return
+}
+
 //Matched will return whether the uri is invalid tcp uri.
 func (s *SocksProxyDialer) Matched(uri string) bool {
 	remote, err := url.Parse(uri)
@@ -98,10 +120,24 @@ func (s *SocksProxyDialer) Matched(uri string) bool {
 
 //Dial one connection by uri
 func (s *SocksProxyDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	raw, err = s.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext one connection by uri with context, the context is used both on dialing proxy server and handshake.
+func (s *SocksProxyDialer) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	if atomic.LoadUint32(&s.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
 	remote, err := url.Parse(uri)
 	if err != nil {
 		return
 	}
+	config, err := NewPipeConfig(s.conf, remote.Query())
+	if err != nil {
+		return
+	}
 	parts := strings.SplitN(remote.Host, ":", 2)
 	if len(parts) < 2 {
 		err = fmt.Errorf("not supported address:%v", remote.Host)
@@ -118,30 +154,52 @@ func (s *SocksProxyDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser)
 		return
 	}
 	var doneErr error
-	defer s.Pooler.Done(address, uri, doneErr)
+	defer func() {
+		s.Pooler.Done(address, uri, doneErr)
+	}()
 	log.D("SocksProxyDialer dial to %v", address)
-	conn, err := net.Dial("tcp", address)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
 	if err != nil {
-		doneErr = &CodeError{Inner: err, ByteCode: 0x10}
+		doneErr = &CodeError{Inner: err, ByteCode: CodeProxy}
 		return
 	}
-	_, err = conn.Write([]byte{0x05, 0x01, 0x00})
+	stop := watchContext(ctx, conn)
+	doneErr, err = s.handshake(conn, host, port)
+	if stop() {
+		err = ctx.Err()
+	}
 	if err != nil {
 		conn.Close()
-		doneErr = &CodeError{Inner: err, ByteCode: 0x10}
+		return
+	}
+	pipable := NewCopyPipable(conn)
+	pipable.Config = config
+	raw = pipable
+	if pipe != nil {
+		err = raw.Pipe(pipe)
+	}
+	if err != nil {
+		conn.Close()
+	}
+	return
+}
+
+func (s *SocksProxyDialer) handshake(conn net.Conn, host string, port int64) (doneErr, err error) {
+	_, err = conn.Write([]byte{0x05, 0x01, 0x00})
+	if err != nil {
+		doneErr = &CodeError{Inner: err, ByteCode: CodeProxy}
 		return
 	}
 	buf := make([]byte, 1024*64)
 	err = fullBuf(conn, buf, 2, nil)
 	if err != nil {
-		conn.Close()
-		doneErr = &CodeError{Inner: err, ByteCode: 0x10}
+		doneErr = &CodeError{Inner: err, ByteCode: CodeProxy}
 		return
 	}
 	if buf[0] != 0x05 || buf[1] != 0x00 {
 		err = fmt.Errorf("unsupported %x", buf)
-		conn.Close()
-		doneErr = &CodeError{Inner: err, ByteCode: 0x10}
+		doneErr = &CodeError{Inner: err, ByteCode: CodeProxy}
 		return
 	}
 	blen := len(host) + 7
@@ -152,14 +210,12 @@ func (s *SocksProxyDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser)
 	buf[blen-1] = byte(port % 256)
 	_, err = conn.Write(buf[:blen])
 	if err != nil {
-		conn.Close()
-		doneErr = &CodeError{Inner: err, ByteCode: 0x10}
+		doneErr = &CodeError{Inner: err, ByteCode: CodeProxy}
 		return
 	}
 	err = fullBuf(conn, buf, 5, nil)
 	if err != nil {
-		conn.Close()
-		doneErr = &CodeError{Inner: err, ByteCode: 0x10}
+		doneErr = &CodeError{Inner: err, ByteCode: CodeProxy}
 		return
 	}
 	switch buf[3] {
@@ -173,25 +229,16 @@ func (s *SocksProxyDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser)
 		err = fmt.Errorf("reply address type is not supported:%v", buf[3])
 	}
 	if err != nil {
-		conn.Close()
-		doneErr = &CodeError{Inner: err, ByteCode: 0x10}
+		doneErr = &CodeError{Inner: err, ByteCode: CodeProxy}
 		return
 	}
 	if buf[1] != 0x00 {
-		conn.Close()
 		err = fmt.Errorf("response code(%x)", buf[1])
 		if buf[1] >= 0x10 {
-			doneErr = &CodeError{Inner: err, ByteCode: 0x10}
+			doneErr = &CodeError{Inner: err, ByteCode: CodeProxy}
 		}
 		return
 	}
-	raw = NewCopyPipable(conn)
-	if pipe != nil {
-		err = raw.Pipe(pipe)
-	}
-	if err != nil {
-		conn.Close()
-	}
 	return
 }
 
@@ -1,8 +1,11 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/Centny/gwf/util"
 )
@@ -36,3 +39,35 @@ func TestSocksProxy(t *testing.T) {
 	}
 	fmt.Printf("-->%v\n", dailer)
 }
+
+func TestSocksProxyContext(t *testing.T) {
+	//the proxy server accept connection but never response.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				break
+			}
+			defer conn.Close()
+		}
+	}()
+	dailer := NewSocksProxyDialer()
+	dailer.Bootstrap(util.Map{
+		"id":      "testing",
+		"address": l.Addr().String(),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err = dailer.DialContext(ctx, 100, "tcp://www.google.com:80", nil)
+	if err != context.DeadlineExceeded || time.Since(begin) > time.Second {
+		t.Errorf("%v,%v", err, time.Since(begin))
+		return
+	}
+}
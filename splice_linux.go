@@ -0,0 +1,59 @@
+package dialer
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+//spliceChunk is the max bytes of one splice, the stats is updated after each chunk.
+const spliceChunk = 256 * 1024
+
+//spliceSupported is whether splice is supported on this platform.
+const spliceSupported = true
+
+//spliceCopy will copy src to dst by splice in kernel when both side is *net.TCPConn,
+//it is not used when idle timeout is set or any rate limiter is limited, because they need accounting on each write.
+//it return handled false when splice is not used or the rate is limited on runtime, then the rest data should be copied by buffer.
+func spliceCopy(dst, src io.ReadWriteCloser, writer *countWriter, idle bool) (handled bool, err error) {
+	if atomic.LoadUint32(&spliceDisabled) == 1 || idle {
+		return
+	}
+	dstConn, dstLimiters := unwrapTCPConn(dst)
+	srcConn, srcLimiters := unwrapTCPConn(src)
+	if dstConn == nil || srcConn == nil {
+		return
+	}
+	limiters := append(append(append(rateLimiters{}, writer.limiters...), dstLimiters...), srcLimiters...)
+	for !limiters.active() {
+		var n int64
+		n, err = dstConn.ReadFrom(&io.LimitedReader{R: srcConn, N: spliceChunk})
+		if n > 0 {
+			writer.done(int(n))
+		}
+		if err != nil || n < 1 {
+			handled = true
+			return
+		}
+	}
+	return
+}
+
+//unwrapTCPConn will return the *net.TCPConn wrapped by rwc and the rate limiters of wrapper, it return nil if not found.
+func unwrapTCPConn(rwc io.ReadWriteCloser) (conn *net.TCPConn, limiters rateLimiters) {
+	for {
+		switch c := rwc.(type) {
+		case *net.TCPConn:
+			conn = c
+			return
+		case *CopyPipable:
+			rwc = c.ReadWriteCloser
+		case *sessionRWC:
+			up, down := c.session.limiters()
+			limiters = append(append(limiters, up...), down...)
+			rwc = c.ReadWriteCloser
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package dialer
+
+import "io"
+
+//spliceSupported is whether splice is supported on this platform.
+const spliceSupported = false
+
+//spliceCopy is not supported on this platform, it always return handled false.
+func spliceCopy(dst, src io.ReadWriteCloser, writer *countWriter, idle bool) (handled bool, err error) {
+	return
+}
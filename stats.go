@@ -0,0 +1,235 @@
+package dialer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//ConnStats is the byte and duration accounting of one piped connection.
+type ConnStats struct {
+	Up     int64     //the bytes from local to remote
+	Down   int64     //the bytes from remote to local
+	Begin  time.Time //the begin time
+	End    time.Time //the end time, it is zero when connection is not closed
+	Reason string    //the close reason
+	lck    sync.RWMutex
+	watch  []func(stats *ConnStats)
+}
+
+//NewConnStats will return new ConnStats which begin on now
+func NewConnStats() *ConnStats {
+	return &ConnStats{
+		Begin: time.Now(),
+		lck:   sync.RWMutex{},
+	}
+}
+
+//StatsConn is the interface to get the ConnStats of Conn
+type StatsConn interface {
+	Stats() *ConnStats
+}
+
+//AddUp will add the bytes from local to remote
+func (c *ConnStats) AddUp(n int64) {
+	atomic.AddInt64(&c.Up, n)
+}
+
+//AddDown will add the bytes from remote to local
+func (c *ConnStats) AddDown(n int64) {
+	atomic.AddInt64(&c.Down, n)
+}
+
+//SetReason will set the close reason if it is not set.
+func (c *ConnStats) SetReason(reason string) {
+	c.lck.Lock()
+	if len(c.Reason) < 1 {
+		c.Reason = reason
+	}
+	c.lck.Unlock()
+}
+
+//Done will mark connection is closed, the reason is used when it is not set, it return false if it is done.
+func (c *ConnStats) Done(reason string) bool {
+	c.lck.Lock()
+	if !c.End.IsZero() {
+		c.lck.Unlock()
+		return false
+	}
+	c.End = time.Now()
+	if len(c.Reason) < 1 {
+		c.Reason = reason
+	}
+	watch := c.watch
+	c.watch = nil
+	c.lck.Unlock()
+	for _, w := range watch {
+		w(c)
+	}
+	return true
+}
+
+//OnDone will add the watcher which is called when connection is done, it will be called directly if it is done.
+func (c *ConnStats) OnDone(watcher func(stats *ConnStats)) {
+	c.lck.Lock()
+	if c.End.IsZero() {
+		c.watch = append(c.watch, watcher)
+		c.lck.Unlock()
+		return
+	}
+	c.lck.Unlock()
+	watcher(c)
+}
+
+//Duration will return the time from begin to end or now
+func (c *ConnStats) Duration() time.Duration {
+	c.lck.RLock()
+	defer c.lck.RUnlock()
+	if c.End.IsZero() {
+		return time.Since(c.Begin)
+	}
+	return c.End.Sub(c.Begin)
+}
+
+//Snapshot will return the copy of current stats
+func (c *ConnStats) Snapshot() *ConnStats {
+	c.lck.RLock()
+	defer c.lck.RUnlock()
+	return &ConnStats{
+		Up:     atomic.LoadInt64(&c.Up),
+		Down:   atomic.LoadInt64(&c.Down),
+		Begin:  c.Begin,
+		End:    c.End,
+		Reason: c.Reason,
+	}
+}
+
+func (c *ConnStats) String() string {
+	return fmt.Sprintf("up:%v,down:%v,duration:%v,reason:%v", atomic.LoadInt64(&c.Up), atomic.LoadInt64(&c.Down), c.Duration(), c.Reason)
+}
+
+//connStats will return the ConnStats of conn, it return nil if conn is not StatsConn
+func connStats(conn Conn) *ConnStats {
+	if sconn, ok := conn.(StatsConn); ok {
+		return sconn.Stats()
+	}
+	return nil
+}
+
+//LatencyBuckets is the upper bounds of dial latency histogram.
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+//DialerStats is the accounting of one dialer
+type DialerStats struct {
+	Name       string
+	Dials      int64            //the dial count
+	Success    int64            //the dial success count
+	Fails      map[string]int64 //the dial fail count by error class
+	Active     int64            //the active session count
+	Sessions   int64            //the closed session count
+	Up         int64            //the bytes from local to remote of all closed sessions
+	Down       int64            //the bytes from remote to local of all closed sessions
+	LiveUp     int64            //the bytes from local to remote of active sessions, it is only set on snapshot
+	LiveDown   int64            //the bytes from remote to local of active sessions, it is only set on snapshot
+	Duration   time.Duration    //the duration of all closed sessions
+	Latency    []int64          //the dial count by latency which is less or equal to LatencyBuckets, not cumulative
+	LatencySum time.Duration    //the latency of all dials
+}
+
+//statsTable is the accounting table of dialers
+type statsTable struct {
+	dialers map[string]*DialerStats
+	live    map[*ConnStats]string //the dialer name of active session
+	lck     sync.RWMutex
+}
+
+func (s *statsTable) dialerStats(name string) (stats *DialerStats) {
+	if s.dialers == nil {
+		s.dialers = map[string]*DialerStats{}
+	}
+	stats = s.dialers[name]
+	if stats == nil {
+		stats = &DialerStats{
+			Name:    name,
+			Fails:   map[string]int64{},
+			Latency: make([]int64, len(LatencyBuckets)),
+		}
+		s.dialers[name] = stats
+	}
+	return
+}
+
+//dialed will record the dial result on dialer, the connection stats will roll up to dialer when it is done.
+func (s *statsTable) dialed(name string, latency time.Duration, err error, cstats *ConnStats) {
+	s.lck.Lock()
+	stats := s.dialerStats(name)
+	stats.Dials++
+	stats.LatencySum += latency
+	for i, bucket := range LatencyBuckets {
+		if latency <= bucket {
+			stats.Latency[i]++
+			break
+		}
+	}
+	if err != nil {
+		stats.Fails[ClassifyError(err)]++
+		s.lck.Unlock()
+		return
+	}
+	stats.Success++
+	if cstats == nil {
+		s.lck.Unlock()
+		return
+	}
+	stats.Active++
+	if s.live == nil {
+		s.live = map[*ConnStats]string{}
+	}
+	s.live[cstats] = name
+	s.lck.Unlock()
+	//the watcher is called directly if connection is done, like the reused connection, so it must be added out of lock
+	cstats.OnDone(func(cstats *ConnStats) {
+		s.lck.Lock()
+		defer s.lck.Unlock()
+		//the bytes is moved from live to closed in one lock, so Up+LiveUp of snapshot is never dropped
+		delete(s.live, cstats)
+		stats.Active--
+		stats.Sessions++
+		stats.Up += atomic.LoadInt64(&cstats.Up)
+		stats.Down += atomic.LoadInt64(&cstats.Down)
+		stats.Duration += cstats.Duration()
+	})
+}
+
+//Snapshot will return the copy of all dialer stats sorted by name, the bytes of active sessions is set to LiveUp/LiveDown.
+func (s *statsTable) Snapshot() (all []*DialerStats) {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+	var names []string
+	for name := range s.dialers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	copied := map[string]*DialerStats{}
+	for _, name := range names {
+		stats := *s.dialers[name]
+		stats.Latency = append([]int64{}, stats.Latency...)
+		stats.Fails = map[string]int64{}
+		for class, count := range s.dialers[name].Fails {
+			stats.Fails[class] = count
+		}
+		copied[name] = &stats
+		all = append(all, &stats)
+	}
+	for cstats, name := range s.live {
+		copied[name].LiveUp += atomic.LoadInt64(&cstats.Up)
+		copied[name].LiveDown += atomic.LoadInt64(&cstats.Down)
+	}
+	return
+}
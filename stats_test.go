@@ -0,0 +1,103 @@
+package dialer
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Centny/gwf/util"
+)
+
+func TestConnStats(t *testing.T) {
+	stats := NewConnStats()
+	stats.AddUp(10)
+	stats.AddDown(100)
+	watched := 0
+	stats.OnDone(func(s *ConnStats) {
+		watched++
+	})
+	if !stats.Done("testing") || stats.Done("testing2") {
+		t.Error("error")
+		return
+	}
+	stats.OnDone(func(s *ConnStats) {
+		watched++
+	})
+	snapshot := stats.Snapshot()
+	if watched != 2 || snapshot.Up != 10 || snapshot.Down != 100 || snapshot.Reason != "testing" || stats.Duration() < 0 {
+		t.Error(snapshot)
+		return
+	}
+	fmt.Println(stats)
+}
+
+func TestPoolStats(t *testing.T) {
+	balanced := NewBalancedDialer()
+	balanced.ID = "b0"
+	balanced.matcher = regexp.MustCompile("^tcp://echo$")
+	balanced.AddDialer(NewEchoDialer())
+	pool := NewPool()
+	pool.AddDialer(balanced, &FailDialer{OnceDialer{ID: "fail", conf: util.Map{}}})
+	pool.Fallback = []string{"*"}
+	//
+	local, remote := net.Pipe()
+	conn, err := pool.Dial(1, "tcp://echo", remote)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(local, "abc")
+	buf := make([]byte, 1024)
+	local.Read(buf)
+	session := pool.Session(1)
+	if session.Stats.Snapshot().Up != 3 {
+		t.Error(session.Stats)
+		return
+	}
+	if all := pool.Stats(); all[0].Up != 0 || all[0].LiveUp != 3 {
+		t.Error(util.S2Json(all))
+		return
+	}
+	local.Close()
+	time.Sleep(100 * time.Millisecond)
+	cstats := conn.(StatsConn).Stats().Snapshot()
+	if cstats.Up != 3 || cstats.Down != 3 || cstats.Reason != "local closed" {
+		t.Error(cstats)
+		return
+	}
+	//
+	_, err = pool.Dial(2, "tcp://none", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	all := pool.Stats()
+	if len(all) != 2 || all[0].Name != "b0" || all[0].Success != 1 || all[0].Up != 3 || all[0].Down != 3 || all[0].LiveUp != 0 ||
+		all[0].Sessions != 1 || all[0].Active != 0 || all[1].Fails[ErrClassOther] != 1 {
+		t.Error(util.S2Json(all))
+		return
+	}
+	children := balanced.Stats()
+	if len(children) != 1 || children[0].Name != "echo" || children[0].Up != 3 {
+		t.Error(util.S2Json(children))
+		return
+	}
+	//
+	conn, err = pool.Dial(3, "tcp://echo", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if all = pool.Stats(); all[0].Active != 1 {
+		t.Error(util.S2Json(all))
+		return
+	}
+	pool.Kill(3)
+	time.Sleep(10 * time.Millisecond)
+	if all = pool.Stats(); all[0].Active != 0 || conn.(StatsConn).Stats().Reason != "killed" {
+		t.Error(util.S2Json(all))
+		return
+	}
+}
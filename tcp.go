@@ -1,10 +1,12 @@
 package dialer
 
 import (
+	"context"
 	"io"
 	"net"
 	"net/url"
 	"regexp"
+	"sync/atomic"
 
 	"github.com/Centny/gwf/util"
 )
@@ -13,8 +15,14 @@ import (
 type TCPDialer struct {
 	portMatcher *regexp.Regexp
 	conf        util.Map
+	shutdown    uint32
 }
 
+//TCPDialerSchema is the config schema of TCPDialer
+var TCPDialerSchema = NewSchema(
+	&SchemaField{Name: "bind", Type: FieldString, Description: "the local address to bind when dialing"},
+).Merge(PipeSchema)
+
 //NewTCPDialer will return new TCPDialer
 func NewTCPDialer() *TCPDialer {
 	return &TCPDialer{
@@ -29,15 +37,24 @@ func (t *TCPDialer) Name() string {
 }
 
 //Bootstrap the dialer.
-func (t *TCPDialer) Bootstrap(options util.Map) error {
-	t.conf = options
-	return nil
+func (t *TCPDialer) Bootstrap(options util.Map) (err error) {
+	err = TCPDialerSchema.Validate("", options, false)
+	if err == nil {
+		t.conf = options
+	}
+	return
 }
 
 func (t *TCPDialer) Options() util.Map {
 	return t.conf
 }
 
+//Shutdown will refuse the new dial, the dialed connections are closed by their owner.
+func (t *TCPDialer) Shutdown(ctx context.Context) (err error) {
+	atomic.StoreUint32(&t.shutdown, 1)
+	return
+}
+
 //Matched will return whether the uri is invalid tcp uri.
 func (t *TCPDialer) Matched(uri string) bool {
 	_, err := url.Parse(uri)
@@ -46,9 +63,24 @@ func (t *TCPDialer) Matched(uri string) bool {
 
 //Dial one connection by uri
 func (t *TCPDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	raw, err = t.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext one connection by uri with context
+func (t *TCPDialer) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	if atomic.LoadUint32(&t.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
 	remote, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	config, err := NewPipeConfig(t.conf, remote.Query())
 	if err == nil {
 		var dialer net.Dialer
+		dialer.Control = aclControl(ctx, uri)
 		bind := remote.Query().Get("bind")
 		if len(bind) < 1 && t.conf != nil {
 			bind = t.conf.StrVal("bind")
@@ -72,9 +104,11 @@ func (t *TCPDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw C
 			}
 		}
 		var basic net.Conn
-		basic, err = dialer.Dial("tcp", host)
+		basic, err = dialer.DialContext(ctx, "tcp", host)
 		if err == nil {
-			raw = NewCopyPipable(basic)
+			pipable := NewCopyPipable(basic)
+			pipable.Config = config
+			raw = pipable
 			if pipe != nil {
 				err = raw.Pipe(pipe)
 			}
@@ -1,7 +1,11 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
 	"testing"
 )
 
@@ -41,4 +45,76 @@ func TestTCPDialer(t *testing.T) {
 		t.Error(err)
 		return
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = tcp.DialContext(ctx, 10, "http://localhost", nil)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+//benchmarkTCPPipe will pipe concurrent sessions by TCPDialer to reply server, each session sends b.N chunks.
+func benchmarkTCPPipe(b *testing.B, sessions int, splice bool) {
+	enableSplice(splice)
+	defer func() {
+		enableSplice(true)
+	}()
+	l, err := runReplyServer()
+	if err != nil {
+		b.Error(err)
+		return
+	}
+	defer l.Close()
+	tcp := NewTCPDialer()
+	tcp.Bootstrap(nil)
+	locals := []*net.TCPConn{}
+	for i := 0; i < sessions; i++ {
+		local, raw, err := tcpPair()
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		_, err = tcp.Dial(uint64(i), "tcp://"+l.Addr().String(), raw)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		locals = append(locals, local)
+	}
+	chunk := make([]byte, 32*1024)
+	b.SetBytes(int64(len(chunk) * sessions))
+	b.ReportAllocs()
+	b.ResetTimer()
+	wg := sync.WaitGroup{}
+	for _, local := range locals {
+		wg.Add(1)
+		go func(local *net.TCPConn) {
+			defer wg.Done()
+			for i := 0; i < b.N; i++ {
+				local.Write(chunk)
+			}
+			local.CloseWrite()
+			data, _ := ioutil.ReadAll(local)
+			if string(data) != fmt.Sprintf("got:%v", b.N*len(chunk)) {
+				b.Error(string(data))
+			}
+			local.Close()
+		}(local)
+	}
+	wg.Wait()
+}
+
+func BenchmarkTCPPipe(b *testing.B) {
+	for _, sessions := range []int{1, 100, 1000} {
+		for _, splice := range []bool{false, spliceSupported} {
+			name := fmt.Sprintf("buffer-%v", sessions)
+			if splice {
+				name = fmt.Sprintf("splice-%v", sessions)
+			}
+			b.Run(name, func(b *testing.B) {
+				benchmarkTCPPipe(b, sessions, splice)
+			})
+		}
+	}
 }
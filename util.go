@@ -1,9 +1,13 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -186,3 +190,96 @@ func fullBuf(r io.Reader, p []byte, length uint32, last *int64) error {
 	}
 	return nil
 }
+
+//watchContext will close the closer when the context is done before stop is called,
+//the stop will return true if the closer is closed by context.
+func watchContext(ctx context.Context, closer io.Closer) (stop func() bool) {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	stopped := make(chan int)
+	fired := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			closer.Close()
+			fired <- true
+		case <-stopped:
+			fired <- false
+		}
+	}()
+	return func() bool {
+		close(stopped)
+		return <-fired
+	}
+}
+
+//uriHostname will return the lowercased hostname of uri without the trailing dot,
+//so the host like EXAMPLE.com. is matched as example.com.
+func uriHostname(target *url.URL) string {
+	return strings.TrimSuffix(strings.ToLower(target.Hostname()), ".")
+}
+
+//matchGlob will return whether the value is matched by pattern, the '*' in pattern is matched any sequence
+//and the '?' is matched any single character.
+//it is matching by two pointers, when mismatched, it backtracks to the last '*' and lets it match one more character.
+func matchGlob(pattern, value string) bool {
+	p, v := 0, 0
+	star, mark := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, v
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case star >= 0:
+			mark++
+			p, v = star+1, mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+//splitAddress will return the network and address, the unix socket address is like unix:/tmp/x.sock
+func splitAddress(address string) (network, addr string) {
+	if strings.HasPrefix(address, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(address, "unix:")
+	} else {
+		network, addr = "tcp", address
+	}
+	return
+}
+
+//ListenAddress will listen on the tcp or unix socket address, the unix socket address is like unix:/tmp/x.sock,
+//the stale unix socket file is removed before listening, but the other file on address is kept.
+func ListenAddress(address string) (l net.Listener, err error) {
+	network, addr := splitAddress(address)
+	if network == "unix" {
+		if info, serr := os.Lstat(addr); serr == nil && info.Mode()&os.ModeSocket != 0 {
+			//only remove the stale socket which is not served by other
+			conn, derr := net.Dial("unix", addr)
+			if derr == nil {
+				conn.Close()
+			} else if ClassifyError(derr) == ErrClassRefused {
+				os.Remove(addr)
+			}
+		}
+	}
+	l, err = net.Listen(network, addr)
+	return
+}
+
+//dialAddress will dial the tcp or unix socket address by context
+func dialAddress(ctx context.Context, address string) (conn net.Conn, err error) {
+	var dialer net.Dialer
+	network, addr := splitAddress(address)
+	conn, err = dialer.DialContext(ctx, network, addr)
+	return
+}
@@ -0,0 +1,49 @@
+package dialer
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "listen")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	address := "unix:" + filepath.Join(dir, "x.sock")
+	l1, err := ListenAddress(address)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//the socket is served
+	_, err = ListenAddress(address)
+	if err == nil {
+		t.Error("error")
+		return
+	}
+	conn, err := net.Dial("unix", filepath.Join(dir, "x.sock"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	//the socket is stale
+	l1.(*net.UnixListener).SetUnlinkOnClose(false)
+	l1.Close()
+	if _, err = os.Lstat(filepath.Join(dir, "x.sock")); err != nil {
+		t.Error(err)
+		return
+	}
+	l2, err := ListenAddress(address)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	l2.Close()
+}
@@ -1,6 +1,7 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -8,6 +9,7 @@ import (
 	"net/url"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Centny/gwf/log"
@@ -17,18 +19,23 @@ import (
 
 //WebDialer is an implementation of the Dialer interface for dial to web server
 type WebDialer struct {
-	accept  chan net.Conn
-	consLck sync.RWMutex
-	cons    map[string]*WebDialerConn
-	davsLck sync.RWMutex
-	davs    map[string]*WebdavHandler
-	conf    util.Map
+	accept   chan net.Conn
+	stopped  chan int
+	served   chan int
+	server   *http.Server
+	shutdown uint32
+	consLck  sync.RWMutex
+	cons     map[string]*WebDialerConn
+	davsLck  sync.RWMutex
+	davs     map[string]*WebdavHandler
+	conf     util.Map
 }
 
 //NewWebDialer will return new WebDialer
 func NewWebDialer() (dialer *WebDialer) {
 	dialer = &WebDialer{
 		accept:  make(chan net.Conn, 10),
+		stopped: make(chan int),
 		consLck: sync.RWMutex{},
 		cons:    map[string]*WebDialerConn{},
 		davsLck: sync.RWMutex{},
@@ -43,11 +50,26 @@ func (web *WebDialer) Name() string {
 	return "web"
 }
 
+//WebDialerSchema is the config schema of WebDialer
+var WebDialerSchema = PipeSchema.Merge()
+
 //Bootstrap the web dialer
 func (web *WebDialer) Bootstrap(options util.Map) error {
+	err := WebDialerSchema.Validate("", options, false)
+	if err != nil {
+		return err
+	}
+	if options != nil {
+		web.conf = options
+	}
+	if web.server != nil {
+		return nil
+	}
+	web.server = &http.Server{Handler: web}
+	web.served = make(chan int)
 	go func() {
-		http.Serve(web, web)
-		close(web.accept)
+		web.server.Serve(web)
+		close(web.served)
 	}()
 	return nil
 }
@@ -56,10 +78,31 @@ func (web *WebDialer) Options() util.Map {
 	return web.conf
 }
 
-//Shutdown the web dialer
-func (web *WebDialer) Shutdown() error {
-	web.accept <- nil
-	return nil
+//Shutdown will stop the web server and close all connections, the new dial is refused.
+//it is changed from Shutdown() to implement Shutdowner, the caller of old api should call Shutdown(context.Background()).
+func (web *WebDialer) Shutdown(ctx context.Context) (err error) {
+	if !atomic.CompareAndSwapUint32(&web.shutdown, 0, 1) {
+		return
+	}
+	close(web.stopped)
+	web.consLck.Lock()
+	for cid, conn := range web.cons {
+		conn.Close()
+		delete(web.cons, cid)
+	}
+	web.consLck.Unlock()
+	if web.server == nil {
+		return
+	}
+	err = web.server.Shutdown(ctx)
+	if err == nil {
+		select {
+		case <-web.served:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	return
 }
 
 //Matched will return whether the uri is a invalid uri
@@ -70,15 +113,50 @@ func (web *WebDialer) Matched(uri string) bool {
 
 //Dial to web server
 func (web *WebDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	raw, err = web.DialContext(context.Background(), sid, uri, pipe)
+	return
+}
+
+//DialContext to web server with context
+func (web *WebDialer) DialContext(ctx context.Context, sid uint64, uri string, pipe io.ReadWriteCloser) (raw Conn, err error) {
+	if atomic.LoadUint32(&web.shutdown) == 1 {
+		err = ErrShutdown
+		return
+	}
+	target, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	config, err := NewPipeConfig(web.conf, target.Query())
+	if err != nil {
+		return
+	}
 	conn, basic, err := PipeWebDialerConn(sid, uri)
 	if err != nil {
 		return
 	}
+	cid := fmt.Sprintf("%v", sid)
 	web.consLck.Lock()
-	web.cons[fmt.Sprintf("%v", sid)] = conn
+	web.cons[cid] = conn
 	web.consLck.Unlock()
-	web.accept <- conn
-	raw = NewCopyPipable(basic)
+	select {
+	case web.accept <- conn:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-web.stopped:
+		err = ErrShutdown
+	}
+	if err != nil {
+		web.consLck.Lock()
+		delete(web.cons, cid)
+		web.consLck.Unlock()
+		conn.Close()
+		basic.Close()
+		return
+	}
+	pipable := NewCopyPipable(basic)
+	pipable.Config = config
+	raw = pipable
 	if pipe != nil {
 		err = raw.Pipe(pipe)
 		if err != nil {
@@ -91,7 +169,10 @@ func (web *WebDialer) Dial(sid uint64, uri string, pipe io.ReadWriteCloser) (raw
 
 //Accept one connection to process web server.
 func (web *WebDialer) Accept() (conn net.Conn, err error) {
-	conn = <-web.accept
+	select {
+	case conn = <-web.accept:
+	case <-web.stopped:
+	}
 	if conn == nil {
 		err = fmt.Errorf("WebDial is closed")
 	}
@@ -113,6 +194,11 @@ func (web *WebDialer) Network() string {
 	return "tcp"
 }
 
+//Local will return true, the web server is served on local host.
+func (web *WebDialer) Local() bool {
+	return true
+}
+
 func (web *WebDialer) String() string {
 	return "WebDialer(0:0)"
 }
@@ -255,6 +341,14 @@ func (p *PipedConn) Write(b []byte) (n int, err error) {
 	return
 }
 
+//CloseWrite will close the writer of piped connection, the other side will read EOF.
+func (p *PipedConn) CloseWrite() error {
+	if p.up {
+		return p.piped.UpWriter.Close()
+	}
+	return p.piped.DownWriter.Close()
+}
+
 //Close the piped connection
 func (p *PipedConn) Close() error {
 	return p.piped.Close()
@@ -1,6 +1,7 @@
 package dialer
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -57,7 +58,7 @@ func TestWebDialer(t *testing.T) {
 	}()
 	fmt.Println(util.HGet("http://localhost:2422/"))
 	fmt.Println(util.HPost("http://localhost:2422/", nil))
-	dialer.Shutdown()
+	dialer.Shutdown(context.Background())
 	time.Sleep(100 * time.Millisecond)
 	//for cover
 	fmt.Printf("%v,%v\n", dialer.Addr(), dialer.Network())